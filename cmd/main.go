@@ -11,31 +11,64 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // база зон внутри бинарника: в минимальных контейнерах нет /usr/share/zoneinfo
 
 	httpapi "ukrainian_call_center_scam_goev/internal/billing/handlers/http"
+	"ukrainian_call_center_scam_goev/internal/billing/ingest"
+	"ukrainian_call_center_scam_goev/internal/billing/notify"
 	memory2 "ukrainian_call_center_scam_goev/internal/billing/repo/memory"
 	billing "ukrainian_call_center_scam_goev/internal/billing/service"
+	"ukrainian_call_center_scam_goev/internal/config"
 	"ukrainian_call_center_scam_goev/web"
 )
 
 func main() {
-	addr := env("ADDR", ":8080")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "JSON config file (env variables override it)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath, os.LookupEnv)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+
+	addr := cfg.Addr
 
 	tariffRepo := memory2.NewTariffMemoryRepo()
 	subscriberRepo := memory2.NewSubscriberMemoryRepo()
+	attributionRepo := memory2.NewAttributionMemoryRepo()
+	balanceRepo := memory2.NewBalanceMemoryRepo()
 
 	// Service
-	svc := billing.New(tariffRepo, subscriberRepo, time.UTC, 2)
+	svc := billing.New(tariffRepo, subscriberRepo, attributionRepo, balanceRepo, cfg)
 	defer svc.Close()
 
+	log.Printf("config: %d CDR workers, queue %d, max %d concurrent runs",
+		cfg.CDRWorkers, cfg.CDRQueueSize, cfg.MaxConcurrentRuns)
+
+	if url := cfg.CreditWebhookURL; url != "" {
+		webhook := notify.NewWebhook(url, 5*time.Second)
+		defer webhook.Close()
+
+		svc.SetCreditAlertSink(webhook)
+	}
+
+	ingestCtx, stopIngest := context.WithCancel(context.Background())
+	ingestDone := startIngest(ingestCtx, svc, cfg.Ingest)
+
+	defer func() {
+		stopIngest()
+		<-ingestDone
+	}()
+
 	// HTTP handlers
-	h, err := httpapi.NewHandler(svc)
+	h, err := httpapi.NewHandler(svc, cfg)
 	if err != nil {
 		log.Fatalf("create HTTP handler: %v", err)
 	}
@@ -75,18 +108,52 @@ func main() {
 
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Printf("shutdown error: %v", err)
+	}
+
+	// фоновые задачи останавливаются до сервиса: с DATA_DIR они продолжатся после перезапуска
+	if err := h.Close(ctx); err != nil {
+		log.Printf("shutdown error: %v", err)
 	} else {
 		log.Println("shutdown: done")
 	}
 }
 
-func env(key, def string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		return def
+// startIngest starts the inbox watcher when ingest.dir is set. The returned channel is closed
+// when the watcher has stopped (immediately if it is disabled).
+func startIngest(ctx context.Context, svc *billing.Service, cfg config.IngestConfig) <-chan struct{} {
+	done := make(chan struct{})
+
+	if cfg.Dir == "" {
+		close(done)
+		return done
+	}
+
+	opt, err := httpapi.ParseTariffOptions(cfg.Options)
+	if err != nil {
+		log.Fatalf("ingest.options: %v", err)
+	}
+
+	if _, err := svc.Formats().Get(opt.Format); err != nil {
+		log.Fatalf("ingest.options: %v", err)
 	}
 
-	return v
+	inbox, err := ingest.New(ingest.Config{
+		Dir:          cfg.Dir,
+		OutputDir:    cfg.OutputDir,
+		PollInterval: time.Duration(cfg.Poll),
+		StableFor:    time.Duration(cfg.Stable),
+		Options:      opt,
+	}, svc, httpapi.WriteReportJSON)
+	if err != nil {
+		log.Fatalf("ingest: %v", err)
+	}
+
+	go func() {
+		defer close(done)
+		inbox.Run(ctx)
+	}()
+
+	return done
 }
 
 func withRequestLog(next http.Handler) http.Handler {
@@ -0,0 +1,235 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package cdrformat
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// Колонки cdr-csv Master.csv в стандартном порядке.
+const (
+	astAccountCode = iota
+	astSrc
+	astDst
+	astDContext
+	astCLID
+	astChannel
+	astDstChannel
+	astLastApp
+	astLastData
+	astStart
+	astAnswer
+	astEnd
+	astDuration
+	astBillSec
+	astDisposition
+	astAMAFlags
+	astUniqueID
+
+	astColumns
+)
+
+// ContextRule maps an Asterisk dialplan context to a call direction.
+// Context matches exactly, or as a prefix when it ends with "*".
+type ContextRule struct {
+	Context   string `json:"context"`
+	Direction string `json:"direction"` // incoming | outgoing | internal
+}
+
+// AsteriskConfig configures the Master.csv importer.
+type AsteriskConfig struct {
+	ContextRules []ContextRule `json:"context_rules"`
+
+	// DefaultDirection is used when no rule matches dcontext (empty means unknown).
+	DefaultDirection string `json:"default_direction,omitempty"`
+}
+
+// DefaultAsteriskConfig covers contexts of a stock FreePBX-like dialplan.
+func DefaultAsteriskConfig() AsteriskConfig {
+	return AsteriskConfig{
+		ContextRules: []ContextRule{
+			{Context: "from-internal", Direction: "outgoing"},
+			{Context: "from-trunk*", Direction: "incoming"},
+			{Context: "from-pstn*", Direction: "incoming"},
+			{Context: "from-did*", Direction: "incoming"},
+			{Context: "ext-local", Direction: "internal"},
+		},
+	}
+}
+
+// AsteriskFormat reads Asterisk cdr-csv Master.csv files.
+type AsteriskFormat struct {
+	name   string
+	cfg    AsteriskConfig
+	dirs   []model.CallDirection
+	defDir model.CallDirection
+}
+
+func NewAsteriskFormat(name string, cfg AsteriskConfig) (*AsteriskFormat, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("cdr format: empty name")
+	}
+
+	f := &AsteriskFormat{name: name, cfg: cfg}
+
+	for _, rule := range cfg.ContextRules {
+		dir := model.ParseCallDirection(rule.Direction)
+		if dir == model.DirUnknown {
+			return nil, fmt.Errorf("cdr format %q: bad direction %q for context %q", name, rule.Direction, rule.Context)
+		}
+
+		f.dirs = append(f.dirs, dir)
+	}
+
+	if cfg.DefaultDirection != "" {
+		f.defDir = model.ParseCallDirection(cfg.DefaultDirection)
+		if f.defDir == model.DirUnknown {
+			return nil, fmt.Errorf("cdr format %q: bad default_direction %q", name, cfg.DefaultDirection)
+		}
+	}
+
+	return f, nil
+}
+
+func (f *AsteriskFormat) Name() string {
+	return f.name
+}
+
+func (f *AsteriskFormat) Config() AsteriskConfig {
+	return f.cfg
+}
+
+func (f *AsteriskFormat) NewParser(zones Zones) Parser {
+	return &asteriskParser{f: f, zones: zones}
+}
+
+func (f *AsteriskFormat) direction(dcontext string) model.CallDirection {
+	for i, rule := range f.cfg.ContextRules {
+		if p, ok := strings.CutSuffix(rule.Context, "*"); ok {
+			if strings.HasPrefix(dcontext, p) {
+				return f.dirs[i]
+			}
+
+			continue
+		}
+
+		if dcontext == rule.Context {
+			return f.dirs[i]
+		}
+	}
+
+	return f.defDir
+}
+
+type asteriskParser struct {
+	f     *AsteriskFormat
+	zones Zones
+}
+
+func (p *asteriskParser) Parse(line string) (model.CDRRecord, bool, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	r.LazyQuotes = true
+	r.FieldsPerRecord = -1
+
+	cols, err := r.Read()
+	if err != nil {
+		return model.CDRRecord{}, false, fmt.Errorf("cdr: bad csv line: %w", err)
+	}
+
+	if len(cols) < astColumns {
+		return model.CDRRecord{}, false, fmt.Errorf("cdr: expected at least %d fields, got %d", astColumns, len(cols))
+	}
+
+	for i := range cols {
+		cols[i] = strings.TrimSpace(cols[i])
+	}
+
+	dir := p.f.direction(cols[astDContext])
+
+	// транк — канал «внешней» стороны: для исходящих это dstchannel, для входящих — channel
+	trunk := cols[astDstChannel]
+	if dir == model.DirIncoming {
+		trunk = cols[astChannel]
+	}
+
+	trunk = channelPeer(trunk)
+	loc := p.zones.For(trunk)
+
+	start, err := time.ParseInLocation(defaultTimeLayout, cols[astStart], loc)
+	if err != nil {
+		return model.CDRRecord{}, false, fmt.Errorf("cdr: bad StartTime %q: %w", cols[astStart], err)
+	}
+
+	end, err := time.ParseInLocation(defaultTimeLayout, cols[astEnd], loc)
+	if err != nil {
+		return model.CDRRecord{}, false, fmt.Errorf("cdr: bad EndTime %q: %w", cols[astEnd], err)
+	}
+
+	duration, err := strconv.Atoi(cols[astDuration])
+	if err != nil {
+		return model.CDRRecord{}, false, fmt.Errorf("cdr: bad Duration %q: %w", cols[astDuration], err)
+	}
+
+	bill, err := strconv.Atoi(cols[astBillSec])
+	if err != nil {
+		return model.CDRRecord{}, false, fmt.Errorf("cdr: bad BillableSec %q: %w", cols[astBillSec], err)
+	}
+
+	start, end = disambiguateDST(start, end, duration)
+
+	return model.CDRRecord{
+		StartTime: start,
+		EndTime:   end,
+
+		CallingParty: cols[astSrc],
+		CalledParty:  cols[astDst],
+
+		Direction:   dir,
+		Disposition: parseAsteriskDisposition(cols[astDisposition]),
+
+		Duration:    duration,
+		BillableSec: bill,
+
+		AccountCode: cols[astAccountCode],
+		CallID:      cols[astUniqueID],
+		TrunkName:   trunk,
+	}, true, nil
+}
+
+func parseAsteriskDisposition(s string) model.Disposition {
+	switch strings.ToUpper(s) {
+	case "ANSWERED":
+		return model.DispAnswered
+	case "BUSY":
+		return model.DispBusy
+	case "NO ANSWER":
+		return model.DispNoAnswer
+	case "FAILED", "CONGESTION":
+		return model.DispFailed
+	default:
+		return model.DispUnknown
+	}
+}
+
+// channelPeer("SIP/trunk1-0000002a") => "SIP/trunk1".
+func channelPeer(ch string) string {
+	if i := strings.LastIndexByte(ch, '-'); i > strings.IndexByte(ch, '/') {
+		return ch[:i]
+	}
+
+	return ch
+}
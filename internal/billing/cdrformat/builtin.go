@@ -0,0 +1,120 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package cdrformat
+
+import "time"
+
+const (
+	Asterisk   = "asterisk"
+	FreeSWITCH = "freeswitch"
+	JSONLines  = "jsonl"
+)
+
+func builtinFormats() []Format {
+	return []Format{
+		mustMapped(Native, nativeMapping()),
+		mustAsterisk(Asterisk, DefaultAsteriskConfig()),
+		mustMapped(FreeSWITCH, freeswitchMapping()),
+		mustMapped(JSONLines, jsonLinesMapping()),
+	}
+}
+
+func mustMapped(name string, m Mapping) Format {
+	f, err := NewMappedFormat(name, m)
+	if err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+func mustAsterisk(name string, cfg AsteriskConfig) Format {
+	f, err := NewAsteriskFormat(name, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+// nativeMapping: StartTime|EndTime|CallingParty|CalledParty|direction|disposition|duration|billable_sec|
+// (зарезервировано)|account_code|call_id|trunk_name.
+func nativeMapping() Mapping {
+	return Mapping{
+		Kind:       KindDelimited,
+		Separator:  "|",
+		MinColumns: 12,
+		Columns: map[Field]Column{
+			FieldStartTime:    {Index: 0},
+			FieldEndTime:      {Index: 1},
+			FieldCallingParty: {Index: 2},
+			FieldCalledParty:  {Index: 3},
+			FieldDirection:    {Index: 4},
+			FieldDisposition:  {Index: 5},
+			FieldDuration:     {Index: 6},
+			FieldBillableSec:  {Index: 7},
+			FieldAccountCode:  {Index: 9},
+			FieldCallID:       {Index: 10},
+			FieldTrunkName:    {Index: 11},
+		},
+	}
+}
+
+// freeswitchMapping reads the default mod_cdr_csv template: caller_id_name, caller_id_number,
+// destination_number, context, start_stamp, answer_stamp, end_stamp, duration, billsec,
+// hangup_cause, uuid, bleg_uuid, accountcode, read_codec, write_codec.
+func freeswitchMapping() Mapping {
+	return Mapping{
+		Kind:       KindDelimited,
+		Separator:  ",",
+		Quoted:     true,
+		MinColumns: 13,
+		Columns: map[Field]Column{
+			FieldCallingParty: {Index: 1},
+			FieldCalledParty:  {Index: 2},
+			FieldDirection:    {Index: 3},
+			FieldStartTime:    {Index: 4},
+			FieldEndTime:      {Index: 6},
+			FieldDuration:     {Index: 7},
+			FieldBillableSec:  {Index: 8},
+			FieldDisposition:  {Index: 9},
+			FieldCallID:       {Index: 10},
+			FieldAccountCode:  {Index: 12},
+		},
+		Values: map[Field]map[string]string{
+			FieldDirection: {
+				"default": "outgoing",
+				"public":  "incoming",
+			},
+			FieldDisposition: {
+				"NORMAL_CLEARING":   "answered",
+				"USER_BUSY":         "busy",
+				"NO_ANSWER":         "no_answer",
+				"NO_USER_RESPONSE":  "no_answer",
+				"ORIGINATOR_CANCEL": "no_answer",
+				"CALL_REJECTED":     "failed",
+			},
+		},
+	}
+}
+
+// jsonLinesMapping reads one JSON object per line with keys named after the fields.
+func jsonLinesMapping() Mapping {
+	cols := make(map[Field]Column, len(fieldLabels))
+	for f := range fieldLabels {
+		cols[f] = Column{Name: string(f)}
+	}
+
+	return Mapping{
+		Kind:       KindJSONLines,
+		TimeLayout: time.RFC3339,
+		Columns:    cols,
+	}
+}
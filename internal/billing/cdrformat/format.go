@@ -0,0 +1,120 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+// Package cdrformat turns CDR lines of different switch formats into model.CDRRecord.
+package cdrformat
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// Native is the name of the default "|"-separated 12-field format.
+const Native = "native"
+
+// Parser turns CDR source lines into records. Parsers may keep state between lines
+// (e.g. a bound header), so a new parser is created for every input stream.
+type Parser interface {
+	// Parse parses one non-empty line. ok=false means the line carries no record.
+	Parse(line string) (rec model.CDRRecord, ok bool, err error)
+}
+
+// Format is a named CDR layout.
+type Format interface {
+	Name() string
+	NewParser(zones Zones) Parser
+}
+
+// Info describes a registered format for listing.
+type Info struct {
+	Name     string
+	Builtin  bool
+	Mapping  *Mapping        // nil for formats that are not declarative
+	Asterisk *AsteriskConfig // only for Asterisk Master.csv formats
+}
+
+// Registry keeps built-in and user-registered formats by name.
+type Registry struct {
+	mu      sync.RWMutex
+	formats map[string]Format
+	builtin map[string]bool
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		formats: make(map[string]Format),
+		builtin: make(map[string]bool),
+	}
+
+	for _, f := range builtinFormats() {
+		r.formats[f.Name()] = f
+		r.builtin[f.Name()] = true
+	}
+
+	return r
+}
+
+// Get returns a format by name, empty name means Native.
+func (r *Registry) Get(name string) (Format, error) {
+	if name == "" {
+		name = Native
+	}
+
+	r.mu.RLock()
+	f, ok := r.formats[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("cdr format %q is not registered", name)
+	}
+
+	return f, nil
+}
+
+// Register adds or replaces a user format. Built-in formats can't be replaced.
+func (r *Registry) Register(f Format) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.builtin[f.Name()] {
+		return fmt.Errorf("cdr format %q is built-in and can't be replaced", f.Name())
+	}
+
+	r.formats[f.Name()] = f
+
+	return nil
+}
+
+func (r *Registry) List() []Info {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]Info, 0, len(r.formats))
+
+	for name, f := range r.formats {
+		info := Info{Name: name, Builtin: r.builtin[name]}
+		switch f := f.(type) {
+		case *MappedFormat:
+			m := f.mapping
+			info.Mapping = &m
+		case *AsteriskFormat:
+			cfg := f.cfg
+			info.Asterisk = &cfg
+		}
+
+		out = append(out, info)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package cdrformat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fieldAliases are header names recognized for each field besides the field name itself.
+var fieldAliases = map[Field][]string{
+	FieldStartTime:    {"starttime", "start", "start_stamp", "calldate", "call_start"},
+	FieldEndTime:      {"endtime", "end", "end_stamp", "call_end"},
+	FieldCallingParty: {"callingparty", "calling", "caller", "src", "a_number", "caller_id_number"},
+	FieldCalledParty:  {"calledparty", "called", "callee", "dst", "b_number", "destination_number"},
+	FieldDirection:    {"call_direction", "calldirection"},
+	FieldDisposition:  {"status", "call_status"},
+	FieldDuration:     {"duration_sec"},
+	FieldBillableSec:  {"billablesec", "billsec", "billable"},
+	FieldAccountCode:  {"accountcode", "account"},
+	FieldCallID:       {"callid", "uniqueid", "uuid"},
+	FieldTrunkName:    {"trunkname", "trunk"},
+}
+
+// headerBinding maps fields and passthrough attributes to column indexes of a file with a header row.
+type headerBinding struct {
+	fields map[Field]int
+	extras []extraColumn
+}
+
+type extraColumn struct {
+	index int
+	name  string
+}
+
+// UTF8BOM starts files saved by Excel and Notepad; it sticks to the first header cell.
+const UTF8BOM = "\ufeff"
+
+func normalizeHeaderName(s string) string {
+	s = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(s, UTF8BOM)))
+	s = strings.Trim(s, "\"'")
+
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(s)
+}
+
+// lookupField resolves a header name: first by names configured in the mapping, then by aliases.
+func (m *Mapping) lookupField(name string) (Field, bool) {
+	for f, col := range m.Columns {
+		if col.Name != "" && normalizeHeaderName(col.Name) == name {
+			return f, true
+		}
+	}
+
+	if _, ok := fieldLabels[Field(name)]; ok {
+		return Field(name), true
+	}
+
+	for f, aliases := range fieldAliases {
+		for _, a := range aliases {
+			if a == name {
+				return f, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// detectHeader returns a binding if cells look like a header row: the start time column
+// and at least one more field are recognized by name.
+func (m *Mapping) detectHeader(cells []string) (*headerBinding, bool) {
+	b := &headerBinding{fields: make(map[Field]int, len(cells))}
+
+	for i, c := range cells {
+		name := normalizeHeaderName(c)
+		if name == "" {
+			continue
+		}
+
+		f, ok := m.lookupField(name)
+		if !ok {
+			b.extras = append(b.extras, extraColumn{index: i, name: strings.TrimSpace(c)})
+			continue
+		}
+
+		if _, dup := b.fields[f]; !dup {
+			b.fields[f] = i
+		}
+	}
+
+	if _, ok := b.fields[FieldStartTime]; !ok || len(b.fields) < 2 {
+		return nil, false
+	}
+
+	return b, true
+}
+
+func (b *headerBinding) validate() error {
+	for _, f := range []Field{FieldStartTime, FieldCallingParty, FieldCalledParty} {
+		if _, ok := b.fields[f]; !ok {
+			return fmt.Errorf("cdr: header has no %q column", f)
+		}
+	}
+
+	return nil
+}
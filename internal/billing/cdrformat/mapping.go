@@ -0,0 +1,428 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package cdrformat
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+const (
+	KindDelimited = "delimited"
+	KindJSONLines = "jsonl"
+
+	// LayoutUnix is a pseudo time layout for epoch seconds.
+	LayoutUnix = "unix"
+
+	defaultTimeLayout = "2006-01-02 15:04:05"
+)
+
+// Field is a model.CDRRecord field a column can be mapped to.
+type Field string
+
+const (
+	FieldStartTime    Field = "start_time"
+	FieldEndTime      Field = "end_time"
+	FieldCallingParty Field = "calling_party"
+	FieldCalledParty  Field = "called_party"
+	FieldDirection    Field = "direction"
+	FieldDisposition  Field = "disposition"
+	FieldDuration     Field = "duration"
+	FieldBillableSec  Field = "billable_sec"
+	FieldAccountCode  Field = "account_code"
+	FieldCallID       Field = "call_id"
+	FieldTrunkName    Field = "trunk_name"
+)
+
+// fieldLabels are used in parse errors, they match model.CDRRecord field names.
+var fieldLabels = map[Field]string{
+	FieldStartTime:    "StartTime",
+	FieldEndTime:      "EndTime",
+	FieldCallingParty: "CallingParty",
+	FieldCalledParty:  "CalledParty",
+	FieldDirection:    "Direction",
+	FieldDisposition:  "Disposition",
+	FieldDuration:     "Duration",
+	FieldBillableSec:  "BillableSec",
+	FieldAccountCode:  "AccountCode",
+	FieldCallID:       "CallID",
+	FieldTrunkName:    "TrunkName",
+}
+
+// Column points to a source value: by 0-based index for delimited lines or by key for JSON lines.
+type Column struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+}
+
+// Mapping is a declarative description of a CDR layout.
+type Mapping struct {
+	Kind       string `json:"kind"`                  // delimited | jsonl
+	Separator  string `json:"separator,omitempty"`   // один символ, по умолчанию ","
+	Quoted     bool   `json:"quoted,omitempty"`      // значения в кавычках по правилам CSV
+	TimeLayout string `json:"time_layout,omitempty"` // Go layout или "unix"
+	MinColumns int    `json:"min_columns,omitempty"`
+
+	Columns map[Field]Column `json:"columns"`
+
+	// Values translates raw values into canonical ones, e.g. disposition "NO ANSWER" => "no_answer".
+	Values map[Field]map[string]string `json:"values,omitempty"`
+
+	// Defaults are used when a column is not mapped or empty.
+	Defaults map[Field]string `json:"defaults,omitempty"`
+}
+
+// MappedFormat is a Format driven by a Mapping.
+type MappedFormat struct {
+	name       string
+	mapping    Mapping
+	sep        rune
+	mappedKeys map[string]bool // ключи jsonl, привязанные к полям
+}
+
+func NewMappedFormat(name string, m Mapping) (*MappedFormat, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("cdr format: empty name")
+	}
+
+	if m.Kind == "" {
+		m.Kind = KindDelimited
+	}
+
+	if m.TimeLayout == "" {
+		m.TimeLayout = defaultTimeLayout
+	}
+
+	if m.Separator == "" {
+		m.Separator = ","
+	}
+
+	f := &MappedFormat{name: name, mapping: m, mappedKeys: make(map[string]bool, len(m.Columns))}
+
+	switch m.Kind {
+	case KindDelimited:
+		if utf8.RuneCountInString(m.Separator) != 1 {
+			return nil, fmt.Errorf("cdr format %q: separator must be a single character", name)
+		}
+
+		f.sep, _ = utf8.DecodeRuneInString(m.Separator)
+	case KindJSONLines:
+	default:
+		return nil, fmt.Errorf("cdr format %q: unknown kind %q", name, m.Kind)
+	}
+
+	for field, col := range m.Columns {
+		if _, ok := fieldLabels[field]; !ok {
+			return nil, fmt.Errorf("cdr format %q: unknown field %q", name, field)
+		}
+
+		if m.Kind == KindJSONLines && col.Name == "" {
+			return nil, fmt.Errorf("cdr format %q: field %q needs a name for jsonl", name, field)
+		}
+
+		if col.Name != "" {
+			f.mappedKeys[col.Name] = true
+		}
+
+		if col.Index < 0 {
+			return nil, fmt.Errorf("cdr format %q: field %q has negative index", name, field)
+		}
+	}
+
+	for _, field := range []Field{FieldStartTime, FieldCallingParty, FieldCalledParty} {
+		if _, ok := m.Columns[field]; !ok {
+			return nil, fmt.Errorf("cdr format %q: field %q must be mapped", name, field)
+		}
+	}
+
+	return f, nil
+}
+
+func (f *MappedFormat) Name() string {
+	return f.name
+}
+
+func (f *MappedFormat) Mapping() Mapping {
+	return f.mapping
+}
+
+func (f *MappedFormat) NewParser(zones Zones) Parser {
+	return &mappedParser{f: f, zones: zones}
+}
+
+type mappedParser struct {
+	f     *MappedFormat
+	zones Zones
+
+	// header is set when the first line of a delimited file was a header row;
+	// columns are then bound by name instead of by mapping indexes.
+	header    *headerBinding
+	seenFirst bool
+
+	// одно из двух заполняется на каждой строке
+	cells []string
+	obj   map[string]any
+}
+
+func (p *mappedParser) Parse(line string) (model.CDRRecord, bool, error) {
+	if err := p.split(line); err != nil {
+		return model.CDRRecord{}, false, err
+	}
+
+	if !p.seenFirst {
+		p.seenFirst = true
+
+		if p.cells != nil {
+			if b, ok := p.f.mapping.detectHeader(p.cells); ok {
+				if err := b.validate(); err != nil {
+					return model.CDRRecord{}, false, err
+				}
+
+				p.header = b
+
+				return model.CDRRecord{}, false, nil
+			}
+		}
+	}
+
+	// при наличии хедера колонки привязаны по имени, недостающие считаются пустыми
+	if minCols := p.f.mapping.MinColumns; p.header == nil && p.cells != nil && len(p.cells) < minCols {
+		return model.CDRRecord{}, false, fmt.Errorf("cdr: expected at least %d fields, got %d", minCols, len(p.cells))
+	}
+
+	rec, err := p.record()
+	if err != nil {
+		return model.CDRRecord{}, false, err
+	}
+
+	return rec, true, nil
+}
+
+func (p *mappedParser) split(line string) error {
+	m := &p.f.mapping
+
+	if m.Kind == KindJSONLines {
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+
+		p.obj = nil
+		p.cells = nil
+
+		if err := dec.Decode(&p.obj); err != nil {
+			return fmt.Errorf("cdr: bad json line: %w", err)
+		}
+
+		return nil
+	}
+
+	if m.Quoted {
+		r := csv.NewReader(strings.NewReader(line))
+		r.Comma = p.f.sep
+		r.LazyQuotes = true
+		r.FieldsPerRecord = -1
+
+		cells, err := r.Read()
+		if err != nil {
+			return fmt.Errorf("cdr: bad csv line: %w", err)
+		}
+
+		p.cells = cells
+	} else {
+		p.cells = strings.Split(line, m.Separator)
+	}
+
+	return nil
+}
+
+// value returns the canonical value of a field ("" when absent).
+func (p *mappedParser) value(field Field) string {
+	m := &p.f.mapping
+
+	var raw string
+
+	if p.header != nil {
+		if idx, ok := p.header.fields[field]; ok && idx < len(p.cells) {
+			raw = p.cells[idx]
+		}
+	} else if col, ok := m.Columns[field]; ok {
+		switch {
+		case p.obj != nil:
+			if v, ok := p.obj[col.Name]; ok && v != nil {
+				raw = fmt.Sprint(v)
+			}
+		case col.Index < len(p.cells):
+			raw = p.cells[col.Index]
+		}
+	}
+
+	raw = strings.TrimSpace(raw)
+
+	if vals := m.Values[field]; vals != nil {
+		if v, ok := vals[raw]; ok {
+			raw = v
+		}
+	}
+
+	if raw == "" {
+		raw = m.Defaults[field]
+	}
+
+	return raw
+}
+
+// attributes returns passthrough values of columns that are not mapped to record fields.
+func (p *mappedParser) attributes() map[string]string {
+	var out map[string]string
+
+	switch {
+	case p.header != nil:
+		for _, ex := range p.header.extras {
+			if ex.index >= len(p.cells) {
+				continue
+			}
+
+			if out == nil {
+				out = make(map[string]string, len(p.header.extras))
+			}
+
+			out[ex.name] = p.cells[ex.index]
+		}
+	case p.obj != nil:
+		for k, v := range p.obj {
+			if p.f.mappedKeys[k] || v == nil {
+				continue
+			}
+
+			if out == nil {
+				out = make(map[string]string)
+			}
+
+			out[k] = fmt.Sprint(v)
+		}
+	}
+
+	return out
+}
+
+func (p *mappedParser) has(field Field) bool {
+	return p.value(field) != ""
+}
+
+func (p *mappedParser) record() (model.CDRRecord, error) {
+	trunk := p.value(FieldTrunkName)
+	loc := p.zones.For(trunk)
+
+	start, err := p.time(FieldStartTime, loc)
+	if err != nil {
+		return model.CDRRecord{}, err
+	}
+
+	var end time.Time
+	if p.has(FieldEndTime) {
+		end, err = p.time(FieldEndTime, loc)
+		if err != nil {
+			return model.CDRRecord{}, err
+		}
+	}
+
+	rec := model.CDRRecord{
+		StartTime: start,
+		EndTime:   end,
+
+		CallingParty: p.value(FieldCallingParty),
+		CalledParty:  p.value(FieldCalledParty),
+
+		Direction:   model.ParseCallDirection(p.value(FieldDirection)),
+		Disposition: model.ParseDisposition(p.value(FieldDisposition)),
+
+		AccountCode: p.value(FieldAccountCode),
+		CallID:      p.value(FieldCallID),
+		TrunkName:   trunk,
+
+		Attributes: p.attributes(),
+	}
+
+	if p.mapped(FieldDuration) {
+		if rec.Duration, err = p.int(FieldDuration); err != nil {
+			return model.CDRRecord{}, err
+		}
+
+		if !end.IsZero() && p.f.mapping.TimeLayout != LayoutUnix {
+			rec.StartTime, rec.EndTime = disambiguateDST(start, end, rec.Duration)
+		}
+	} else if !end.IsZero() {
+		rec.Duration = int(end.Sub(start) / time.Second)
+	}
+
+	if p.mapped(FieldBillableSec) {
+		if rec.BillableSec, err = p.int(FieldBillableSec); err != nil {
+			return model.CDRRecord{}, err
+		}
+	} else if rec.Disposition == model.DispAnswered {
+		rec.BillableSec = rec.Duration
+	}
+
+	if rec.EndTime.IsZero() {
+		rec.EndTime = start.Add(time.Duration(rec.Duration) * time.Second)
+	}
+
+	return rec, nil
+}
+
+func (p *mappedParser) mapped(field Field) bool {
+	if p.header != nil {
+		_, ok := p.header.fields[field]
+		return ok
+	}
+
+	_, ok := p.f.mapping.Columns[field]
+
+	return ok
+}
+
+func (p *mappedParser) time(field Field, loc *time.Location) (time.Time, error) {
+	v := p.value(field)
+	layout := p.f.mapping.TimeLayout
+
+	if layout == LayoutUnix {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cdr: bad %s %q: %w", fieldLabels[field], v, err)
+		}
+
+		return time.Unix(sec, 0).In(loc), nil
+	}
+
+	t, err := time.ParseInLocation(layout, v, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cdr: bad %s %q: %w", fieldLabels[field], v, err)
+	}
+
+	return t, nil
+}
+
+func (p *mappedParser) int(field Field) (int, error) {
+	v := p.value(field)
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("cdr: bad %s %q: bad int %q", fieldLabels[field], v, v)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package cdrformat
+
+import "time"
+
+// Zones tells which time zone wall-clock CDR times are in.
+// Times with an explicit offset (e.g. RFC 3339) and unix timestamps don't depend on it.
+type Zones struct {
+	Default *time.Location
+	Trunks  map[string]*time.Location // by trunk name, take precedence over Default
+}
+
+// UTC is used when nothing is configured.
+func UTC() Zones {
+	return Zones{Default: time.UTC}
+}
+
+func (z Zones) For(trunk string) *time.Location {
+	if loc, ok := z.Trunks[trunk]; ok {
+		return loc
+	}
+
+	if z.Default == nil {
+		return time.UTC
+	}
+
+	return z.Default
+}
+
+// disambiguateDST fixes wall-clock times that fall into the repeated hour of a DST switch.
+// Such a time maps to two instants and time.ParseInLocation picks one of them, so a call
+// crossing the switch may get a span an hour off its duration. The switch knows the real
+// duration, so the pair of instants closest to it wins.
+func disambiguateDST(start, end time.Time, duration int) (time.Time, time.Time) {
+	want := time.Duration(duration) * time.Second
+	best := [2]time.Time{start, end}
+	bestDiff := absDuration(end.Sub(start) - want)
+
+	if bestDiff == 0 {
+		return start, end
+	}
+
+	for _, s := range wallClockInstants(start) {
+		for _, e := range wallClockInstants(end) {
+			if d := absDuration(e.Sub(s) - want); d < bestDiff {
+				best, bestDiff = [2]time.Time{s, e}, d
+			}
+		}
+	}
+
+	return best[0], best[1]
+}
+
+// wallClockInstants returns every instant that shows the same wall clock as t in t's zone.
+func wallClockInstants(t time.Time) []time.Time {
+	out := []time.Time{t}
+
+	for _, shift := range []time.Duration{-time.Hour, time.Hour} {
+		c := t.Add(shift)
+		if sameWallClock(c, t) {
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+func sameWallClock(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+
+	return ay == by && am == bm && ad == bd &&
+		a.Hour() == b.Hour() && a.Minute() == b.Minute() && a.Second() == b.Second()
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+// Package cdrinput opens CDR uploads and inbox files: plain text, gzip or zip archives
+// of several CDR files.
+package cdrinput
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+type compression uint8
+
+const (
+	compressionNone compression = iota
+	compressionGzip
+	compressionZip
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// ErrZipTooLarge fails zip uploads larger than the limit passed to Open.
+var ErrZipTooLarge = errors.New("zip archive is too large")
+
+// detectCompression looks at magic bytes first and falls back to the file name
+// (some clients send archives with a text content type).
+func detectCompression(br *bufio.Reader, name string) compression {
+	head, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(head, zipMagic):
+		return compressionZip
+	}
+
+	switch strings.ToLower(path.Ext(name)) {
+	case ".gz", ".gzip":
+		return compressionGzip
+	case ".zip":
+		return compressionZip
+	default:
+		return compressionNone
+	}
+}
+
+// Upload is a CDR upload split into source files.
+type Upload struct {
+	Sources []model.CDRSource
+
+	// Progress counts compressed bytes read from an archive (nil for plain uploads): the unpacked
+	// size is unknown in advance, so runs of archives measure progress by it instead of processed rows.
+	Progress *Progress
+
+	// CompressedSize is the archive size for zip uploads (0 when unknown).
+	CompressedSize int64
+
+	closers []func()
+}
+
+func (u *Upload) Close() {
+	for i := len(u.closers) - 1; i >= 0; i-- {
+		u.closers[i]()
+	}
+}
+
+// Progress passes compressed bytes read to a job. Reading starts before the job
+// (the gzip header, the zip directory), so bytes read until Attach are handed over by it.
+type Progress struct {
+	mu      sync.Mutex
+	pending int
+	add     func(n int)
+}
+
+func (p *Progress) read(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.add == nil {
+		p.pending += n
+		return
+	}
+
+	p.add(n)
+}
+
+// Attach starts passing bytes to add, beginning with the bytes read so far.
+func (p *Progress) Attach(add func(n int)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.add = add
+	if p.pending > 0 {
+		add(p.pending)
+		p.pending = 0
+	}
+}
+
+// Open detects gzip/zip content of an upload body and returns its CDR files.
+//
+// gzip is decompressed on the fly. zip needs random access to its central directory,
+// so the archive is spooled to a temp file first (at most maxZip bytes, 0 means no limit);
+// its entries are then rated one by one.
+func Open(src io.Reader, name string, maxZip int64) (*Upload, error) {
+	br := bufio.NewReaderSize(src, 64*1024)
+
+	switch detectCompression(br, name) {
+	case compressionGzip:
+		return openGzip(br, name, nil)
+	case compressionZip:
+		return spoolZip(br, maxZip)
+	default:
+		return &Upload{Sources: []model.CDRSource{model.ReaderSource(name, br)}}, nil
+	}
+}
+
+// OpenFile is Open for a file on disk: a zip archive is read in place, without spooling.
+func OpenFile(name string) (*Upload, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	closeFile := func() { _ = f.Close() }
+	br := bufio.NewReaderSize(f, 256*1024)
+
+	var u *Upload
+
+	switch detectCompression(br, name) {
+	case compressionGzip:
+		u, err = openGzip(br, filepath.Base(name), closeFile)
+	case compressionZip:
+		var info os.FileInfo
+		if info, err = f.Stat(); err == nil {
+			u, err = openZip(f, info.Size(), closeFile)
+		}
+	default:
+		u = &Upload{
+			Sources: []model.CDRSource{model.ReaderSource(filepath.Base(name), br)},
+			closers: []func(){closeFile},
+		}
+	}
+
+	if err != nil {
+		closeFile()
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// openGzip decompresses src on the fly; closeSrc (optional) closes the underlying file.
+func openGzip(src io.Reader, name string, closeSrc func()) (*Upload, error) {
+	progress := &Progress{}
+
+	zr, err := gzip.NewReader(&countingReader{r: src, onRead: progress.read})
+	if err != nil {
+		return nil, fmt.Errorf("open gzip: %w", err)
+	}
+
+	u := &Upload{
+		Sources:  []model.CDRSource{model.ReaderSource(strings.TrimSuffix(name, path.Ext(name)), zr)},
+		Progress: progress,
+	}
+
+	if closeSrc != nil {
+		u.closers = append(u.closers, closeSrc)
+	}
+
+	u.closers = append(u.closers, func() { _ = zr.Close() })
+
+	return u, nil
+}
+
+func spoolZip(src io.Reader, maxZip int64) (*Upload, error) {
+	f, err := os.CreateTemp("", "billing-upload-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+
+	remove := func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+
+	// лимит проверяется по ходу копирования: архив больше лимита не доходит до диска целиком
+	if maxZip > 0 {
+		src = io.LimitReader(src, maxZip+1)
+	}
+
+	size, err := io.Copy(f, src)
+	if err != nil {
+		remove()
+		return nil, fmt.Errorf("spool zip: %w", err)
+	}
+
+	if maxZip > 0 && size > maxZip {
+		remove()
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrZipTooLarge, maxZip)
+	}
+
+	u, err := openZip(f, size, remove)
+	if err != nil {
+		remove()
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// openZip lists CDR files of the archive in ra; closeSrc is called by Upload.Close.
+func openZip(ra io.ReaderAt, size int64, closeSrc func()) (*Upload, error) {
+	u := &Upload{Progress: &Progress{}}
+
+	zr, err := zip.NewReader(&countingReaderAt{r: ra, onRead: u.Progress.read}, size)
+	if err != nil {
+		return nil, fmt.Errorf("open zip: %w", err)
+	}
+
+	files := make([]*zip.File, 0, len(zr.File))
+	for _, zf := range zr.File {
+		if isCDREntry(zf) {
+			files = append(files, zf)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("zip archive has no CDR files")
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	for _, zf := range files {
+		u.Sources = append(u.Sources, zipEntrySource(zf))
+		u.CompressedSize += int64(zf.CompressedSize64)
+	}
+
+	u.closers = append(u.closers, closeSrc)
+
+	return u, nil
+}
+
+// isCDREntry skips directories and service files macOS/Windows put into archives.
+func isCDREntry(zf *zip.File) bool {
+	if zf.FileInfo().IsDir() {
+		return false
+	}
+
+	base := path.Base(zf.Name)
+
+	return !strings.HasPrefix(zf.Name, "__MACOSX/") && !strings.HasPrefix(base, ".") && base != "Thumbs.db"
+}
+
+// zipEntrySource opens a zip entry; nested .gz files are decompressed as well.
+func zipEntrySource(zf *zip.File) model.CDRSource {
+	return model.CDRSource{
+		Name: zf.Name,
+		Open: func() (io.ReadCloser, error) {
+			rc, err := zf.Open()
+			if err != nil {
+				return nil, err
+			}
+
+			if !strings.EqualFold(path.Ext(zf.Name), ".gz") {
+				return rc, nil
+			}
+
+			gz, err := gzip.NewReader(rc)
+			if err != nil {
+				_ = rc.Close()
+				return nil, fmt.Errorf("open gzip: %w", err)
+			}
+
+			return &gzipEntry{Reader: gz, entry: rc}, nil
+		},
+	}
+}
+
+// gzipEntry is a .gz file inside a zip archive.
+type gzipEntry struct {
+	*gzip.Reader
+	entry io.Closer
+}
+
+func (g *gzipEntry) Close() error {
+	return errors.Join(g.Reader.Close(), g.entry.Close())
+}
+
+type countingReaderAt struct {
+	r      io.ReaderAt
+	onRead func(n int)
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	if n > 0 {
+		c.onRead(n)
+	}
+
+	return n, err
+}
+
+type countingReader struct {
+	r      io.Reader
+	onRead func(n int)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.onRead(n)
+	}
+	return n, err
+}
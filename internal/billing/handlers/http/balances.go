@@ -0,0 +1,69 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+func (h *Handler) topUpBalance(w http.ResponseWriter, r *http.Request) {
+	var req TopUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", "invalid json body")
+		return
+	}
+
+	b, err := h.svc.TopUpBalance(r.Context(), r.PathValue("phone"), model.Money(req.AmountKop))
+	if err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BalanceResponse{Status: "ok", Balance: mapBalance(b)})
+}
+
+func (h *Handler) getBalance(w http.ResponseWriter, r *http.Request) {
+	b, err := h.svc.GetBalance(r.Context(), r.PathValue("phone"))
+	if err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BalanceResponse{Status: "ok", Balance: mapBalance(b)})
+}
+
+func mapBalance(b model.Balance) BalanceDTO {
+	return BalanceDTO{
+		PhoneNumber: b.PhoneNumber,
+		BalanceKop:  int64(b.Amount),
+		UpdatedAt:   b.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func mapBalanceSummaries(in []model.BalanceSummary) []BalanceSummaryDTO {
+	out := make([]BalanceSummaryDTO, 0, len(in))
+	for _, b := range in {
+		out = append(out, BalanceSummaryDTO{
+			PhoneNumber:     b.PhoneNumber,
+			ChargedKop:      int64(b.Charged),
+			OverdraftKop:    int64(b.Overdraft),
+			BalanceAfterKop: int64(b.BalanceAfter),
+			Negative:        b.BalanceAfter < 0,
+			CallsCharged:    b.CallsCharged,
+			CallsBlocked:    b.CallsBlocked,
+			CallsDuplicate:  b.CallsDuplicate,
+		})
+	}
+
+	return out
+}
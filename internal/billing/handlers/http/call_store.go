@@ -0,0 +1,877 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// A call store keeps rated calls of one run in three files next to each other:
+// <base>.calls.ndjson with a RatedCallDTO line per call (input order), <base>.calls.idx with
+// a fixed-size record per call and <base>.calls.dict.json with subscribers and destinations
+// the index refers to by number. A query reads the index only (and the secondary indexes
+// built when the store is finished) and the lines of its page.
+const (
+	callDataSuffix  = ".calls.ndjson"
+	callIndexSuffix = ".calls.idx"
+	callDictSuffix  = ".calls.dict.json"
+
+	callIndexRecord = 48
+)
+
+// callIndexEntry is one record of the index; the n-th record describes the n-th call.
+type callIndexEntry struct {
+	offset      int64
+	length      uint32
+	subscriber  uint32 // number in callDict.Subscribers + 1, 0 when the call has no subscriber
+	destination uint32 // number in callDict.Destinations + 1, 0 when no tariff matched
+	start       int64  // unix seconds
+	cost        int64
+	duration    int32
+	billable    int32
+	direction   model.CallDirection
+	disposition model.Disposition
+	rated       bool
+}
+
+func (e callIndexEntry) put(b []byte) {
+	binary.LittleEndian.PutUint64(b[0:], uint64(e.offset))
+	binary.LittleEndian.PutUint32(b[8:], e.length)
+	binary.LittleEndian.PutUint32(b[12:], e.subscriber)
+	binary.LittleEndian.PutUint32(b[16:], e.destination)
+	binary.LittleEndian.PutUint32(b[20:], uint32(e.duration))
+	binary.LittleEndian.PutUint64(b[24:], uint64(e.start))
+	binary.LittleEndian.PutUint64(b[32:], uint64(e.cost))
+	binary.LittleEndian.PutUint32(b[40:], uint32(e.billable))
+	b[44] = byte(e.direction)
+	b[45] = byte(e.disposition)
+	b[46] = 0
+	if e.rated {
+		b[46] = 1
+	}
+	b[47] = 0
+}
+
+func readCallIndexEntry(b []byte) callIndexEntry {
+	return callIndexEntry{
+		offset:      int64(binary.LittleEndian.Uint64(b[0:])),
+		length:      binary.LittleEndian.Uint32(b[8:]),
+		subscriber:  binary.LittleEndian.Uint32(b[12:]),
+		destination: binary.LittleEndian.Uint32(b[16:]),
+		duration:    int32(binary.LittleEndian.Uint32(b[20:])),
+		start:       int64(binary.LittleEndian.Uint64(b[24:])),
+		cost:        int64(binary.LittleEndian.Uint64(b[32:])),
+		billable:    int32(binary.LittleEndian.Uint32(b[40:])),
+		direction:   model.CallDirection(b[44]),
+		disposition: model.Disposition(b[45]),
+		rated:       b[46] == 1,
+	}
+}
+
+type callDict struct {
+	Subscribers  []string `json:"subscribers"`
+	Destinations []string `json:"destinations"`
+}
+
+// callStoreMark is how much of a call store a checkpoint covers.
+type callStoreMark struct {
+	Calls     int64 `json:"calls"`
+	DataBytes int64 `json:"data_bytes"`
+}
+
+// callStoreWriter appends calls of a running rating (Options.OnCall) to a call store.
+type callStoreWriter struct {
+	mu   sync.Mutex
+	path string // dir + base name
+
+	data, index     *os.File
+	dataBW, indexBW *bufio.Writer
+
+	mark  callStoreMark
+	dict  callDict
+	subs  map[string]uint32
+	dests map[string]uint32
+
+	line   bytes.Buffer
+	enc    *json.Encoder
+	rec    [callIndexRecord]byte
+	closed bool
+	moved  bool
+}
+
+// openCallStore creates a call store at path. With mark it continues a store written
+// before a checkpoint: calls after the mark are cut off.
+func openCallStore(path string, mark *callStoreMark) (*callStoreWriter, error) {
+	w := &callStoreWriter{path: path, subs: make(map[string]uint32), dests: make(map[string]uint32)}
+	w.enc = json.NewEncoder(&w.line)
+	w.enc.SetEscapeHTML(false)
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if mark != nil {
+		flag = os.O_CREATE | os.O_WRONLY
+
+		if err := readJSONFile(path+callDictSuffix, &w.dict); err != nil {
+			return nil, fmt.Errorf("open call store: %w", err)
+		}
+
+		w.mark = *mark
+	}
+
+	var err error
+	if w.data, err = os.OpenFile(path+callDataSuffix, flag, 0o644); err != nil {
+		return nil, fmt.Errorf("open call store: %w", err)
+	}
+
+	if w.index, err = os.OpenFile(path+callIndexSuffix, flag, 0o644); err != nil {
+		_ = w.data.Close()
+		return nil, fmt.Errorf("open call store: %w", err)
+	}
+
+	if mark != nil {
+		if err := w.cut(); err != nil {
+			_ = w.data.Close()
+			_ = w.index.Close()
+			return nil, fmt.Errorf("open call store: %w", err)
+		}
+	}
+
+	for i, s := range w.dict.Subscribers {
+		w.subs[s] = uint32(i + 1)
+	}
+
+	for i, s := range w.dict.Destinations {
+		w.dests[s] = uint32(i + 1)
+	}
+
+	w.dataBW = bufio.NewWriterSize(w.data, 256<<10)
+	w.indexBW = bufio.NewWriterSize(w.index, 64<<10)
+
+	return w, nil
+}
+
+// cut truncates the files to the mark and positions writes after it.
+func (w *callStoreWriter) cut() error {
+	for _, f := range []struct {
+		file *os.File
+		size int64
+	}{
+		{w.data, w.mark.DataBytes},
+		{w.index, w.mark.Calls * callIndexRecord},
+	} {
+		st, err := f.file.Stat()
+		if err != nil {
+			return err
+		}
+
+		// файл короче отметки: чекпоинт не от этого хранилища
+		if st.Size() < f.size {
+			return fmt.Errorf("%s is shorter than its checkpoint", st.Name())
+		}
+
+		if err := f.file.Truncate(f.size); err != nil {
+			return err
+		}
+
+		if _, err := f.file.Seek(f.size, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// add is Options.OnCall of a run that stores its calls.
+func (w *callStoreWriter) add(c model.RatedCall) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.line.Reset()
+	if err := w.enc.Encode(mapCall(c)); err != nil {
+		return err
+	}
+
+	e := callIndexEntry{
+		offset:      w.mark.DataBytes,
+		length:      uint32(w.line.Len()),
+		subscriber:  dictID(w.subs, &w.dict.Subscribers, c.SubscriberPhone),
+		start:       c.StartTime.Unix(),
+		cost:        int64(c.Cost),
+		duration:    int32(c.Duration),
+		billable:    int32(c.BillableSec),
+		direction:   c.Direction,
+		disposition: c.Disposition,
+		rated:       c.Tariff != nil,
+	}
+
+	if c.Tariff != nil {
+		e.destination = dictID(w.dests, &w.dict.Destinations, c.Tariff.Destination)
+	}
+
+	if _, err := w.dataBW.Write(w.line.Bytes()); err != nil {
+		return err
+	}
+
+	e.put(w.rec[:])
+	if _, err := w.indexBW.Write(w.rec[:]); err != nil {
+		return err
+	}
+
+	w.mark.DataBytes += int64(e.length)
+	w.mark.Calls++
+
+	return nil
+}
+
+func dictID(ids map[string]uint32, list *[]string, s string) uint32 {
+	if s == "" {
+		return 0
+	}
+
+	if id, ok := ids[s]; ok {
+		return id
+	}
+
+	*list = append(*list, s)
+	id := uint32(len(*list))
+	ids[s] = id
+
+	return id
+}
+
+// sync writes out buffered calls and the dictionary; the returned mark goes into a checkpoint.
+func (w *callStoreWriter) sync() (callStoreMark, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.mark, w.syncLocked()
+}
+
+func (w *callStoreWriter) syncLocked() error {
+	if err := w.dataBW.Flush(); err != nil {
+		return fmt.Errorf("write calls: %w", err)
+	}
+
+	if err := w.indexBW.Flush(); err != nil {
+		return fmt.Errorf("write calls index: %w", err)
+	}
+
+	return writeJSONFile(w.path+callDictSuffix, w.dict)
+}
+
+// close completes the store; the files stay where they are.
+func (w *callStoreWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	err := w.syncLocked()
+
+	return errors.Join(err, w.data.Close(), w.index.Close())
+}
+
+// moveTo completes the store, renames its files to path and builds its secondary indexes.
+func (w *callStoreWriter) moveTo(path string) error {
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	for _, suffix := range []string{callDataSuffix, callIndexSuffix, callDictSuffix} {
+		if err := os.Rename(w.path+suffix, path+suffix); err != nil {
+			removeCallStore(path)
+			return fmt.Errorf("move call store: %w", err)
+		}
+	}
+
+	w.moved = true
+
+	if err := buildCallIndexes(path, len(w.dict.Subscribers)); err != nil {
+		removeCallStore(path)
+		return err
+	}
+
+	return nil
+}
+
+// discard drops the store unless it was moved to a result. It accepts a nil writer,
+// so a run without store_calls can defer it unconditionally.
+func (w *callStoreWriter) discard() {
+	if w == nil {
+		return
+	}
+
+	_ = w.close()
+
+	if !w.moved {
+		removeCallStore(w.path)
+	}
+}
+
+func removeCallStore(path string) {
+	_ = os.Remove(path + callDataSuffix)
+	_ = os.Remove(path + callIndexSuffix)
+	_ = os.Remove(path + callDictSuffix)
+	removeCallIndexes(path)
+}
+
+// Sort orders of GET /api/v1/results/{id}/calls; "-" in front of the name sorts descending.
+const (
+	callSortSeq      = "seq" // input order
+	callSortStart    = "start_time"
+	callSortCost     = "cost"
+	callSortDuration = "duration"
+)
+
+const (
+	defaultCallPage = 100
+	maxCallPage     = 1000
+)
+
+// callQuery selects calls of a store. Nil pointers do not filter.
+type callQuery struct {
+	subscriber  string
+	destination string // case-insensitive substring of the tariff destination
+	direction   *model.CallDirection
+	disposition *model.Disposition
+	minCost     *int64
+	maxCost     *int64
+	from, to    *int64 // unix seconds of the call start: from <= start < to
+	rated       *bool  // false: only calls no tariff matched
+
+	sort   string
+	desc   bool
+	limit  int
+	cursor *callCursor
+}
+
+// callCursor is the position after the last call of a page: its sort key and number.
+type callCursor struct {
+	key int64
+	seq int64
+}
+
+func (q callQuery) sortName() string {
+	if q.desc {
+		return "-" + q.sort
+	}
+
+	return q.sort
+}
+
+func (q callQuery) encodeCursor(c callCursor) string {
+	raw := q.sortName() + ":" + strconv.FormatInt(c.key, 10) + ":" + strconv.FormatInt(c.seq, 10)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseCursor reads a cursor of a previous page; it must come from the same sort order.
+func (q callQuery) parseCursor(s string) (*callCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("cursor: malformed")
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 {
+		return nil, errors.New("cursor: malformed")
+	}
+
+	if parts[0] != q.sortName() {
+		return nil, fmt.Errorf("cursor: issued for sort=%s, not %s", parts[0], q.sortName())
+	}
+
+	key, err1 := strconv.ParseInt(parts[1], 10, 64)
+	seq, err2 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, errors.New("cursor: malformed")
+	}
+
+	return &callCursor{key: key, seq: seq}, nil
+}
+
+func (q callQuery) key(e callIndexEntry, seq int64) int64 {
+	switch q.sort {
+	case callSortStart:
+		return e.start
+	case callSortCost:
+		return e.cost
+	case callSortDuration:
+		return int64(e.duration)
+	default:
+		return seq
+	}
+}
+
+// before reports whether a goes before b in the query order; equal keys keep the input order.
+func (q callQuery) before(a, b callCursor) bool {
+	if a.key != b.key {
+		return (a.key < b.key) != q.desc
+	}
+
+	return a.seq < b.seq
+}
+
+// callFilter is callQuery with strings resolved to dictionary numbers.
+type callFilter struct {
+	q            callQuery
+	none         bool // nothing can match
+	subscriber   uint32
+	destinations map[uint32]bool
+}
+
+func newCallFilter(q callQuery, dict callDict) callFilter {
+	f := callFilter{q: q}
+
+	if q.subscriber != "" {
+		for i, s := range dict.Subscribers {
+			if s == q.subscriber {
+				f.subscriber = uint32(i + 1)
+				break
+			}
+		}
+
+		f.none = f.subscriber == 0
+	}
+
+	if q.destination != "" {
+		needle := strings.ToLower(q.destination)
+
+		f.destinations = make(map[uint32]bool)
+		for i, d := range dict.Destinations {
+			if strings.Contains(strings.ToLower(d), needle) {
+				f.destinations[uint32(i+1)] = true
+			}
+		}
+
+		f.none = f.none || len(f.destinations) == 0
+	}
+
+	return f
+}
+
+func (f callFilter) match(e callIndexEntry) bool {
+	q := f.q
+
+	switch {
+	case f.none:
+		return false
+	case f.subscriber != 0 && e.subscriber != f.subscriber:
+		return false
+	case f.destinations != nil && !f.destinations[e.destination]:
+		return false
+	case q.direction != nil && e.direction != *q.direction:
+		return false
+	case q.disposition != nil && e.disposition != *q.disposition:
+		return false
+	case q.minCost != nil && e.cost < *q.minCost:
+		return false
+	case q.maxCost != nil && e.cost > *q.maxCost:
+		return false
+	case q.from != nil && e.start < *q.from:
+		return false
+	case q.to != nil && e.start >= *q.to:
+		return false
+	case q.rated != nil && e.rated != *q.rated:
+		return false
+	}
+
+	return true
+}
+
+type callHit struct {
+	pos   callCursor
+	entry callIndexEntry
+}
+
+// callPageHeap keeps the first calls of a page; the last of them in the query order is on top.
+type callPageHeap struct {
+	q    callQuery
+	hits []callHit
+}
+
+func (h *callPageHeap) Len() int           { return len(h.hits) }
+func (h *callPageHeap) Less(i, j int) bool { return h.q.before(h.hits[j].pos, h.hits[i].pos) }
+func (h *callPageHeap) Swap(i, j int)      { h.hits[i], h.hits[j] = h.hits[j], h.hits[i] }
+func (h *callPageHeap) Push(x any)         { h.hits = append(h.hits, x.(callHit)) }
+
+func (h *callPageHeap) Pop() any {
+	last := h.hits[len(h.hits)-1]
+	h.hits = h.hits[:len(h.hits)-1]
+
+	return last
+}
+
+type callPage struct {
+	total  int64
+	items  []json.RawMessage
+	cursor string
+}
+
+// queryCallStore returns a page of calls of the store at path; memory holds only the page.
+// With secondary indexes (call_store_index.go) a page reads the records after its cursor until it is
+// full, and the number of matches comes from counts (nil: not cached). Older stores are scanned whole.
+func queryCallStore(path string, q callQuery, counts *callCountCache) (callPage, error) {
+	var dict callDict
+	if err := readJSONFile(path+callDictSuffix, &dict); err != nil {
+		return callPage{}, err
+	}
+
+	f := newCallFilter(q, dict)
+	if f.none {
+		return callPage{items: []json.RawMessage{}}, nil
+	}
+
+	s, err := openCallStoreFiles(path, len(dict.Subscribers))
+	if err != nil {
+		return callPage{}, err
+	}
+	defer s.Close()
+
+	var (
+		hits  []callHit
+		total int64 = -1
+	)
+
+	switch {
+	case s.sorted == nil || s.subs == nil:
+		hits, total, err = selectCallPage(f, s.scan)
+	case f.subscriber != 0:
+		hits, total, err = s.subscriberPage(f)
+	case q.sort == callSortSeq:
+		hits, err = collectCallPage(f, func(visit func(seq int64, e callIndexEntry) bool) error {
+			return s.walkInput(q, visit)
+		})
+	default:
+		reads := 0
+
+		hits, err = collectCallPage(f, func(visit func(seq int64, e callIndexEntry) bool) error {
+			return s.walkSorted(q, func(seq int64) (bool, error) {
+				if reads++; reads > maxSortedReads {
+					return false, errSortedWalkTooLong
+				}
+
+				e, err := s.entry(seq)
+				if err != nil {
+					return false, err
+				}
+
+				return visit(seq, e), nil
+			})
+		})
+
+		if errors.Is(err, errSortedWalkTooLong) {
+			hits, total, err = selectCallPage(f, s.scan)
+		}
+	}
+
+	if err != nil {
+		return callPage{}, err
+	}
+
+	page := callPage{total: total, items: make([]json.RawMessage, 0, q.limit)}
+
+	if page.total < 0 {
+		if page.total, err = s.count(f, counts); err != nil {
+			return callPage{}, err
+		}
+	} else if q.filtered() {
+		counts.put(q.filterKey(), page.total)
+	}
+
+	// одна лишняя запись показывает, что за страницей есть продолжение
+	if len(hits) > q.limit {
+		hits = hits[:q.limit]
+		page.cursor = q.encodeCursor(hits[len(hits)-1].pos)
+	}
+
+	data, err := os.Open(path + callDataSuffix)
+	if err != nil {
+		return callPage{}, err
+	}
+	defer data.Close()
+
+	for _, hit := range hits {
+		line := make([]byte, hit.entry.length)
+		if _, err := data.ReadAt(line, hit.entry.offset); err != nil {
+			return callPage{}, fmt.Errorf("read calls: %w", err)
+		}
+
+		page.items = append(page.items, bytes.TrimRight(line, "\n"))
+	}
+
+	return page, nil
+}
+
+var errSortedWalkTooLong = errors.New("sorted walk is too long")
+
+// subscriberPage reads only the calls of the filtered subscriber, unless they are a large part
+// of the store: then the index is walked as without the filter.
+func (s *callStoreFiles) subscriberPage(f callFilter) ([]callHit, int64, error) {
+	seqs, err := s.subscriberCalls(f.subscriber)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if int64(len(seqs)) > s.n/maxSubscriberShare {
+		return selectCallPage(f, s.scan)
+	}
+
+	return selectCallPage(f, func(visit func(seq int64, e callIndexEntry)) error {
+		for _, seq := range seqs {
+			e, err := s.entry(int64(seq))
+			if err != nil {
+				return err
+			}
+
+			visit(int64(seq), e)
+		}
+
+		return nil
+	})
+}
+
+// count returns the number of calls matching f: the store size without filters, otherwise a cached
+// count or one scan of the index (stores don't change once finished).
+func (s *callStoreFiles) count(f callFilter, counts *callCountCache) (int64, error) {
+	if !f.q.filtered() {
+		return s.n, nil
+	}
+
+	key := f.q.filterKey()
+	if n, ok := counts.get(key); ok {
+		return n, nil
+	}
+
+	var n int64
+
+	err := s.scan(func(_ int64, e callIndexEntry) {
+		if f.match(e) {
+			n++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	counts.put(key, n)
+
+	return n, nil
+}
+
+// collectCallPage takes the first limit+1 matches of calls each visits in the query order after the cursor.
+func collectCallPage(f callFilter, each func(visit func(seq int64, e callIndexEntry) bool) error) ([]callHit, error) {
+	q := f.q
+	hits := make([]callHit, 0, q.limit+1)
+
+	err := each(func(seq int64, e callIndexEntry) bool {
+		if f.match(e) {
+			hits = append(hits, callHit{pos: callCursor{key: q.key(e, seq), seq: seq}, entry: e})
+		}
+
+		return len(hits) <= q.limit
+	})
+
+	return hits, err
+}
+
+// selectCallPage takes the first limit+1 matches after the cursor of calls each visits in any order
+// and counts all the matches.
+func selectCallPage(f callFilter, each func(visit func(seq int64, e callIndexEntry)) error) ([]callHit, int64, error) {
+	var (
+		q     = f.q
+		top   = &callPageHeap{q: q, hits: make([]callHit, 0, q.limit+1)}
+		total int64
+	)
+
+	err := each(func(seq int64, e callIndexEntry) {
+		if !f.match(e) {
+			return
+		}
+
+		total++
+
+		hit := callHit{pos: callCursor{key: q.key(e, seq), seq: seq}, entry: e}
+		if q.cursor != nil && !q.before(*q.cursor, hit.pos) {
+			return
+		}
+
+		if top.Len() <= q.limit {
+			heap.Push(top, hit)
+		} else if q.before(hit.pos, top.hits[0].pos) {
+			top.hits[0] = hit
+			heap.Fix(top, 0)
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hits := make([]callHit, top.Len())
+	for i := len(hits) - 1; i >= 0; i-- {
+		hits[i] = heap.Pop(top).(callHit)
+	}
+
+	return hits, total, nil
+}
+
+// callQueryFromRequest parses query parameters of GET /api/v1/results/{id}/calls.
+// Times without a zone are read in loc.
+func callQueryFromRequest(r *http.Request, loc *time.Location) (callQuery, error) {
+	v := r.URL.Query()
+	q := callQuery{
+		subscriber:  strings.TrimSpace(v.Get("subscriber")),
+		destination: strings.TrimSpace(v.Get("destination")),
+		limit:       defaultCallPage,
+	}
+
+	if s := strings.TrimSpace(v.Get("direction")); s != "" {
+		d := model.ParseCallDirection(s)
+		if d == model.DirUnknown && s != "unknown" {
+			return callQuery{}, fmt.Errorf("direction: want incoming | outgoing | internal | unknown, got %q", s)
+		}
+
+		q.direction = &d
+	}
+
+	if s := strings.TrimSpace(v.Get("disposition")); s != "" {
+		d := model.ParseDisposition(s)
+		if d == model.DispUnknown && s != "unknown" {
+			return callQuery{}, fmt.Errorf("disposition: want answered | busy | no_answer | failed | unknown, got %q", s)
+		}
+
+		q.disposition = &d
+	}
+
+	var err error
+
+	for _, p := range []struct {
+		name string
+		dst  **int64
+	}{
+		{"min_cost_kop", &q.minCost},
+		{"max_cost_kop", &q.maxCost},
+	} {
+		if s := strings.TrimSpace(v.Get(p.name)); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return callQuery{}, fmt.Errorf("%s: want an integer, got %q", p.name, s)
+			}
+
+			*p.dst = &n
+		}
+	}
+
+	if q.from, err = parseCallTime(v.Get("from"), loc); err != nil {
+		return callQuery{}, fmt.Errorf("from: %w", err)
+	}
+
+	if q.to, err = parseCallTime(v.Get("to"), loc); err != nil {
+		return callQuery{}, fmt.Errorf("to: %w", err)
+	}
+
+	// unrated=true — только звонки без тарифа, unrated=false — только тарифицированные
+	if s := strings.TrimSpace(v.Get("unrated")); s != "" {
+		unrated, err := strconv.ParseBool(s)
+		if err != nil {
+			return callQuery{}, fmt.Errorf("unrated: want true or false, got %q", s)
+		}
+
+		rated := !unrated
+		q.rated = &rated
+	}
+
+	sortName := strings.TrimSpace(v.Get("sort"))
+	q.sort, q.desc = strings.TrimPrefix(sortName, "-"), strings.HasPrefix(sortName, "-")
+
+	switch q.sort {
+	case "":
+		q.sort = callSortSeq
+	case callSortSeq, callSortStart, callSortCost, callSortDuration:
+	default:
+		return callQuery{}, fmt.Errorf("sort: want [-]seq | start_time | cost | duration, got %q", sortName)
+	}
+
+	if s := strings.TrimSpace(v.Get("limit")); s != "" {
+		if q.limit, err = strconv.Atoi(s); err != nil || q.limit < 1 || q.limit > maxCallPage {
+			return callQuery{}, fmt.Errorf("limit: must be in 1..%d, got %q", maxCallPage, s)
+		}
+	}
+
+	if s := strings.TrimSpace(v.Get("cursor")); s != "" {
+		if q.cursor, err = q.parseCursor(s); err != nil {
+			return callQuery{}, err
+		}
+	}
+
+	return q, nil
+}
+
+// parseCallTime accepts RFC 3339, "2006-01-02 15:04:05" and "2006-01-02" (the start of the day).
+func parseCallTime(s string, loc *time.Location) (*int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if t, err = time.ParseInLocation(cdrTimeLayout, s, loc); err != nil {
+			if t, err = time.ParseInLocation(time.DateOnly, s, loc); err != nil {
+				return nil, fmt.Errorf("want RFC 3339, \"2006-01-02 15:04:05\" or \"2006-01-02\", got %q", s)
+			}
+		}
+	}
+
+	unix := t.Unix()
+
+	return &unix, nil
+}
+
+// checkStoreCalls validates store_calls of a request.
+func (h *Handler) checkStoreCalls(o TariffOptionsDTO) error {
+	switch {
+	case !o.StoreCalls:
+		return nil
+	case h.results == nil:
+		return errors.New("store_calls: results persistence is disabled (set DATA_DIR)")
+	case o.CollectCalls:
+		return errors.New("store_calls: exclusive with collect_calls, stored calls are read by GET /api/v1/results/{id}/calls")
+	}
+
+	return nil
+}
+
+// newCallStore creates the call store of a synchronous run; nil without store_calls.
+func (h *Handler) newCallStore(store bool) (*callStoreWriter, error) {
+	if !store {
+		return nil, nil
+	}
+
+	return h.results.NewCallStore()
+}
@@ -0,0 +1,558 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A finished call store (moveTo) gets two secondary indexes, so that a page reads only the records
+// it needs instead of the whole index:
+// <base>.calls.sort has a section per sort order (start time, cost, duration) with callSortRecord
+// records ordered by the key, then by input order; <base>.calls.subs has the calls of every subscriber
+// in input order, after a header of their positions. Stores written before them are scanned as before.
+const (
+	callSortIndexSuffix = ".calls.sort"
+	callSubsIndexSuffix = ".calls.subs"
+
+	callSortRecord   = 12 // key int64, seq uint32
+	callRecordsBlock = 4096
+
+	// большой абонент дешевле пройти по индексу подряд, чем читать его звонки вразбивку
+	maxSubscriberShare = 8
+
+	// столько звонков страница в порядке сортировки читает вразбивку, прежде чем пройти индекс подряд:
+	// фильтр, под который не подходят первые по сортировке звонки, так обходится не дороже полного прохода
+	maxSortedReads = 1 << 16
+)
+
+var callSortOrders = []string{callSortStart, callSortCost, callSortDuration}
+
+type callSortKey struct {
+	key int64
+	seq uint32
+}
+
+func (k callSortKey) put(b []byte) {
+	binary.LittleEndian.PutUint64(b[0:], uint64(k.key))
+	binary.LittleEndian.PutUint32(b[8:], k.seq)
+}
+
+func readCallSortKey(b []byte) callSortKey {
+	return callSortKey{key: int64(binary.LittleEndian.Uint64(b[0:])), seq: binary.LittleEndian.Uint32(b[8:])}
+}
+
+// callStoreFiles are the opened index files of a finished store; sorted and subs are nil without
+// secondary indexes.
+type callStoreFiles struct {
+	index, sorted, subs *os.File
+	n                   int64
+	subscribers         int
+}
+
+func openCallStoreFiles(path string, subscribers int) (*callStoreFiles, error) {
+	index, err := os.Open(path + callIndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := index.Stat()
+	if err != nil {
+		_ = index.Close()
+		return nil, err
+	}
+
+	s := &callStoreFiles{index: index, n: st.Size() / callIndexRecord, subscribers: subscribers}
+
+	// индексы не того размера (например, недописанные) не используются
+	s.sorted = openSized(path+callSortIndexSuffix, func(*os.File) int64 {
+		return int64(len(callSortOrders)) * s.n * callSortRecord
+	})
+	s.subs = openSized(path+callSubsIndexSuffix, func(f *os.File) int64 {
+		var end [8]byte
+		if _, err := f.ReadAt(end[:], int64(subscribers)*8); err != nil {
+			return -1
+		}
+
+		return s.subsHeader() + int64(binary.LittleEndian.Uint64(end[:]))*4
+	})
+
+	return s, nil
+}
+
+// openSized opens a secondary index if it exists and has the size it should have.
+func openSized(name string, size func(f *os.File) int64) *os.File {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+
+	if st, err := f.Stat(); err != nil || st.Size() != size(f) {
+		_ = f.Close()
+		return nil
+	}
+
+	return f
+}
+
+func (s *callStoreFiles) subsHeader() int64 {
+	return int64(s.subscribers+1) * 8
+}
+
+func (s *callStoreFiles) Close() {
+	for _, f := range []*os.File{s.index, s.sorted, s.subs} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+}
+
+func (s *callStoreFiles) entry(seq int64) (callIndexEntry, error) {
+	var rec [callIndexRecord]byte
+	if _, err := s.index.ReadAt(rec[:], seq*callIndexRecord); err != nil {
+		return callIndexEntry{}, fmt.Errorf("read calls index: %w", err)
+	}
+
+	return readCallIndexEntry(rec[:]), nil
+}
+
+// scan visits every call of the index in input order.
+func (s *callStoreFiles) scan(visit func(seq int64, e callIndexEntry)) error {
+	rd := bufio.NewReaderSize(io.NewSectionReader(s.index, 0, s.n*callIndexRecord), 256<<10)
+
+	var rec [callIndexRecord]byte
+
+	for seq := range s.n {
+		if _, err := io.ReadFull(rd, rec[:]); err != nil {
+			return fmt.Errorf("read calls index: %w", err)
+		}
+
+		visit(seq, readCallIndexEntry(rec[:]))
+	}
+
+	return nil
+}
+
+// buildCallIndexes writes the secondary indexes of a completed store.
+func buildCallIndexes(path string, subscribers int) (err error) {
+	s, err := openCallStoreFiles(path, subscribers)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// номер звонка в индексах — uint32; больше звонков запросы пройдут полным чтением
+	if s.n > math.MaxUint32 {
+		return nil
+	}
+
+	defer func() {
+		if err != nil {
+			removeCallIndexes(path)
+		}
+	}()
+
+	if err := s.buildSorted(path + callSortIndexSuffix); err != nil {
+		return fmt.Errorf("build calls sort index: %w", err)
+	}
+
+	if err := s.buildSubs(path + callSubsIndexSuffix); err != nil {
+		return fmt.Errorf("build calls subscriber index: %w", err)
+	}
+
+	return nil
+}
+
+func (s *callStoreFiles) buildSorted(name string) error {
+	keys := make([]callSortKey, s.n)
+
+	return writeIndexFile(name, func(bw *bufio.Writer) error {
+		var rec [callSortRecord]byte
+
+		for _, order := range callSortOrders {
+			q := callQuery{sort: order}
+
+			err := s.scan(func(seq int64, e callIndexEntry) {
+				keys[seq] = callSortKey{key: q.key(e, seq), seq: uint32(seq)}
+			})
+			if err != nil {
+				return err
+			}
+
+			slices.SortFunc(keys, func(a, b callSortKey) int {
+				return cmp.Or(cmp.Compare(a.key, b.key), cmp.Compare(a.seq, b.seq))
+			})
+
+			for _, k := range keys {
+				k.put(rec[:])
+				if _, err := bw.Write(rec[:]); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// buildSubs writes the header — where the calls of subscriber k+1 start, k = 0..subscribers
+// (the last one is the end) — and the numbers of the calls. Calls without a subscriber are not listed.
+func (s *callStoreFiles) buildSubs(name string) error {
+	start := make([]uint64, s.subscribers+2)
+
+	err := s.scan(func(_ int64, e callIndexEntry) {
+		if e.subscriber != 0 && int(e.subscriber) <= s.subscribers {
+			start[e.subscriber+1]++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 2; i < len(start); i++ {
+		start[i] += start[i-1]
+	}
+
+	seqs := make([]uint32, start[len(start)-1])
+	next := slices.Clone(start)
+
+	err = s.scan(func(seq int64, e callIndexEntry) {
+		if e.subscriber != 0 && int(e.subscriber) <= s.subscribers {
+			seqs[next[e.subscriber]] = uint32(seq)
+			next[e.subscriber]++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeIndexFile(name, func(bw *bufio.Writer) error {
+		var b [8]byte
+
+		for _, v := range start[1:] {
+			binary.LittleEndian.PutUint64(b[:], v)
+			if _, err := bw.Write(b[:]); err != nil {
+				return err
+			}
+		}
+
+		for _, seq := range seqs {
+			binary.LittleEndian.PutUint32(b[:4], seq)
+			if _, err := bw.Write(b[:4]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func writeIndexFile(name string, write func(bw *bufio.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(f, 256<<10)
+
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	return errors.Join(err, f.Close())
+}
+
+func removeCallIndexes(path string) {
+	_ = os.Remove(path + callSortIndexSuffix)
+	_ = os.Remove(path + callSubsIndexSuffix)
+}
+
+// subscriberCalls returns the numbers of the calls of subscriber id (1-based), in input order.
+func (s *callStoreFiles) subscriberCalls(id uint32) ([]uint32, error) {
+	var bounds [16]byte
+	if _, err := s.subs.ReadAt(bounds[:], int64(id-1)*8); err != nil {
+		return nil, fmt.Errorf("read calls subscriber index: %w", err)
+	}
+
+	lo := int64(binary.LittleEndian.Uint64(bounds[0:]))
+	hi := int64(binary.LittleEndian.Uint64(bounds[8:]))
+
+	raw := make([]byte, (hi-lo)*4)
+	if _, err := s.subs.ReadAt(raw, s.subsHeader()+lo*4); err != nil {
+		return nil, fmt.Errorf("read calls subscriber index: %w", err)
+	}
+
+	seqs := make([]uint32, hi-lo)
+	for i := range seqs {
+		seqs[i] = binary.LittleEndian.Uint32(raw[i*4:])
+	}
+
+	return seqs, nil
+}
+
+// walkInput visits calls in input order after the cursor (backwards for -seq) until visit returns false.
+func (s *callStoreFiles) walkInput(q callQuery, visit func(seq int64, e callIndexEntry) bool) error {
+	buf := make([]byte, callRecordsBlock*callIndexRecord)
+
+	read := func(lo, hi int64) ([]byte, error) {
+		b := buf[:(hi-lo)*callIndexRecord]
+		if _, err := s.index.ReadAt(b, lo*callIndexRecord); err != nil {
+			return nil, fmt.Errorf("read calls index: %w", err)
+		}
+
+		return b, nil
+	}
+
+	if !q.desc {
+		from := int64(0)
+		if q.cursor != nil {
+			from = max(q.cursor.seq+1, 0)
+		}
+
+		for lo := from; lo < s.n; lo += callRecordsBlock {
+			hi := min(lo+callRecordsBlock, s.n)
+
+			b, err := read(lo, hi)
+			if err != nil {
+				return err
+			}
+
+			for seq := lo; seq < hi; seq++ {
+				if !visit(seq, readCallIndexEntry(b[(seq-lo)*callIndexRecord:])) {
+					return nil
+				}
+			}
+		}
+
+		return nil
+	}
+
+	to := s.n
+	if q.cursor != nil {
+		to = min(q.cursor.seq, s.n)
+	}
+
+	for hi := to; hi > 0; hi -= callRecordsBlock {
+		lo := max(hi-callRecordsBlock, 0)
+
+		b, err := read(lo, hi)
+		if err != nil {
+			return err
+		}
+
+		for seq := hi - 1; seq >= lo; seq-- {
+			if !visit(seq, readCallIndexEntry(b[(seq-lo)*callIndexRecord:])) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkSorted visits calls in the order of q (not seq) after the cursor until visit returns false.
+// The section is ordered by key, then by input order; a descending query takes groups of equal keys
+// from the end, but each group from its start, since equal keys keep the input order.
+func (s *callStoreFiles) walkSorted(q callQuery, visit func(seq int64) (bool, error)) error {
+	base := int64(slices.Index(callSortOrders, q.sort)) * s.n * callSortRecord
+	buf := make([]byte, callRecordsBlock*callSortRecord)
+
+	var readErr error
+
+	at := func(p int64) callSortKey {
+		var rec [callSortRecord]byte
+		if _, err := s.sorted.ReadAt(rec[:], base+p*callSortRecord); err != nil && readErr == nil {
+			readErr = fmt.Errorf("read calls sort index: %w", err)
+		}
+
+		return readCallSortKey(rec[:])
+	}
+
+	search := func(after func(k callSortKey) bool) int64 {
+		return int64(sort.Search(int(s.n), func(p int) bool { return after(at(int64(p))) }))
+	}
+
+	read := func(lo, hi int64) ([]byte, error) {
+		b := buf[:(hi-lo)*callSortRecord]
+		if _, err := s.sorted.ReadAt(b, base+lo*callSortRecord); err != nil {
+			return nil, fmt.Errorf("read calls sort index: %w", err)
+		}
+
+		return b, nil
+	}
+
+	// forward visits positions [lo, hi); false: visit stopped the walk
+	forward := func(lo, hi int64) (bool, error) {
+		for ; lo < hi; lo += callRecordsBlock {
+			end := min(lo+callRecordsBlock, hi)
+
+			b, err := read(lo, end)
+			if err != nil {
+				return false, err
+			}
+
+			for p := lo; p < end; p++ {
+				if ok, err := visit(int64(readCallSortKey(b[(p-lo)*callSortRecord:]).seq)); !ok || err != nil {
+					return false, err
+				}
+			}
+		}
+
+		return true, nil
+	}
+
+	c := q.cursor
+
+	if !q.desc {
+		from := int64(0)
+		if c != nil {
+			from = search(func(k callSortKey) bool { return k.key > c.key || k.key == c.key && int64(k.seq) > c.seq })
+		}
+
+		if readErr != nil {
+			return readErr
+		}
+
+		_, err := forward(from, s.n)
+
+		return err
+	}
+
+	end := s.n
+
+	if c != nil {
+		// сначала остаток группы ключа курсора, затем ключи меньше
+		from := search(func(k callSortKey) bool { return k.key > c.key || k.key == c.key && int64(k.seq) > c.seq })
+		groupEnd := search(func(k callSortKey) bool { return k.key > c.key })
+		end = search(func(k callSortKey) bool { return k.key >= c.key })
+
+		if readErr != nil {
+			return readErr
+		}
+
+		if ok, err := forward(from, groupEnd); !ok || err != nil {
+			return err
+		}
+	}
+
+	var (
+		group    []uint32 // group of equal keys, read from its end
+		groupKey int64
+	)
+
+	flush := func() (bool, error) {
+		for i := len(group) - 1; i >= 0; i-- {
+			if ok, err := visit(int64(group[i])); !ok || err != nil {
+				return false, err
+			}
+		}
+
+		group = group[:0]
+
+		return true, nil
+	}
+
+	for hi := end; hi > 0; hi -= callRecordsBlock {
+		lo := max(hi-callRecordsBlock, 0)
+
+		b, err := read(lo, hi)
+		if err != nil {
+			return err
+		}
+
+		for p := hi - 1; p >= lo; p-- {
+			k := readCallSortKey(b[(p-lo)*callSortRecord:])
+
+			if len(group) > 0 && k.key != groupKey {
+				if ok, err := flush(); !ok || err != nil {
+					return err
+				}
+			}
+
+			group = append(group, k.seq)
+			groupKey = k.key
+		}
+	}
+
+	_, err := flush()
+
+	return err
+}
+
+// callCountCache keeps match counts of the filters of one finished store: the first page of a filter
+// scans the index, the next pages and other sort orders reuse the count.
+type callCountCache struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+const maxCachedCallCounts = 256
+
+func (c *callCountCache) get(key string) (int64, bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.counts[key]
+
+	return n, ok
+}
+
+func (c *callCountCache) put(key string, n int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil || len(c.counts) >= maxCachedCallCounts {
+		c.counts = make(map[string]int64)
+	}
+
+	c.counts[key] = n
+}
+
+// filterKey identifies the filters of a query (not its order or page) in callCountCache.
+func (q callQuery) filterKey() string {
+	return strings.Join([]string{
+		q.subscriber, strings.ToLower(q.destination),
+		optionalKey(q.direction), optionalKey(q.disposition), optionalKey(q.minCost), optionalKey(q.maxCost),
+		optionalKey(q.from), optionalKey(q.to), optionalKey(q.rated),
+	}, "\x00")
+}
+
+// filtered reports whether the query has any filter.
+func (q callQuery) filtered() bool {
+	return q.subscriber != "" || q.destination != "" || q.direction != nil || q.disposition != nil ||
+		q.minCost != nil || q.maxCost != nil || q.from != nil || q.to != nil || q.rated != nil
+}
+
+func optionalKey[T any](v *T) string {
+	if v == nil {
+		return "-"
+	}
+
+	return fmt.Sprint(*v)
+}
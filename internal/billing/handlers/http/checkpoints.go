@@ -0,0 +1,375 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+const (
+	jobSpecSuffix       = ".json"
+	jobCheckpointSuffix = ".checkpoint"
+	jobCollectedSuffix  = ".collected"
+)
+
+// jobSpec is what is needed to start a background job again after restart.
+type jobSpec struct {
+	ID         string                  `json:"id"`
+	Request    StartPreparedCDRRequest `json:"request"`
+	TotalBytes int64                   `json:"total_bytes"`
+	CreatedAt  time.Time               `json:"created_at"`
+}
+
+// jobCheckpoint is the latest checkpoint of a job; Calls is how much of the job's call store
+// (store_calls) it covers, Collected — how much of its collected calls file (<id>.collected).
+type jobCheckpoint struct {
+	model.Checkpoint
+
+	Calls     *callStoreMark `json:"calls,omitempty"`
+	Collected int64          `json:"collected,omitempty"`
+}
+
+// JobCheckpoints keeps specs and the latest checkpoints of unfinished background jobs in a directory.
+// Files are removed when a job ends; what is left after a crash or restart are interrupted jobs.
+// A store_calls job writes its call store here too (<id>.calls.*) until the result takes it.
+type JobCheckpoints struct {
+	dir string
+}
+
+func NewJobCheckpoints(dir string) (*JobCheckpoints, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir jobs dir: %w", err)
+	}
+
+	return &JobCheckpoints{dir: dir}, nil
+}
+
+func (c *JobCheckpoints) SaveSpec(spec jobSpec) error {
+	return writeJSONFile(filepath.Join(c.dir, spec.ID+jobSpecSuffix), spec)
+}
+
+func (c *JobCheckpoints) SaveCheckpoint(id string, cp jobCheckpoint) error {
+	return writeJSONFile(filepath.Join(c.dir, id+jobCheckpointSuffix), cp)
+}
+
+// Load returns the job spec and its latest checkpoint (nil if the job had none yet).
+func (c *JobCheckpoints) Load(id string) (jobSpec, *jobCheckpoint, error) {
+	var spec jobSpec
+	if err := readJSONFile(filepath.Join(c.dir, id+jobSpecSuffix), &spec); err != nil {
+		return jobSpec{}, nil, err
+	}
+
+	var cp jobCheckpoint
+
+	err := readJSONFile(filepath.Join(c.dir, id+jobCheckpointSuffix), &cp)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return spec, nil, nil
+	case err != nil:
+		return jobSpec{}, nil, err
+	}
+
+	return spec, &cp, nil
+}
+
+// AppendCollected appends a chunk of collected calls of a checkpoint (model.Checkpoint.Calls)
+// and returns the file size for jobCheckpoint.Collected. An empty chunk only reports the size.
+func (c *JobCheckpoints) AppendCollected(id string, chunk []byte) (int64, error) {
+	name := filepath.Join(c.dir, id+jobCollectedSuffix)
+
+	if len(chunk) == 0 {
+		info, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return info.Size(), nil
+	}
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// запись — длина и сама часть; чекпоинт сохраняется после синхронизации файла
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(chunk)))
+
+	if _, err := f.Write(append(size[:], chunk...)); err != nil {
+		return 0, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// LoadCollected cuts the collected calls file to the end the checkpoint saw (0 — the job starts over)
+// and returns its chunks in order.
+func (c *JobCheckpoints) LoadCollected(id string, end int64) ([][]byte, error) {
+	name := filepath.Join(c.dir, id+jobCollectedSuffix)
+
+	if end == 0 {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	// хвост, записанный после последнего чекпоинта, отбрасывается
+	if err := os.Truncate(name, end); err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks [][]byte
+
+	for len(raw) > 0 {
+		if len(raw) < 4 {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(name), io.ErrUnexpectedEOF)
+		}
+
+		n := int(binary.LittleEndian.Uint32(raw))
+		if len(raw)-4 < n {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(name), io.ErrUnexpectedEOF)
+		}
+
+		chunks = append(chunks, raw[4:4+n])
+		raw = raw[4+n:]
+	}
+
+	return chunks, nil
+}
+
+// Interrupted lists jobs left unfinished by the previous process.
+func (c *JobCheckpoints) Interrupted() ([]jobSpec, []*jobCheckpoint) {
+	names, _ := filepath.Glob(filepath.Join(c.dir, "*"+jobSpecSuffix))
+
+	specs := make([]jobSpec, 0, len(names))
+	cps := make([]*jobCheckpoint, 0, len(names))
+
+	for _, name := range names {
+		spec, cp, err := c.Load(strings.TrimSuffix(filepath.Base(name), jobSpecSuffix))
+		if err != nil || spec.ID == "" {
+			continue
+		}
+
+		specs = append(specs, spec)
+		cps = append(cps, cp)
+	}
+
+	return specs, cps
+}
+
+// OpenCallStore opens the call store of a store_calls job: a new one, or with mark
+// the one the job wrote before its checkpoint.
+func (c *JobCheckpoints) OpenCallStore(id string, mark *callStoreMark) (*callStoreWriter, error) {
+	return openCallStore(filepath.Join(c.dir, id), mark)
+}
+
+func (c *JobCheckpoints) Remove(id string) {
+	_ = os.Remove(filepath.Join(c.dir, id+jobCheckpointSuffix))
+	_ = os.Remove(filepath.Join(c.dir, id+jobSpecSuffix))
+	_ = os.Remove(filepath.Join(c.dir, id+jobCollectedSuffix))
+	removeCallStore(filepath.Join(c.dir, id))
+}
+
+func readJSONFile(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("decode %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
+
+// restoreInterrupted registers jobs of the previous process: they wait for POST /api/v1/jobs/{id}/resume
+// (reference data lives in memory and has to be loaded again first).
+func (h *Handler) restoreInterrupted() {
+	specs, cps := h.checkpoints.Interrupted()
+
+	for i, spec := range specs {
+		ids, err := spec.Request.preparedIDs()
+		if err != nil {
+			h.checkpoints.Remove(spec.ID)
+			continue
+		}
+
+		h.prepared.Pin(ids)
+		h.jobPins.Store(spec.ID, ids)
+
+		var read int64
+		if cps[i] != nil {
+			read = cps[i].ProcessedBytes
+		}
+
+		h.jobs.Restore(spec.ID, spec.TotalBytes, read, spec.CreatedAt)
+	}
+}
+
+// persistJob saves the spec of a new background job and keeps its prepared files while it is unfinished.
+func (h *Handler) persistJob(id string, req StartPreparedCDRRequest, ids []string, totalBytes int64) error {
+	if h.checkpoints == nil {
+		return nil
+	}
+
+	spec := jobSpec{ID: id, Request: req, TotalBytes: totalBytes, CreatedAt: time.Now()}
+	spec.Request.ProgressID = id
+
+	if err := h.checkpoints.SaveSpec(spec); err != nil {
+		return err
+	}
+
+	h.prepared.Pin(ids)
+	h.jobPins.Store(id, ids)
+
+	return nil
+}
+
+// releaseJob forgets a finished job on disk. It is safe to call more than once.
+func (h *Handler) releaseJob(id string) {
+	if h.checkpoints == nil {
+		return
+	}
+
+	if ids, ok := h.jobPins.LoadAndDelete(id); ok {
+		h.prepared.Unpin(ids.([]string))
+	}
+
+	h.checkpoints.Remove(id)
+}
+
+// resumeJob continues an interrupted job from its latest checkpoint.
+func (h *Handler) resumeJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	if h.checkpoints == nil {
+		writeErr(w, http.StatusNotFound, "not_found", "job persistence is disabled (set DATA_DIR)")
+		return
+	}
+
+	snap, ok := h.jobs.Get(id)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "job not found or expired")
+		return
+	}
+
+	if snap.Status != jobInterrupted {
+		writeErr(w, http.StatusConflict, "job_not_interrupted", "job is "+snap.Status)
+		return
+	}
+
+	spec, cp, err := h.checkpoints.Load(id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	// тарифы в памяти: после перезапуска их нужно загрузить заново, и ровно те же
+	if cp != nil {
+		if v, _ := h.svc.TariffVersion(); v.SHA256 != cp.Tariffs.SHA256 {
+			writeErr(w, http.StatusConflict, "tariffs_changed",
+				"load the same tariffs the job was started with (sha256 "+cp.Tariffs.SHA256+")")
+			return
+		}
+	}
+
+	ids, err := spec.Request.preparedIDs()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	opt, err := spec.Request.toModel()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	sources, total, missing := h.preparedSources(ids)
+	if missing != "" {
+		writeErr(w, http.StatusNotFound, "not_found", fmt.Sprintf("prepared file %q not found", missing))
+		return
+	}
+
+	// хранилище звонков обрезается до чекпоинта; без чекпоинта задача начнётся заново
+	var calls *callStoreWriter
+	if spec.Request.StoreCalls {
+		var mark *callStoreMark
+		if cp != nil {
+			mark = cp.Calls
+		}
+
+		if calls, err = h.checkpoints.OpenCallStore(id, mark); err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+	}
+
+	// звонки отчёта (collect_calls) тоже обрезаются до чекпоинта
+	var collected int64
+	if cp != nil {
+		collected = cp.Collected
+	}
+
+	chunks, err := h.checkpoints.LoadCollected(id, collected)
+	if err != nil {
+		calls.discard()
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	if err := h.jobs.Resume(id); err != nil {
+		calls.discard()
+		writeErr(w, http.StatusConflict, "job_not_interrupted", err.Error())
+		return
+	}
+
+	opt.TotalBytes = total
+	if cp != nil {
+		opt.Resume = &cp.Checkpoint
+		opt.Resume.CallChunks = chunks
+	}
+
+	h.startJob(id, sources, opt, calls)
+
+	writeJSON(w, http.StatusAccepted, JobSubmitResponse{Status: "accepted", JobID: id})
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// cdrTimeLayout is the time layout of the native CDR format.
+const cdrTimeLayout = "2006-01-02 15:04:05"
+
+// exportCallsHeader: the native CDR columns in their order (the reserved 9th column holds the cost
+// in rubles), then the rating. With the default "|" separator the export is a native CDR file:
+// /cdr/tariff binds the header row by name and takes the rating columns as passthrough attributes.
+var exportCallsHeader = []string{
+	"start_time", "end_time", "calling_party", "called_party", "direction", "disposition",
+	"duration", "billable_sec", "cost", "account_code", "call_id", "trunk_name",
+	"subscriber", "tariff_prefix", "tariff_destination", "tariff_priority",
+}
+
+var exportTotalsHeader = []string{"phone_number", "client_name", "calls_count", "total_cost"}
+
+// csvOptions are CSV settings of a response; Excel in ru-RU wants ";" and a decimal comma.
+type csvOptions struct {
+	sep     rune
+	decimal byte
+	bom     bool
+}
+
+func (o OutputOptionsDTO) csvOptions() (csvOptions, error) {
+	opt := csvOptions{sep: ';', decimal: '.', bom: o.BOM}
+
+	// звонки по умолчанию выгружаются в разметке входного CDR, их можно снова отправить на расчёт
+	if strings.EqualFold(strings.TrimSpace(o.Output), outputCallsCSV) {
+		opt.sep = '|'
+	}
+
+	switch strings.ToLower(o.CSVSep) {
+	case "":
+	case ";":
+		opt.sep = ';'
+	case ",":
+		opt.sep = ','
+	case "|":
+		opt.sep = '|'
+	case "tab", "\t":
+		opt.sep = '\t'
+	default:
+		return csvOptions{}, fmt.Errorf("csv_sep: want ; , | or tab, got %q", o.CSVSep)
+	}
+
+	switch strings.TrimSpace(o.Decimal) {
+	case "", ".":
+	case ",":
+		opt.decimal = ','
+	default:
+		return csvOptions{}, fmt.Errorf("decimal: want . or , got %q", o.Decimal)
+	}
+
+	if opt.sep == ',' && opt.decimal == ',' {
+		return csvOptions{}, fmt.Errorf("decimal: ',' clashes with csv_sep ','")
+	}
+
+	return opt, nil
+}
+
+// start sends the headers of a CSV response and returns the writer of its rows.
+func (o csvOptions) start(w http.ResponseWriter, bw *bufio.Writer, name string) *csv.Writer {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	if o.bom {
+		_, _ = bw.WriteString("\ufeff")
+	}
+
+	cw := csv.NewWriter(bw)
+	cw.Comma = o.sep
+
+	return cw
+}
+
+// spreadsheetText keeps a text cell from being evaluated as a formula (CSV injection): a cell
+// starting with = @ (or a tab, CR) gets a leading apostrophe, which Excel and LibreOffice hide.
+// + and - are left alone: phone numbers (+79162914177) must stay as they are.
+func spreadsheetText(s string) string {
+	if s != "" && strings.ContainsRune("=@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+func exportCallRow(c model.RatedCall, decimal byte) []string {
+	row := []string{
+		c.StartTime.Format(cdrTimeLayout), c.EndTime.Format(cdrTimeLayout),
+		spreadsheetText(c.CallingParty), spreadsheetText(c.CalledParty), c.Direction.String(), c.Disposition.String(),
+		strconv.Itoa(c.Duration), strconv.Itoa(c.BillableSec), c.Cost.Format(decimal),
+		spreadsheetText(c.AccountCode), spreadsheetText(c.CallID), spreadsheetText(c.TrunkName),
+		spreadsheetText(c.SubscriberPhone), "", "", "",
+	}
+
+	if c.Tariff != nil {
+		row[13] = spreadsheetText(c.Tariff.Prefix)
+		row[14] = spreadsheetText(c.Tariff.Destination)
+		row[15] = strconv.Itoa(c.Tariff.Priority)
+	}
+
+	return row
+}
+
+// writeTotalsCSV answers with subscriber totals, one row per subscriber.
+func writeTotalsCSV(w http.ResponseWriter, totals []SubscriberTotalDTO, opt csvOptions) {
+	bw := bufio.NewWriter(w)
+	cw := opt.start(w, bw, "totals")
+
+	_ = cw.Write(exportTotalsHeader)
+
+	for _, t := range totals {
+		_ = cw.Write([]string{
+			spreadsheetText(t.PhoneNumber), spreadsheetText(t.ClientName), strconv.Itoa(t.CallsCount),
+			model.Money(t.TotalCostKop).Format(opt.decimal),
+		})
+	}
+
+	cw.Flush()
+	_ = bw.Flush()
+}
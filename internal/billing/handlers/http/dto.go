@@ -9,6 +9,8 @@
 
 package http
 
+import "ukrainian_call_center_scam_goev/internal/billing/cdrformat"
+
 type ErrorResponse struct {
 	Error ErrorBody `json:"error"`
 }
@@ -26,18 +28,134 @@ type UploadResponse struct {
 	Status string `json:"status"`
 }
 
+type SubscriberDTO struct {
+	PhoneNumber    string `json:"phone_number"`
+	ClientName     string `json:"client_name"`
+	CreditLimitKop int64  `json:"credit_limit_kop"`
+}
+
+type SubscriberResponse struct {
+	Status     string        `json:"status"`
+	Subscriber SubscriberDTO `json:"subscriber"`
+}
+
+type SubscriberListResponse struct {
+	Status string          `json:"status"`
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Items  []SubscriberDTO `json:"items"`
+}
+
+type TopUpRequest struct {
+	AmountKop int64 `json:"amount_kop"`
+}
+
+type BalanceDTO struct {
+	PhoneNumber string `json:"phone_number"`
+	BalanceKop  int64  `json:"balance_kop"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+type BalanceResponse struct {
+	Status  string     `json:"status"`
+	Balance BalanceDTO `json:"balance"`
+}
+
+type CDRFormatDTO struct {
+	Name     string                    `json:"name"`
+	Builtin  bool                      `json:"builtin"`
+	Mapping  *cdrformat.Mapping        `json:"mapping,omitempty"`
+	Asterisk *cdrformat.AsteriskConfig `json:"asterisk,omitempty"`
+}
+
+type CDRFormatListResponse struct {
+	Status  string         `json:"status"`
+	Formats []CDRFormatDTO `json:"formats"`
+}
+
+// RegisterCDRFormatRequest registers either a declarative mapping or an Asterisk importer
+// with custom context rules (exactly one of Mapping and Asterisk must be set).
+type RegisterCDRFormatRequest struct {
+	Name     string                    `json:"name"`
+	Mapping  *cdrformat.Mapping        `json:"mapping,omitempty"`
+	Asterisk *cdrformat.AsteriskConfig `json:"asterisk,omitempty"`
+}
+
 type PreparedCDRResponse struct {
 	Status          string `json:"status"`
 	PreparedID      string `json:"prepared_id"`
 	FileName        string `json:"file_name"`
+	Format          string `json:"format"`
 	RowsCount       int64  `json:"rows_count"`
 	NormalizedBytes int64  `json:"normalized_bytes"`
+
+	Files []PreparedCDRFileDTO `json:"files"`
+}
+
+// TariffOptionsDTO carries rating options shared by /cdr/tariff (query) and /cdr/start (json body).
+type TariffOptionsDTO struct {
+	CollectCalls bool `json:"collect_calls"`
+
+	// StoreCalls keeps rated calls with the persisted result instead of the response
+	// (GET /api/v1/results/{id}/calls); needs DATA_DIR.
+	StoreCalls bool `json:"store_calls,omitempty"`
+
+	// Attribution is a comma-separated fallback chain, e.g. "account_code,trunk,calling_party".
+	Attribution string `json:"attribution,omitempty"`
+
+	// UnknownSubscribers is bill | skip | fail.
+	UnknownSubscribers string `json:"unknown_subscribers,omitempty"`
+
+	// NegativeBalance is flag | block | overdraft.
+	NegativeBalance string `json:"negative_balance,omitempty"`
+
+	// Preview only shows prepaid charges; by default they are deducted once the run succeeds.
+	Preview bool `json:"preview,omitempty"`
+
+	// Timezone is an IANA zone of wall-clock CDR times of this upload (default: server zone).
+	Timezone string `json:"timezone,omitempty"`
+
+	// Tolerant skips malformed rows and reports them instead of failing the run.
+	Tolerant   bool `json:"tolerant,omitempty"`
+	MaxRejects int  `json:"max_rejects,omitempty"`
+
+	// Validation is a list of rule:severity pairs, e.g. "end_before_start:reject,unknown_direction:off".
+	Validation string `json:"validation,omitempty"`
+
+	// DurationTolerance is nil when not set (the default tolerance), 0 requires an exact match.
+	DurationTolerance *int `json:"duration_tolerance_sec,omitempty"`
+
+	// Dedup is off | first_wins | last_wins | reject, DedupKey is call_id | full.
+	Dedup       string `json:"dedup,omitempty"`
+	DedupKey    string `json:"dedup_key,omitempty"`
+	DedupWindow int    `json:"dedup_window,omitempty"`
+
+	// Priority is low | normal | high: the order of runs waiting to start and their share of workers.
+	Priority string `json:"priority,omitempty"`
 }
 
 type StartPreparedCDRRequest struct {
-	PreparedID   string `json:"prepared_id"`
-	CollectCalls bool   `json:"collect_calls"`
-	ProgressID   string `json:"progress_id"`
+	PreparedID string `json:"prepared_id"`
+	ProgressID string `json:"progress_id"`
+
+	// PreparedIDs rates several prepared uploads (e.g. one file per day) as one run with a combined report.
+	PreparedIDs []string `json:"prepared_ids,omitempty"`
+
+	OutputOptionsDTO
+	TariffOptionsDTO
+}
+
+// OutputOptionsDTO selects the response format of /cdr/tariff and /cdr/start.
+type OutputOptionsDTO struct {
+	// Output is json (default) | ndjson | csv | calls_csv | totals_csv.
+	Output string `json:"output,omitempty"`
+
+	// CSVSep is the CSV separator: ";" (default), ",", "|" or "tab". Decimal is the decimal separator
+	// of money in rubles ("." or ","). BOM prepends a UTF-8 BOM, so that Excel detects the encoding.
+	CSVSep  string `json:"csv_sep,omitempty"`
+	Decimal string `json:"decimal,omitempty"`
+	BOM     bool   `json:"bom,omitempty"`
 }
 
 type SubscriberTotalDTO struct {
@@ -47,6 +165,87 @@ type SubscriberTotalDTO struct {
 	CallsCount   int    `json:"calls_count"`
 }
 
+type UnknownSubscriberDTO struct {
+	PhoneNumber    string `json:"phone_number"`
+	CallsCount     int    `json:"calls_count"`
+	WouldBeCostKop int64  `json:"would_be_cost_kop"`
+}
+
+type BalanceSummaryDTO struct {
+	PhoneNumber     string `json:"phone_number"`
+	ChargedKop      int64  `json:"charged_kop"`
+	OverdraftKop    int64  `json:"overdraft_kop"`
+	BalanceAfterKop int64  `json:"balance_after_kop"`
+	Negative        bool   `json:"negative"`
+	CallsCharged    int    `json:"calls_charged"`
+	CallsBlocked    int    `json:"calls_blocked"`
+	CallsDuplicate  int    `json:"calls_duplicate"`
+}
+
+type CreditEventDTO struct {
+	PhoneNumber    string `json:"phone_number"`
+	ClientName     string `json:"client_name,omitempty"`
+	ThresholdPct   int    `json:"threshold_pct"`
+	CreditLimitKop int64  `json:"credit_limit_kop"`
+	TotalCostKop   int64  `json:"total_cost_kop"`
+	CallID         string `json:"call_id,omitempty"`
+	At             string `json:"at"`
+}
+
+type RejectedRowDTO struct {
+	File  string `json:"file,omitempty"`
+	Line  int64  `json:"line"`
+	Raw   string `json:"raw"`
+	Error string `json:"error"`
+}
+
+type RejectsDTO struct {
+	Count     int64            `json:"count"`
+	Truncated bool             `json:"truncated"` // Count > len(Rows)
+	Rows      []RejectedRowDTO `json:"rows"`
+
+	// DownloadID is used with GET /api/v1/cdr/rejects/{id}; the download has DownloadRows rows,
+	// the response only the first maxInlineRejects of them.
+	DownloadID   string `json:"download_id,omitempty"`
+	DownloadRows int    `json:"download_rows,omitempty"`
+}
+
+type RuleViolationsDTO struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Count    int64  `json:"count"`
+}
+
+type DuplicateRowDTO struct {
+	CallID      string `json:"call_id"`
+	KeptFile    string `json:"kept_file,omitempty"`
+	KeptLine    int64  `json:"kept_line"`
+	DroppedFile string `json:"dropped_file,omitempty"`
+	DroppedLine int64  `json:"dropped_line"`
+}
+
+type FileStatsDTO struct {
+	Name       string `json:"name"`
+	Rows       int64  `json:"rows"`
+	Rejected   int64  `json:"rejected"`
+	Duplicates int64  `json:"duplicates"`
+	Calls      int64  `json:"calls"`
+	CostKop    int64  `json:"cost_kop"`
+}
+
+type PreparedCDRFileDTO struct {
+	Name            string `json:"name"`
+	RowsCount       int64  `json:"rows_count"`
+	NormalizedBytes int64  `json:"normalized_bytes"`
+}
+
+type DuplicatesDTO struct {
+	Mode      string            `json:"mode"`
+	Count     int64             `json:"count"`
+	Truncated bool              `json:"truncated"`
+	Rows      []DuplicateRowDTO `json:"rows"`
+}
+
 type AppliedTariffRefDTO struct {
 	Prefix      string `json:"prefix"`
 	Destination string `json:"destination"`
@@ -69,13 +268,41 @@ type RatedCallDTO struct {
 	CallID      string `json:"call_id,omitempty"`
 	TrunkName   string `json:"trunk_name,omitempty"`
 
+	Subscriber string `json:"subscriber"`
+
+	// Attributes are passthrough source columns not mapped to CDR fields.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
 	CostKop int64                `json:"cost_kop"`
 	Tariff  *AppliedTariffRefDTO `json:"tariff,omitempty"`
 }
 
 type TariffCDRResponse struct {
 	Status        string               `json:"status"`
+	ResultID      string               `json:"result_id,omitempty"` // id in /api/v1/results when persistence is on
 	CalculationMS float64              `json:"calculation_ms"`
 	Totals        []SubscriberTotalDTO `json:"totals"`
 	Calls         []RatedCallDTO       `json:"calls,omitempty"`
+
+	// StoredCalls is the number of calls kept with the result (store_calls): GET /api/v1/results/{id}/calls.
+	StoredCalls int64 `json:"stored_calls,omitempty"`
+
+	UnknownSubscribers []UnknownSubscriberDTO `json:"unknown_subscribers"`
+	Balances           []BalanceSummaryDTO    `json:"balances"`
+	BalancesCharged    bool                   `json:"balances_charged"` // false: balances are a preview
+	CreditEvents       []CreditEventDTO       `json:"credit_events"`
+	Rejects            RejectsDTO             `json:"rejects"`
+	Violations         []RuleViolationsDTO    `json:"violations"`
+	Duplicates         DuplicatesDTO          `json:"duplicates"`
+	Files              []FileStatsDTO         `json:"files"`
+}
+
+type TrunkZonesRequest struct {
+	Trunks map[string]string `json:"trunks"`
+}
+
+type TrunkZonesResponse struct {
+	Status  string            `json:"status"`
+	Default string            `json:"default"`
+	Trunks  map[string]string `json:"trunks"`
 }
@@ -0,0 +1,79 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ukrainian_call_center_scam_goev/internal/billing/cdrformat"
+)
+
+func (h *Handler) listCDRFormats(w http.ResponseWriter, r *http.Request) {
+	infos := h.svc.Formats().List()
+
+	out := make([]CDRFormatDTO, 0, len(infos))
+	for _, info := range infos {
+		out = append(out, CDRFormatDTO{
+			Name:     info.Name,
+			Builtin:  info.Builtin,
+			Mapping:  info.Mapping,
+			Asterisk: info.Asterisk,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, CDRFormatListResponse{Status: "ok", Formats: out})
+}
+
+func (h *Handler) registerCDRFormat(w http.ResponseWriter, r *http.Request) {
+	var req RegisterCDRFormatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", "invalid json body")
+		return
+	}
+
+	var (
+		f   cdrformat.Format
+		err error
+	)
+
+	switch {
+	case req.Mapping != nil && req.Asterisk == nil:
+		f, err = cdrformat.NewMappedFormat(req.Name, *req.Mapping)
+	case req.Asterisk != nil && req.Mapping == nil:
+		f, err = cdrformat.NewAsteriskFormat(req.Name, *req.Asterisk)
+	default:
+		err = errors.New("exactly one of mapping and asterisk must be set")
+	}
+
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	if err := h.svc.Formats().Register(f); err != nil {
+		writeErr(w, http.StatusConflict, "format_exists", err.Error())
+		return
+	}
+
+	resp := CDRFormatDTO{Name: f.Name(), Asterisk: req.Asterisk}
+	if mf, ok := f.(*cdrformat.MappedFormat); ok {
+		m := mf.Mapping() // с заполненными значениями по умолчанию
+		resp.Mapping = &m
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// resolveFormat checks that an input format exists before a file is accepted.
+func (h *Handler) resolveFormat(name string) error {
+	_, err := h.svc.Formats().Get(name)
+	return err
+}
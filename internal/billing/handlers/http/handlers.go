@@ -12,49 +12,147 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"ukrainian_call_center_scam_goev/internal/billing/cdrinput"
 	"ukrainian_call_center_scam_goev/internal/billing/model"
 	billing "ukrainian_call_center_scam_goev/internal/billing/service"
+	"ukrainian_call_center_scam_goev/internal/config"
 )
 
 type Handler struct {
+	cfg      config.Config
 	svc      *billing.Service
-	progress *ProgressStore
+	jobs     *JobStore
 	prepared *PreparedCDRStore
+	rejects  *RejectStore
+	results  *ResultStore // nil when persistence is off
+
+	// checkpoints persist background jobs (nil when persistence is off); jobPins maps job id -> prepared ids.
+	checkpoints     *JobCheckpoints
+	checkpointEvery time.Duration
+	jobPins         sync.Map
+
+	// jobsCtx is the context of background jobs, canceled by Close; jobsWG waits for them.
+	jobsCtx  context.Context
+	stopJobs context.CancelCauseFunc
+	jobsWG   sync.WaitGroup
 }
 
-func NewHandler(svc *billing.Service) (*Handler, error) {
-	prepared, err := NewPreparedCDRStore("", 2*time.Hour)
+// NewHandler uses DataDir (persistence of results, prepared files and jobs; empty keeps them in memory),
+// ResultsRetention and CheckpointInterval of cfg; the whole cfg is shown by GET /api/v1/config.
+func NewHandler(svc *billing.Service, cfg config.Config) (*Handler, error) {
+	var preparedDir string
+	if cfg.DataDir != "" {
+		preparedDir = filepath.Join(cfg.DataDir, "prepared")
+	}
+
+	prepared, err := NewPreparedCDRStore(preparedDir, 2*time.Hour)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Handler{
+	jobsCtx, stopJobs := context.WithCancelCause(context.Background())
+
+	h := &Handler{
+		cfg:      cfg,
 		svc:      svc,
-		progress: NewProgressStore(),
+		jobs:     NewJobStore(time.Hour),
 		prepared: prepared,
-	}, nil
+		rejects:  NewRejectStore(2 * time.Hour),
+		jobsCtx:  jobsCtx,
+		stopJobs: stopJobs,
+	}
+
+	if cfg.DataDir != "" {
+		h.results, err = NewResultStore(filepath.Join(cfg.DataDir, "results"), time.Duration(cfg.ResultsRetention))
+		if err != nil {
+			return nil, err
+		}
+
+		h.checkpoints, err = NewJobCheckpoints(filepath.Join(cfg.DataDir, "jobs"))
+		if err != nil {
+			return nil, err
+		}
+
+		h.checkpointEvery = time.Duration(cfg.CheckpointInterval)
+		h.restoreInterrupted()
+	}
+
+	return h, nil
+}
+
+// Close stops background jobs and waits for them until ctx is done. Jobs stop as on service
+// shutdown (billing.ErrStopped): with DATA_DIR they stay on disk and resume after restart.
+func (h *Handler) Close(ctx context.Context) error {
+	h.stopJobs(billing.ErrStopped)
+
+	done := make(chan struct{})
+	go func() {
+		h.jobsWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background jobs still running: %w", ctx.Err())
+	}
 }
 
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("POST /api/v1/tariffs", h.uploadTariffs)
-	mux.HandleFunc("POST /api/v1/subscribers", h.uploadSubscribers)
+	mux.HandleFunc("POST /api/v1/subscribers", h.postSubscribers)
+	mux.HandleFunc("GET /api/v1/subscribers", h.listSubscribers)
+	mux.HandleFunc("GET /api/v1/subscribers/{phone}", h.getSubscriber)
+	mux.HandleFunc("PUT /api/v1/subscribers/{phone}", h.updateSubscriber)
+	mux.HandleFunc("DELETE /api/v1/subscribers/{phone}", h.deleteSubscriber)
+	mux.HandleFunc("POST /api/v1/attribution", h.uploadAttribution)
+	mux.HandleFunc("GET /api/v1/balances/{phone}", h.getBalance)
+	mux.HandleFunc("POST /api/v1/balances/{phone}/topup", h.topUpBalance)
+	mux.HandleFunc("GET /api/v1/trunks/timezones", h.getTrunkZones)
+	mux.HandleFunc("PUT /api/v1/trunks/timezones", h.putTrunkZones)
+
+	mux.HandleFunc("GET /api/v1/cdr/formats", h.listCDRFormats)
+	mux.HandleFunc("POST /api/v1/cdr/formats", h.registerCDRFormat)
 	mux.HandleFunc("POST /api/v1/cdr/prepare", h.prepareCDR)
 	mux.HandleFunc("POST /api/v1/cdr/start", h.startPreparedCDR)
 	mux.HandleFunc("POST /api/v1/cdr/tariff", h.tariffCDRStream)
 	mux.HandleFunc("GET /api/v1/cdr/progress/{id}", h.getCDRProgress)
+	mux.HandleFunc("GET /api/v1/cdr/progress/{id}/events", h.streamCDRProgress)
+	mux.HandleFunc("POST /api/v1/cdr/progress/{id}/cancel", h.cancelCDRProgress)
+	mux.HandleFunc("GET /api/v1/cdr/rejects/{id}", h.downloadRejects)
+	mux.HandleFunc("POST /api/v1/jobs", h.submitJob)
+	mux.HandleFunc("GET /api/v1/jobs", h.listJobs)
+	mux.HandleFunc("GET /api/v1/jobs/{id}", h.getJob)
+	mux.HandleFunc("GET /api/v1/jobs/{id}/result", h.getJobResult)
+	mux.HandleFunc("POST /api/v1/jobs/{id}/resume", h.resumeJob)
+	mux.HandleFunc("GET /api/v1/results", h.listResults)
+	mux.HandleFunc("GET /api/v1/results/{id}", h.getResult)
+	mux.HandleFunc("GET /api/v1/results/{id}/calls", h.listResultCalls)
+	mux.HandleFunc("DELETE /api/v1/results/{id}", h.deleteResult)
+
+	mux.HandleFunc("GET /api/v1/config", h.getConfig)
 
 	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, OKResponse{Status: "ok"})
 	})
 }
 
+// getConfig shows the effective settings (defaults, config file and env applied).
+func (h *Handler) getConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.cfg.Redacted())
+}
+
 func (h *Handler) uploadTariffs(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -97,7 +195,34 @@ func (h *Handler) uploadSubscribers(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, UploadResponse{Status: "ok"})
 }
 
+func (h *Handler) uploadAttribution(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	reader, closer, _, err := getUploadSource(r, "file")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	if err := h.svc.LoadAttribution(ctx, reader); err != nil {
+		writeErr(w, http.StatusUnprocessableEntity, "load_attribution_failed", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, UploadResponse{Status: "ok"})
+}
+
 func (h *Handler) prepareCDR(w http.ResponseWriter, r *http.Request) {
+	format := strings.TrimSpace(r.URL.Query().Get("format"))
+	if err := h.resolveFormat(format); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
 	reader, closer, fileName, err := getUploadSource(r, "file")
 	if err != nil {
 		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
@@ -108,7 +233,14 @@ func (h *Handler) prepareCDR(w http.ResponseWriter, r *http.Request) {
 		defer closer.Close()
 	}
 
-	meta, err := h.prepared.SaveNormalized(reader, fileName)
+	upload, err := cdrinput.Open(reader, fileName, h.cfg.MaxZipBytes)
+	if err != nil {
+		writeUploadErr(w, err)
+		return
+	}
+	defer upload.Close()
+
+	meta, err := h.prepared.SaveNormalized(upload.Sources, fileName, format)
 	if err != nil {
 		writeErr(w, http.StatusUnprocessableEntity, "prepare_cdr_failed", err.Error())
 		return
@@ -118,8 +250,10 @@ func (h *Handler) prepareCDR(w http.ResponseWriter, r *http.Request) {
 		Status:          "ok",
 		PreparedID:      meta.ID,
 		FileName:        meta.OriginalName,
+		Format:          meta.Format,
 		RowsCount:       meta.RowsCount,
 		NormalizedBytes: meta.NormalizedBytes,
+		Files:           mapPreparedFiles(meta.Files),
 	})
 }
 
@@ -130,37 +264,134 @@ func (h *Handler) startPreparedCDR(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req.PreparedID = strings.TrimSpace(req.PreparedID)
 	req.ProgressID = strings.TrimSpace(req.ProgressID)
-	if req.PreparedID == "" {
-		writeErr(w, http.StatusBadRequest, "bad_request", "prepared_id is required")
+
+	ids, err := req.preparedIDs()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
 		return
 	}
 
-	meta, ok := h.prepared.Get(req.PreparedID)
-	if !ok {
-		writeErr(w, http.StatusNotFound, "not_found", "prepared file not found or expired")
+	opt, err := req.toModel()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
 		return
 	}
 
-	f, err := os.Open(meta.Path)
+	out, err := req.OutputOptionsDTO.parse(r.Header.Get("Accept"))
 	if err != nil {
-		writeErr(w, http.StatusInternalServerError, "open_prepared_failed", err.Error())
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
 		return
 	}
-	defer f.Close()
 
-	report, calcMS, err := h.runTariffing(r.Context(), f, req.CollectCalls, req.ProgressID, meta.NormalizedBytes)
+	if err := h.checkStoreCalls(req.TariffOptionsDTO); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	sources, total, missing := h.preparedSources(ids)
+	if missing != "" {
+		writeErr(w, http.StatusNotFound, "not_found", fmt.Sprintf("prepared file %q not found or expired", missing))
+		return
+	}
+
+	opt.TotalBytes = total
+
+	calls, err := h.newCallStore(req.StoreCalls)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	defer calls.discard()
+
+	keepWriting(w)
+
+	if out.output != outputJSON {
+		h.runFormatted(w, r, sources, opt, req.ProgressID, out, calls, nil)
+		return
+	}
+
+	resp, err := h.runTariffing(r.Context(), sources, opt, req.ProgressID, false, calls, nil)
 	if err != nil {
 		h.writeTariffErr(w, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, buildTariffResponse(report, req.CollectCalls, calcMS))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// preparedSources opens prepared uploads as one run; missing is the first unknown id.
+func (h *Handler) preparedSources(ids []string) (sources []model.CDRSource, totalBytes int64, missing string) {
+	for _, id := range ids {
+		meta, ok := h.prepared.Get(id)
+		if !ok {
+			return nil, 0, id
+		}
+
+		totalBytes += meta.NormalizedBytes
+
+		metaSources := meta.Sources()
+		if len(ids) > 1 {
+			// в общем отчёте файлы разных загрузок различаются по исходному имени
+			for i := range metaSources {
+				metaSources[i].Name = preparedSourceName(meta, metaSources[i].Name)
+			}
+		}
+
+		sources = append(sources, metaSources...)
+	}
+
+	return sources, totalBytes, ""
+}
+
+// maxPreparedPerRun bounds prepared_ids of one /cdr/start request.
+const maxPreparedPerRun = 100
+
+// preparedIDs merges prepared_id and prepared_ids keeping the order.
+func (req StartPreparedCDRRequest) preparedIDs() ([]string, error) {
+	raw := req.PreparedIDs
+	if id := strings.TrimSpace(req.PreparedID); id != "" {
+		raw = append([]string{id}, raw...)
+	}
+
+	ids := make([]string, 0, len(raw))
+	seen := make(map[string]bool, len(raw))
+
+	for _, id := range raw {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+
+		if seen[id] {
+			return nil, fmt.Errorf("prepared id %q is listed twice", id)
+		}
+
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	switch {
+	case len(ids) == 0:
+		return nil, errors.New("prepared_id or prepared_ids is required")
+	case len(ids) > maxPreparedPerRun:
+		return nil, fmt.Errorf("too many prepared ids: %d > %d", len(ids), maxPreparedPerRun)
+	}
+
+	return ids, nil
+}
+
+// preparedSourceName("cdr-02.zip", "day01.txt") => "cdr-02.zip/day01.txt".
+func preparedSourceName(meta PreparedCDRMeta, entry string) string {
+	if len(meta.Files) == 1 {
+		return meta.OriginalName
+	}
+
+	return meta.OriginalName + "/" + entry
 }
 
 func (h *Handler) tariffCDRStream(w http.ResponseWriter, r *http.Request) {
-	reader, closer, _, err := getUploadSource(r, "file")
+	reader, closer, fileName, err := getUploadSource(r, "file")
 	if err != nil {
 		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
 		return
@@ -170,66 +401,233 @@ func (h *Handler) tariffCDRStream(w http.ResponseWriter, r *http.Request) {
 		defer closer.Close()
 	}
 
-	collectCalls := parseBoolQuery(r, "collect_calls", false)
 	progressID := strings.TrimSpace(r.URL.Query().Get("progress_id"))
 
-	totalBytes := parseInt64Query(r, "total_bytes", 0)
-	if totalBytes <= 0 && r.ContentLength > 0 {
-		totalBytes = r.ContentLength
+	dto := tariffOptionsFromQuery(r)
+
+	opt, err := dto.toModel()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	if err := h.checkStoreCalls(dto); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
 	}
 
-	report, calcMS, err := h.runTariffing(r.Context(), reader, collectCalls, progressID, totalBytes)
+	out, err := outputOptionsFromQuery(r).parse(r.Header.Get("Accept"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	opt.Format = strings.TrimSpace(r.URL.Query().Get("format"))
+	if err := h.resolveFormat(opt.Format); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	opt.TotalBytes = parseInt64Query(r, "total_bytes", 0)
+	if opt.TotalBytes <= 0 && r.ContentLength > 0 {
+		opt.TotalBytes = r.ContentLength
+	}
+
+	upload, err := cdrinput.Open(reader, fileName, h.cfg.MaxZipBytes)
+	if err != nil {
+		writeUploadErr(w, err)
+		return
+	}
+	defer upload.Close()
+
+	if upload.CompressedSize > 0 {
+		opt.TotalBytes = upload.CompressedSize
+	}
+
+	calls, err := h.newCallStore(dto.StoreCalls)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	defer calls.discard()
+
+	keepWriting(w)
+
+	if out.output != outputJSON {
+		h.runFormatted(w, r, upload.Sources, opt, progressID, out, calls, upload.Progress)
+		return
+	}
+
+	resp, err := h.runTariffing(r.Context(), upload.Sources, opt, progressID, false, calls, upload.Progress)
 	if err != nil {
 		h.writeTariffErr(w, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, buildTariffResponse(report, collectCalls, calcMS))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// keepWriting lifts the server WriteTimeout for a synchronous run: its response is written
+// only when the run ends, and a large file is rated longer than the timeout.
+func keepWriting(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
 }
 
+// runTariffing rates sources and tracks the run as job progressID (if set).
+// keepResult stores the response in the job for GET /api/v1/jobs/{id}/result.
+// calls (store_calls) receives the rated calls and is saved with the result; the caller discards it.
+// compressed is the progress of an archive upload (nil otherwise): the job counts its compressed
+// bytes instead of the bytes of processed rows.
 func (h *Handler) runTariffing(
 	ctx context.Context,
-	reader io.Reader,
-	collectCalls bool,
+	sources []model.CDRSource,
+	opt model.Options,
 	progressID string,
-	totalBytes int64,
-) (model.Report, float64, error) {
-	var onProcessed func(int64)
+	keepResult bool,
+	calls *callStoreWriter,
+	compressed *cdrinput.Progress,
+) (TariffCDRResponse, error) {
 	if progressID != "" {
-		h.progress.Start(progressID, totalBytes)
-		onProcessed = func(n int64) {
-			h.progress.Add(progressID, int(n))
+		var (
+			release func()
+			err     error
+		)
+
+		// занятый id не трогаем: ошибка не попадает в чужую задачу
+		if ctx, release, err = h.jobs.Start(ctx, progressID, opt.TotalBytes); err != nil {
+			return TariffCDRResponse{}, err
+		}
+		defer release()
+
+		opt.OnStarted = func() { h.jobs.Started(progressID) }
+
+		// прогресс архива — по прочитанным сжатым байтам, подключается только после Start
+		if compressed != nil {
+			compressed.Attach(func(n int) { h.jobs.Add(progressID, n) })
+		} else {
+			opt.OnProcessedBytes = func(n int64) {
+				h.jobs.Add(progressID, int(n))
+			}
+		}
+
+		opt.OnProcessedRows = func(rows, rejected int64) {
+			h.jobs.AddRows(progressID, rows, rejected)
 		}
 	}
 
+	if calls != nil {
+		// поток в ответ (output=ndjson, ...) получает звонок раньше хранилища
+		next := opt.OnCall
+		opt.OnCall = func(c model.RatedCall) error {
+			if next != nil {
+				if err := next(c); err != nil {
+					return err
+				}
+			}
+
+			return calls.add(c)
+		}
+	}
+
+	// возобновлённый расчёт читает не всё, его вход хэшируется отдельным проходом в конце
+	var ih *inputHash
+	if h.results != nil && opt.Resume == nil {
+		sources, ih = hashSources(sources)
+	}
+
 	started := time.Now()
-	report, err := h.svc.TariffCDRStream(ctx, reader, model.Options{
-		CollectCalls:     collectCalls,
-		TotalBytes:       totalBytes,
-		OnProcessedBytes: onProcessed,
-		DemoSleepPerLine: 0,
-	})
-	calcMS := float64(time.Since(started).Microseconds()) / 1000
+	report, err := h.svc.TariffCDRSources(ctx, sources, opt)
+	finished := time.Now()
+	calcMS := float64(finished.Sub(started).Microseconds()) / 1000
+
+	if err != nil {
+		// отмена могла прийти раньше, чем ошибка чтения или воркера
+		if cause := context.Cause(ctx); errors.Is(cause, errJobCanceled) {
+			err = cause
+		}
+	}
+
+	// списания с балансов — только за успешный расчёт; клиент, ушедший после расчёта, их не отменяет
+	if err == nil {
+		err = h.svc.CommitRun(context.WithoutCancel(ctx), report)
+	}
 
 	if err != nil {
 		if progressID != "" {
-			h.progress.Fail(progressID, err)
+			h.jobs.Fail(progressID, err)
+		}
+		return TariffCDRResponse{}, err
+	}
+
+	resp := h.buildTariffResponse(report, opt.CollectCalls, calcMS)
+
+	if h.results != nil && ih == nil {
+		if ih, err = hashInput(sources); err != nil {
+			log.Printf("hash resumed input: %v", err)
+		}
+	}
+
+	if h.results != nil && ih != nil {
+		if calls != nil {
+			resp.StoredCalls = calls.mark.Calls
+		}
+
+		meta := newResultMeta(progressID, started, finished, sources, ih, report, resp)
+
+		// расчёт уже прошёл: без сохранения клиент всё равно получит отчёт в ответе
+		if id, err := h.results.Save(meta, resp, calls); err != nil {
+			log.Printf("save result: %v", err)
+		} else {
+			resp.ResultID = id
 		}
-		return model.Report{}, calcMS, err
 	}
 
 	if progressID != "" {
-		h.progress.Done(progressID)
+		var result *TariffCDRResponse
+		if keepResult {
+			result = &resp
+		}
+
+		h.jobs.Done(progressID, newRunSummary(resp), result)
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) buildTariffResponse(report model.Report, collectCalls bool, calcMS float64) TariffCDRResponse {
+	resp := newTariffResponse(report, collectCalls, calcMS)
+
+	if len(report.Rejects.Rows) > 0 {
+		// отчёт важнее ссылки на скачивание: при ошибке просто не отдаём download_id
+		if id, err := h.rejects.Save(report.Rejects); err == nil {
+			resp.Rejects.DownloadID = id
+			resp.Rejects.DownloadRows = len(report.Rejects.Rows)
+
+			// полный список — в выгрузке, в ответе только начало
+			if len(resp.Rejects.Rows) > maxInlineRejects {
+				resp.Rejects.Rows = resp.Rejects.Rows[:maxInlineRejects]
+				resp.Rejects.Truncated = true
+			}
+		}
 	}
 
-	return report, calcMS, nil
+	return resp
 }
 
-func buildTariffResponse(report model.Report, collectCalls bool, calcMS float64) TariffCDRResponse {
+func newTariffResponse(report model.Report, collectCalls bool, calcMS float64) TariffCDRResponse {
 	resp := TariffCDRResponse{
 		Status:        "ok",
 		CalculationMS: calcMS,
 		Totals:        mapTotals(report.Totals),
+
+		UnknownSubscribers: mapUnknownSubscribers(report.UnknownSubscribers),
+		Balances:           mapBalanceSummaries(report.Balances),
+		BalancesCharged:    report.Charges != nil,
+		CreditEvents:       mapCreditEvents(report.CreditEvents),
+		Rejects:            mapRejects(report.Rejects),
+		Violations:         mapViolations(report.Violations),
+		Duplicates:         mapDuplicates(report.Duplicates),
+		Files:              mapFileStats(report.Files),
 	}
 	if collectCalls {
 		resp.Calls = mapCalls(report.Calls)
@@ -242,7 +640,11 @@ func (h *Handler) writeTariffErr(w http.ResponseWriter, err error) {
 	code := "tariff_cdr_failed"
 	status := http.StatusUnprocessableEntity
 
-	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+	switch {
+	case errors.Is(err, errJobExists):
+		code = "already_exists"
+		status = http.StatusConflict
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
 		code = "request_canceled"
 		status = 499
 	}
@@ -257,13 +659,13 @@ func (h *Handler) getCDRProgress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snap, ok := h.progress.Get(id)
+	snap, ok := h.jobs.Get(id)
 	if !ok {
 		writeErr(w, http.StatusNotFound, "not_found", "progress id not found")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, snap)
+	writeJSON(w, http.StatusOK, snap.CDRProgressResponse)
 }
 
 func mapTotals(in []model.SubscriberTotal) []SubscriberTotalDTO {
@@ -280,38 +682,129 @@ func mapTotals(in []model.SubscriberTotal) []SubscriberTotalDTO {
 	return out
 }
 
+func mapUnknownSubscribers(in []model.UnknownSubscriber) []UnknownSubscriberDTO {
+	out := make([]UnknownSubscriberDTO, 0, len(in))
+	for _, u := range in {
+		out = append(out, UnknownSubscriberDTO{
+			PhoneNumber:    u.PhoneNumber,
+			CallsCount:     u.CallsCount,
+			WouldBeCostKop: int64(u.WouldBeCost),
+		})
+	}
+
+	return out
+}
+
+func mapCreditEvents(in []model.CreditEvent) []CreditEventDTO {
+	out := make([]CreditEventDTO, 0, len(in))
+	for _, ev := range in {
+		out = append(out, CreditEventDTO{
+			PhoneNumber:    ev.PhoneNumber,
+			ClientName:     ev.ClientName,
+			ThresholdPct:   ev.ThresholdPct,
+			CreditLimitKop: int64(ev.CreditLimit),
+			TotalCostKop:   int64(ev.TotalCost),
+			CallID:         ev.CallID,
+			At:             ev.At.Format(time.RFC3339),
+		})
+	}
+
+	return out
+}
+
+func mapViolations(in []model.RuleViolations) []RuleViolationsDTO {
+	out := make([]RuleViolationsDTO, 0, len(in))
+	for _, v := range in {
+		out = append(out, RuleViolationsDTO{Rule: v.Rule.String(), Severity: v.Severity.String(), Count: v.Count})
+	}
+
+	return out
+}
+
+func mapDuplicates(in model.DuplicateStats) DuplicatesDTO {
+	out := DuplicatesDTO{
+		Mode:      in.Mode.String(),
+		Count:     in.Count,
+		Truncated: in.Truncated,
+		Rows:      make([]DuplicateRowDTO, 0, len(in.Rows)),
+	}
+
+	for _, row := range in.Rows {
+		out.Rows = append(out.Rows, DuplicateRowDTO{
+			CallID:      row.CallID,
+			KeptFile:    row.KeptFile,
+			KeptLine:    row.KeptLine,
+			DroppedFile: row.DroppedFile,
+			DroppedLine: row.DroppedLine,
+		})
+	}
+
+	return out
+}
+
+func mapFileStats(in []model.FileStats) []FileStatsDTO {
+	out := make([]FileStatsDTO, 0, len(in))
+	for _, f := range in {
+		out = append(out, FileStatsDTO{
+			Name:       f.Name,
+			Rows:       f.Rows,
+			Rejected:   f.Rejected,
+			Duplicates: f.Duplicates,
+			Calls:      f.Calls,
+			CostKop:    int64(f.Cost),
+		})
+	}
+
+	return out
+}
+
+func mapPreparedFiles(in []PreparedCDRFile) []PreparedCDRFileDTO {
+	out := make([]PreparedCDRFileDTO, 0, len(in))
+	for _, f := range in {
+		out = append(out, PreparedCDRFileDTO{Name: f.Name, RowsCount: f.RowsCount, NormalizedBytes: f.NormalizedBytes})
+	}
+
+	return out
+}
+
 func mapCalls(in []model.RatedCall) []RatedCallDTO {
 	out := make([]RatedCallDTO, 0, len(in))
 	for _, c := range in {
-		var tr *AppliedTariffRefDTO
-		if c.Tariff != nil {
-			tr = &AppliedTariffRefDTO{
-				Prefix:      c.Tariff.Prefix,
-				Destination: c.Tariff.Destination,
-				Priority:    c.Tariff.Priority,
-			}
-		}
-
-		out = append(out, RatedCallDTO{
-			StartTime:     c.StartTime.Format(time.RFC3339),
-			EndTime:       c.EndTime.Format(time.RFC3339),
-			CallingParty:  string(c.CallingParty),
-			CalledParty:   string(c.CalledParty),
-			CallDirection: c.Direction.String(),
-			Disposition:   c.Disposition.String(),
-			Duration:      c.Duration,
-			BillableSec:   c.BillableSec,
-			AccountCode:   c.AccountCode,
-			CallID:        c.CallID,
-			TrunkName:     c.TrunkName,
-			CostKop:       int64(c.Cost),
-			Tariff:        tr,
-		})
+		out = append(out, mapCall(c))
 	}
 
 	return out
 }
 
+func mapCall(c model.RatedCall) RatedCallDTO {
+	var tr *AppliedTariffRefDTO
+	if c.Tariff != nil {
+		tr = &AppliedTariffRefDTO{
+			Prefix:      c.Tariff.Prefix,
+			Destination: c.Tariff.Destination,
+			Priority:    c.Tariff.Priority,
+		}
+	}
+
+	return RatedCallDTO{
+		StartTime:     c.StartTime.Format(time.RFC3339),
+		EndTime:       c.EndTime.Format(time.RFC3339),
+		CallingParty:  string(c.CallingParty),
+		CalledParty:   string(c.CalledParty),
+		CallDirection: c.Direction.String(),
+		Disposition:   c.Disposition.String(),
+		Duration:      c.Duration,
+		BillableSec:   c.BillableSec,
+		AccountCode:   c.AccountCode,
+		CallID:        c.CallID,
+		TrunkName:     c.TrunkName,
+		Subscriber:    c.SubscriberPhone,
+		Attributes:    c.Attributes,
+		CostKop:       int64(c.Cost),
+		Tariff:        tr,
+	}
+}
+
 func getUploadSource(r *http.Request, fieldName string) (io.Reader, io.Closer, string, error) {
 	ct := r.Header.Get("Content-Type")
 
@@ -345,8 +838,22 @@ func getUploadSource(r *http.Request, fieldName string) (io.Reader, io.Closer, s
 	return r.Body, r.Body, "upload", nil
 }
 
+// writeUploadErr answers an upload that could not be opened.
+func writeUploadErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, cdrinput.ErrZipTooLarge) {
+		writeErr(w, http.StatusRequestEntityTooLarge, "too_large", err.Error())
+		return
+	}
+
+	writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+}
+
 func parseBoolQuery(r *http.Request, key string, def bool) bool {
-	v := strings.TrimSpace(r.URL.Query().Get(key))
+	return parseBool(r.URL.Query().Get(key), def)
+}
+
+func parseBool(v string, def bool) bool {
+	v = strings.TrimSpace(v)
 	if v == "" {
 		return def
 	}
@@ -360,7 +867,11 @@ func parseBoolQuery(r *http.Request, key string, def bool) bool {
 }
 
 func parseInt64Query(r *http.Request, key string, def int64) int64 {
-	v := strings.TrimSpace(r.URL.Query().Get(key))
+	return parseInt64(r.URL.Query().Get(key), def)
+}
+
+func parseInt64(v string, def int64) int64 {
+	v = strings.TrimSpace(v)
 	if v == "" {
 		return def
 	}
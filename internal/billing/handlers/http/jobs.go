@@ -0,0 +1,915 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+	billing "ukrainian_call_center_scam_goev/internal/billing/service"
+)
+
+const (
+	jobQueued     = "queued"
+	jobProcessing = "processing"
+	jobDone       = "done"
+	jobError      = "error"
+	jobCanceled   = "canceled"
+
+	// jobInterrupted: the previous process stopped during the job, it waits for resume.
+	jobInterrupted = "interrupted"
+)
+
+var (
+	errJobExists   = errors.New("job with this id is still running")
+	errJobFinished = errors.New("job is already finished")
+	errNotFoundJob = errors.New("job not found")
+
+	// errJobCanceled is the cancel cause; it wraps context.Canceled so the run stops as usual.
+	errJobCanceled = fmt.Errorf("canceled by request: %w", context.Canceled)
+)
+
+// CDRProgressResponse is the progress of a rating in-flight (polled or streamed as events).
+// progress_pct is null when total_bytes is unknown, eta_sec also while throughput is not measured yet.
+type CDRProgressResponse struct {
+	Status      string `json:"status"` // queued | processing | done | error | canceled | interrupted
+	ProgressPct *int   `json:"progress_pct"`
+	ReadBytes   int64  `json:"read_bytes"`
+	TotalBytes  int64  `json:"total_bytes"`
+	UpdatedAt   string `json:"updated_at"`
+	Error       string `json:"error,omitempty"`
+
+	RowsProcessed int64   `json:"rows_processed"`
+	RowsRejected  int64   `json:"rows_rejected"`
+	RowsPerSec    float64 `json:"rows_per_sec"`
+	BytesPerSec   float64 `json:"bytes_per_sec"`
+	ETASec        *int    `json:"eta_sec"`
+}
+
+// RunSummaryDTO sums up a finished run for progress watchers; the full report is in the response,
+// GET /api/v1/jobs/{id}/result or GET /api/v1/results/{id}.
+type RunSummaryDTO struct {
+	ResultID           string  `json:"result_id,omitempty"`
+	CalculationMS      float64 `json:"calculation_ms"`
+	Subscribers        int     `json:"subscribers"`
+	CallsCount         int64   `json:"calls_count"`
+	TotalCostKop       int64   `json:"total_cost_kop"`
+	UnknownSubscribers int     `json:"unknown_subscribers"`
+	Rejected           int64   `json:"rejected"`
+	Duplicates         int64   `json:"duplicates"`
+	StoredCalls        int64   `json:"stored_calls,omitempty"`
+}
+
+// JobResponse is GET /api/v1/jobs/{id}: progress plus job timestamps.
+type JobResponse struct {
+	JobID string `json:"job_id"`
+	CDRProgressResponse
+
+	CreatedAt    string `json:"created_at"`
+	StartedAt    string `json:"started_at,omitempty"`
+	FinishedAt   string `json:"finished_at,omitempty"`
+	CheckpointAt string `json:"checkpoint_at,omitempty"` // last checkpoint the job can be resumed from
+
+	Summary *RunSummaryDTO `json:"summary,omitempty"` // set when the job is done
+}
+
+type JobSubmitResponse struct {
+	Status string `json:"status"`
+	JobID  string `json:"job_id"`
+}
+
+type JobListResponse struct {
+	Jobs []JobResponse `json:"jobs"`
+}
+
+type job struct {
+	mu        sync.Mutex
+	id        string
+	status    string
+	err       string
+	total     int64
+	rate      progressRate
+	createdAt time.Time
+	startedAt time.Time
+	updatedAt time.Time
+	doneAt    time.Time
+
+	checkpointAt time.Time
+
+	// attached is set once a run took the job (Start); a submitted job waits for it in queued.
+	attached bool
+
+	// cancel stops the run; nil until the job is started.
+	cancel context.CancelCauseFunc
+
+	// result is kept until the job expires; synchronous runs don't store it.
+	result  *TariffCDRResponse
+	summary *RunSummaryDTO
+
+	// Counters of the run are updated for every row without the job lock (read is not clamped
+	// to total, see readLocked); watchers hear of them at most every jobNotifyEvery (progressed).
+	read, rows, rejected atomic.Int64
+	notifiedAt           atomic.Int64 // unix nanoseconds
+
+	// watchers are signalled on changes of the job (see Watch); they follow the id when
+	// a new job replaces this one.
+	watchers map[chan struct{}]struct{}
+}
+
+// jobNotifyEvery bounds how often row and byte counters signal watchers; status changes signal at once.
+const jobNotifyEvery = 50 * time.Millisecond
+
+// progressRateWindow is the shortest interval throughput is measured over.
+const progressRateWindow = time.Second
+
+// progressRate is the throughput of a job, smoothed over recent windows. It is sampled
+// when the progress is read, so a job nobody watches doesn't pay for it.
+type progressRate struct {
+	at          time.Time // start of the current window
+	read, rows  int64
+	bytesPerSec float64
+	rowsPerSec  float64
+}
+
+func (r *progressRate) sample(now time.Time, read, rows int64) {
+	if r.at.IsZero() {
+		r.at, r.read, r.rows = now, read, rows
+		return
+	}
+
+	dt := now.Sub(r.at).Seconds()
+	if dt < progressRateWindow.Seconds() {
+		return
+	}
+
+	bps := float64(read-r.read) / dt
+	rps := float64(rows-r.rows) / dt
+
+	if r.bytesPerSec == 0 && r.rowsPerSec == 0 {
+		r.bytesPerSec, r.rowsPerSec = bps, rps
+	} else {
+		// половина веса у последнего окна: скорость не скачет от каждой паузы воркеров
+		r.bytesPerSec = (r.bytesPerSec + bps) / 2
+		r.rowsPerSec = (r.rowsPerSec + rps) / 2
+	}
+
+	r.at, r.read, r.rows = now, read, rows
+}
+
+// JobStore keeps rating runs keyed by job id (progress_id of the synchronous endpoints).
+// It is in-memory: finished jobs and their reports live for ttl.
+type JobStore struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	items map[string]*job
+}
+
+func NewJobStore(ttl time.Duration) *JobStore {
+	return &JobStore{ttl: ttl, items: make(map[string]*job, 64)}
+}
+
+// Submit registers a queued background job. Empty id gets a random one.
+func (s *JobStore) Submit(id string, totalBytes int64) (string, error) {
+	if id == "" {
+		var err error
+		if id, err = randomID(); err != nil {
+			return "", err
+		}
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cleanupLocked(now)
+
+	if it := s.items[id]; it != nil {
+		it.mu.Lock()
+		finished := !it.doneAt.IsZero()
+		it.mu.Unlock()
+
+		if !finished {
+			return "", errJobExists
+		}
+	}
+
+	s.replaceLocked(&job{
+		id:        id,
+		status:    jobQueued,
+		total:     totalBytes,
+		createdAt: now,
+		updatedAt: now,
+	})
+
+	return id, nil
+}
+
+// Start attaches a run to a queued job; for synchronous runs it creates the job, replacing
+// a finished one with the same id (a running one is errJobExists). The job stays queued until
+// the service admits the run (Started). The returned ctx is canceled by Cancel; release must be
+// called when the run is over.
+func (s *JobStore) Start(parent context.Context, id string, totalBytes int64) (ctx context.Context, release func(), err error) {
+	ctx, cancel := context.WithCancelCause(parent)
+	release = func() { cancel(nil) }
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if it := s.items[id]; it != nil {
+		it.mu.Lock()
+		submitted := !it.attached
+		running := it.doneAt.IsZero()
+		switch {
+		case it.status == jobQueued && submitted:
+			it.attached = true
+			it.total = totalBytes
+			it.updatedAt = now
+			it.cancel = cancel
+			it.notifyLocked()
+		case it.status == jobCanceled && submitted:
+			it.attached = true
+			// отменили, пока задача ждала запуска
+			cancel(errJobCanceled)
+		default:
+			submitted = false
+		}
+		it.mu.Unlock()
+
+		if submitted {
+			return ctx, release, nil
+		}
+
+		if running {
+			cancel(nil)
+			return nil, nil, errJobExists
+		}
+	}
+
+	s.cleanupLocked(now)
+	s.replaceLocked(&job{
+		id:        id,
+		status:    jobQueued,
+		total:     totalBytes,
+		createdAt: now,
+		updatedAt: now,
+		attached:  true,
+		cancel:    cancel,
+	})
+
+	return ctx, release, nil
+}
+
+// replaceLocked puts a new job under its id. Watchers of the job it replaces move to it: a progress
+// stream follows the id, not the run it started watching.
+func (s *JobStore) replaceLocked(it *job) {
+	if old := s.items[it.id]; old != nil {
+		old.mu.Lock()
+		it.watchers, old.watchers = old.watchers, nil
+		old.mu.Unlock()
+
+		it.notifyLocked()
+	}
+
+	s.items[it.id] = it
+}
+
+// Started marks the job as processing: its run got a slot and reads the input.
+func (s *JobStore) Started(id string) {
+	it := s.get(id)
+	if it == nil {
+		return
+	}
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.status == jobQueued {
+		now := time.Now()
+		it.status = jobProcessing
+		it.startedAt = now
+		it.updatedAt = now
+		// время в очереди в скорость не входит
+		it.rate = progressRate{}
+		it.notifyLocked()
+	}
+}
+
+// Cancel asks a running job to stop. The job becomes canceled once the run has drained;
+// a queued job is canceled right away.
+func (s *JobStore) Cancel(id string) (JobResponse, error) {
+	it := s.get(id)
+	if it == nil {
+		return JobResponse{}, errNotFoundJob
+	}
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if !it.doneAt.IsZero() {
+		return it.snapshotLocked(), errJobFinished
+	}
+
+	if it.cancel != nil {
+		it.cancel(errJobCanceled)
+	} else {
+		it.status = jobCanceled
+		it.updatedAt = time.Now()
+		it.doneAt = it.updatedAt
+		it.notifyLocked()
+	}
+
+	return it.snapshotLocked(), nil
+}
+
+// Restore registers a job interrupted by the previous process.
+func (s *JobStore) Restore(id string, totalBytes, readBytes int64, createdAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it := &job{
+		id:        id,
+		status:    jobInterrupted,
+		total:     totalBytes,
+		createdAt: createdAt,
+		updatedAt: time.Now(),
+	}
+	it.read.Store(readBytes)
+
+	s.items[id] = it
+}
+
+// Resume queues an interrupted job again. Progress restarts from zero: the resumed run
+// reports the bytes handled before the checkpoint first.
+func (s *JobStore) Resume(id string) error {
+	it := s.get(id)
+	if it == nil {
+		return errNotFoundJob
+	}
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.status != jobInterrupted {
+		return fmt.Errorf("job is %s", it.status)
+	}
+
+	it.status = jobQueued
+	it.read.Store(0)
+	it.rows.Store(0)
+	it.rejected.Store(0)
+	it.updatedAt = time.Now()
+	it.notifyLocked()
+
+	return nil
+}
+
+// Checkpointed records that the job can be resumed from now on.
+func (s *JobStore) Checkpointed(id string) {
+	it := s.get(id)
+	if it == nil {
+		return
+	}
+
+	it.mu.Lock()
+	it.checkpointAt = time.Now()
+	it.notifyLocked()
+	it.mu.Unlock()
+}
+
+func (s *JobStore) Add(id string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	it := s.get(id)
+	if it == nil {
+		return
+	}
+
+	it.read.Add(int64(n))
+	it.progressed()
+}
+
+// AddRows counts handled rows of the job (model.Options.OnProcessedRows).
+func (s *JobStore) AddRows(id string, rows, rejected int64) {
+	it := s.get(id)
+	if it == nil {
+		return
+	}
+
+	it.rows.Add(rows)
+	it.rejected.Add(rejected)
+	it.progressed()
+}
+
+// Done finishes the job with the summary of its report; result may be nil
+// (synchronous runs return the report in the response).
+func (s *JobStore) Done(id string, summary RunSummaryDTO, result *TariffCDRResponse) {
+	it := s.get(id)
+	if it == nil {
+		return
+	}
+
+	it.mu.Lock()
+	it.status = jobDone
+	it.result = result
+	it.summary = &summary
+	it.cancel = nil
+	it.updatedAt = time.Now()
+	it.doneAt = it.updatedAt
+	// если total известен, сделаем "красивые" 100%.
+	if it.total > 0 {
+		it.read.Store(it.total)
+	}
+	it.notifyLocked()
+	it.mu.Unlock()
+}
+
+// Fail finishes the job with an error; errJobCanceled marks it canceled.
+func (s *JobStore) Fail(id string, err error) {
+	it := s.get(id)
+	if it == nil {
+		return
+	}
+
+	it.mu.Lock()
+	it.status = jobError
+	if errors.Is(err, errJobCanceled) {
+		it.status = jobCanceled
+	}
+	if err != nil {
+		it.err = err.Error()
+	}
+	it.cancel = nil
+	it.updatedAt = time.Now()
+	it.doneAt = it.updatedAt
+	it.notifyLocked()
+	it.mu.Unlock()
+}
+
+func (s *JobStore) Get(id string) (JobResponse, bool) {
+	it := s.get(id)
+	if it == nil {
+		return JobResponse{}, false
+	}
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.rate.sample(time.Now(), it.readLocked(), it.rows.Load())
+
+	return it.snapshotLocked(), true
+}
+
+// Watch subscribes to changes of the job: the channel is signalled after updates, several updates
+// in a row may come as one signal. Any number of watchers is fanned out without blocking the run;
+// stop must be called when the watcher is done.
+func (s *JobStore) Watch(id string) (changed <-chan struct{}, stop func(), ok bool) {
+	if s.get(id) == nil {
+		return nil, nil, false
+	}
+
+	ch := make(chan struct{}, 1)
+
+	// наблюдатели переходят к новой задаче под блокировкой стора (replaceLocked), поэтому
+	// подписка и отписка берут задачу, которая сейчас под этим id
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	it := s.items[id]
+	if it == nil {
+		return nil, nil, false
+	}
+
+	it.mu.Lock()
+	if it.watchers == nil {
+		it.watchers = make(map[chan struct{}]struct{})
+	}
+	it.watchers[ch] = struct{}{}
+	it.mu.Unlock()
+
+	stop = func() {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		if it := s.items[id]; it != nil {
+			it.mu.Lock()
+			delete(it.watchers, ch)
+			it.mu.Unlock()
+		}
+	}
+
+	return ch, stop, true
+}
+
+// Result returns the job state and its report (nil until the job is done).
+func (s *JobStore) Result(id string) (JobResponse, *TariffCDRResponse, bool) {
+	it := s.get(id)
+	if it == nil {
+		return JobResponse{}, nil, false
+	}
+
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	return it.snapshotLocked(), it.result, true
+}
+
+// List returns live jobs, newest first.
+func (s *JobStore) List() []JobResponse {
+	s.mu.Lock()
+	s.cleanupLocked(time.Now())
+	items := make([]*job, 0, len(s.items))
+	for _, it := range s.items {
+		items = append(items, it)
+	}
+	s.mu.Unlock()
+
+	// createdAt не меняется после создания, читаем без блокировки задачи
+	sort.Slice(items, func(i, j int) bool { return items[i].createdAt.After(items[j].createdAt) })
+
+	out := make([]JobResponse, 0, len(items))
+	for _, it := range items {
+		it.mu.Lock()
+		out = append(out, it.snapshotLocked())
+		it.mu.Unlock()
+	}
+
+	return out
+}
+
+func (s *JobStore) get(id string) *job {
+	s.mu.RLock()
+	it := s.items[id]
+	s.mu.RUnlock()
+	if it == nil {
+		return nil
+	}
+
+	// best-effort cleanup: if done long ago, drop it.
+	it.mu.Lock()
+	doneAt := it.doneAt
+	it.mu.Unlock()
+
+	if !doneAt.IsZero() && time.Since(doneAt) > s.ttl {
+		s.mu.Lock()
+		delete(s.items, id)
+		s.mu.Unlock()
+		return nil
+	}
+
+	return it
+}
+
+func (s *JobStore) cleanupLocked(now time.Time) {
+	// cheap cleanup: drop finished items older than TTL.
+	for id, it := range s.items {
+		it.mu.Lock()
+		doneAt := it.doneAt
+		it.mu.Unlock()
+		if !doneAt.IsZero() && now.Sub(doneAt) > s.ttl {
+			delete(s.items, id)
+		}
+	}
+}
+
+// progressed signals watchers of counter updates, at most every jobNotifyEvery.
+func (it *job) progressed() {
+	now := time.Now()
+
+	last := it.notifiedAt.Load()
+	if now.UnixNano()-last < int64(jobNotifyEvery) || !it.notifiedAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	it.mu.Lock()
+	it.updatedAt = now
+	it.notifyLocked()
+	it.mu.Unlock()
+}
+
+// readLocked is the bytes read, at most total (a resumed run reports bytes before its checkpoint again).
+func (it *job) readLocked() int64 {
+	read := it.read.Load()
+	if it.total > 0 && read > it.total {
+		read = it.total
+	}
+
+	return read
+}
+
+func (it *job) notifyLocked() {
+	for ch := range it.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (it *job) snapshotLocked() JobResponse {
+	read := it.readLocked()
+
+	var pct *int
+	if it.total > 0 {
+		p := int((read * 100) / it.total)
+		if p < 0 {
+			p = 0
+		}
+		if p > 100 {
+			p = 100
+		}
+		pct = &p
+	}
+
+	return JobResponse{
+		JobID: it.id,
+		CDRProgressResponse: CDRProgressResponse{
+			Status:      it.status,
+			ProgressPct: pct,
+			ReadBytes:   read,
+			TotalBytes:  it.total,
+			UpdatedAt:   it.updatedAt.UTC().Format(time.RFC3339),
+			Error:       it.err,
+
+			RowsProcessed: it.rows.Load(),
+			RowsRejected:  it.rejected.Load(),
+			RowsPerSec:    math.Round(it.rate.rowsPerSec),
+			BytesPerSec:   math.Round(it.rate.bytesPerSec),
+			ETASec:        it.etaLocked(),
+		},
+		CreatedAt:    it.createdAt.UTC().Format(time.RFC3339),
+		StartedAt:    formatJobTime(it.startedAt),
+		FinishedAt:   formatJobTime(it.doneAt),
+		CheckpointAt: formatJobTime(it.checkpointAt),
+		Summary:      it.summary,
+	}
+}
+
+// etaLocked estimates seconds left by the byte throughput; nil when it can't be told.
+func (it *job) etaLocked() *int {
+	if it.status != jobProcessing || it.total <= 0 || it.rate.bytesPerSec <= 0 {
+		return nil
+	}
+
+	eta := int(math.Ceil(float64(it.total-it.readLocked()) / it.rate.bytesPerSec))
+
+	return &eta
+}
+
+func newRunSummary(resp TariffCDRResponse) RunSummaryDTO {
+	s := RunSummaryDTO{
+		ResultID:           resp.ResultID,
+		CalculationMS:      resp.CalculationMS,
+		Subscribers:        len(resp.Totals),
+		UnknownSubscribers: len(resp.UnknownSubscribers),
+		Rejected:           resp.Rejects.Count,
+		Duplicates:         resp.Duplicates.Count,
+		StoredCalls:        resp.StoredCalls,
+	}
+
+	for _, t := range resp.Totals {
+		s.CallsCount += int64(t.CallsCount)
+		s.TotalCostKop += t.TotalCostKop
+	}
+
+	return s
+}
+
+func formatJobTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// submitJob starts rating of prepared uploads in the background; the body is the same as for /cdr/start
+// (progress_id, if set, becomes the job id).
+func (h *Handler) submitJob(w http.ResponseWriter, r *http.Request) {
+	var req StartPreparedCDRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", "invalid json body")
+		return
+	}
+
+	ids, err := req.preparedIDs()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	opt, err := req.toModel()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	// отчёт задачи читают потом через /result, передавать его потоком некуда
+	if out, err := req.OutputOptionsDTO.parse(""); err != nil || out.output != outputJSON {
+		writeErr(w, http.StatusBadRequest, "bad_request", "output: background jobs keep a JSON report")
+		return
+	}
+
+	if err := h.checkStoreCalls(req.TariffOptionsDTO); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	sources, total, missing := h.preparedSources(ids)
+	if missing != "" {
+		writeErr(w, http.StatusNotFound, "not_found", fmt.Sprintf("prepared file %q not found or expired", missing))
+		return
+	}
+
+	opt.TotalBytes = total
+
+	id, err := h.jobs.Submit(strings.TrimSpace(req.ProgressID), opt.TotalBytes)
+	if err != nil {
+		if errors.Is(err, errJobExists) {
+			writeErr(w, http.StatusConflict, "already_exists", err.Error())
+			return
+		}
+
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	if err := h.persistJob(id, req, ids, opt.TotalBytes); err != nil {
+		h.jobs.Fail(id, err)
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	var calls *callStoreWriter
+	if req.StoreCalls {
+		if calls, err = h.checkpoints.OpenCallStore(id, nil); err != nil {
+			h.jobs.Fail(id, err)
+			h.releaseJob(id)
+			writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+	}
+
+	h.startJob(id, sources, opt, calls)
+
+	w.Header().Set("Location", "/api/v1/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, JobSubmitResponse{Status: "accepted", JobID: id})
+}
+
+// startJob runs a background job in its own goroutine; Close waits for it.
+func (h *Handler) startJob(id string, sources []model.CDRSource, opt model.Options, calls *callStoreWriter) {
+	h.jobsWG.Add(1)
+
+	go func() {
+		defer h.jobsWG.Done()
+		h.runJob(id, sources, opt, calls)
+	}()
+}
+
+// runJob runs a background job. With DATA_DIR the job takes checkpoints and stays on disk
+// until it ends, so that a restarted service can resume it. calls is the job's call store (store_calls).
+func (h *Handler) runJob(id string, sources []model.CDRSource, opt model.Options, calls *callStoreWriter) {
+	if h.checkpoints != nil && h.checkpointEvery > 0 {
+		opt.CheckpointEvery = h.checkpointEvery
+		opt.OnCheckpoint = func(cp model.Checkpoint) error {
+			saved := jobCheckpoint{Checkpoint: cp}
+
+			// собранные звонки дописываются в отдельный файл, в JSON чекпоинта их нет
+			end, err := h.checkpoints.AppendCollected(id, cp.Calls)
+			if err != nil {
+				return err
+			}
+
+			saved.Checkpoint.Calls = nil
+			saved.Collected = end
+
+			// звонки до чекпоинта уже отданы хранилищу: к этому моменту все строки посчитаны и их звонки записаны
+			if calls != nil {
+				mark, err := calls.sync()
+				if err != nil {
+					return err
+				}
+
+				saved.Calls = &mark
+			}
+
+			if err := h.checkpoints.SaveCheckpoint(id, saved); err != nil {
+				return err
+			}
+
+			h.jobs.Checkpointed(id)
+
+			return nil
+		}
+	}
+
+	// задача переживает запрос, но не сервер: её контекст отменяет Close;
+	// ошибка уже сохранена в задаче
+	_, err := h.runTariffing(h.jobsCtx, sources, opt, id, true, calls, nil)
+	if errors.Is(err, billing.ErrStopped) {
+		// сервис останавливается: задача остаётся на диске и продолжится после перезапуска
+		if calls != nil {
+			_ = calls.close()
+		}
+
+		return
+	}
+
+	calls.discard()
+	h.releaseJob(id)
+}
+
+func (h *Handler) listJobs(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, JobListResponse{Jobs: h.jobs.List()})
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	snap, ok := h.jobs.Get(strings.TrimSpace(r.PathValue("id")))
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "job not found or expired")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snap)
+}
+
+func (h *Handler) getJobResult(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	snap, result, ok := h.jobs.Result(id)
+	if !ok {
+		// после перезапуска задачи в памяти нет, но её отчёт мог сохраниться
+		if meta, found := h.storedJobResult(id); found {
+			h.writeStoredReport(w, meta.ID, nil)
+			return
+		}
+
+		writeErr(w, http.StatusNotFound, "not_found", "job not found or expired")
+		return
+	}
+
+	switch snap.Status {
+	case jobDone:
+		if result == nil {
+			// синхронный расчёт: отчёт уже отдан в ответе /cdr/start или /cdr/tariff
+			writeErr(w, http.StatusNotFound, "not_found", "job result is not stored for synchronous runs")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	case jobError:
+		writeErr(w, http.StatusUnprocessableEntity, "tariff_cdr_failed", snap.Error)
+	case jobCanceled:
+		writeErr(w, http.StatusConflict, "job_canceled", "job is canceled")
+	default:
+		writeErr(w, http.StatusConflict, "job_not_finished", "job is "+snap.Status)
+	}
+}
+
+func (h *Handler) storedJobResult(id string) (ResultMeta, bool) {
+	if h.results == nil {
+		return ResultMeta{}, false
+	}
+
+	meta, ok := h.results.Get(id)
+
+	return meta, ok && meta.JobID == id
+}
+
+// cancelCDRProgress stops a rating by its progress_id (job id): the run's context is canceled,
+// rows already handed to the workers are drained and the job ends as "canceled".
+func (h *Handler) cancelCDRProgress(w http.ResponseWriter, r *http.Request) {
+	snap, err := h.jobs.Cancel(strings.TrimSpace(r.PathValue("id")))
+	switch {
+	case errors.Is(err, errNotFoundJob):
+		writeErr(w, http.StatusNotFound, "not_found", "progress id not found")
+	case errors.Is(err, errJobFinished):
+		writeErr(w, http.StatusConflict, "job_finished", "job is already "+snap.Status)
+	default:
+		// ещё не запущенная задача отменяется сразу, запускать её уже не будут
+		if snap.Status == jobCanceled {
+			h.releaseJob(snap.JobID)
+		}
+
+		writeJSON(w, http.StatusAccepted, snap.CDRProgressResponse)
+	}
+}
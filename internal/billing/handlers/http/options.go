@@ -0,0 +1,142 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+	billing "ukrainian_call_center_scam_goev/internal/billing/service"
+)
+
+// maxRejectsLimit bounds rejected rows kept in memory per run.
+const maxRejectsLimit = 100_000
+
+func tariffOptionsFromQuery(r *http.Request) TariffOptionsDTO {
+	return tariffOptionsFromValues(r.URL.Query())
+}
+
+func tariffOptionsFromValues(q url.Values) TariffOptionsDTO {
+	return TariffOptionsDTO{
+		CollectCalls:       parseBool(q.Get("collect_calls"), false),
+		StoreCalls:         parseBool(q.Get("store_calls"), false),
+		Attribution:        q.Get("attribution"),
+		UnknownSubscribers: q.Get("unknown_subscribers"),
+		NegativeBalance:    q.Get("negative_balance"),
+		Preview:            parseBool(q.Get("preview"), false),
+		Timezone:           q.Get("tz"),
+		Tolerant:           parseBool(q.Get("tolerant"), false),
+		MaxRejects:         int(parseInt64(q.Get("max_rejects"), 0)),
+		Validation:         q.Get("validation"),
+		DurationTolerance:  parseOptionalInt(q.Get("duration_tolerance_sec")),
+		Dedup:              q.Get("dedup"),
+		DedupKey:           q.Get("dedup_key"),
+		DedupWindow:        int(parseInt64(q.Get("dedup_window"), 0)),
+		Priority:           q.Get("priority"),
+	}
+}
+
+// parseOptionalInt returns nil for an empty or malformed value, so an explicit 0 differs from "not set".
+func parseOptionalInt(v string) *int {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return nil
+	}
+
+	return &n
+}
+
+func outputOptionsFromQuery(r *http.Request) OutputOptionsDTO {
+	q := r.URL.Query()
+
+	return OutputOptionsDTO{
+		Output:  q.Get("output"),
+		CSVSep:  q.Get("csv_sep"),
+		Decimal: q.Get("decimal"),
+		BOM:     parseBool(q.Get("bom"), false),
+	}
+}
+
+// toModel validates the DTO and converts it into service options.
+func (o TariffOptionsDTO) toModel() (model.Options, error) {
+	attribution, err := model.ParseAttributionChain(o.Attribution)
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	unknown, err := model.ParseUnknownSubscriberPolicy(o.UnknownSubscribers)
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	negBalance, err := model.ParseNegativeBalancePolicy(o.NegativeBalance)
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	if o.MaxRejects < 0 || o.MaxRejects > maxRejectsLimit {
+		return model.Options{}, fmt.Errorf("max_rejects must be in 0..%d", maxRejectsLimit)
+	}
+
+	validation, err := model.ParseValidationConfig(o.Validation)
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	if o.DurationTolerance != nil && *o.DurationTolerance < 0 {
+		return model.Options{}, fmt.Errorf("duration_tolerance_sec must be >= 0")
+	}
+
+	validation.DurationTolerance = o.DurationTolerance
+
+	dedupMode, err := model.ParseDedupMode(o.Dedup)
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	dedupKey, err := model.ParseDedupKey(o.DedupKey)
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	if o.DedupWindow < 0 {
+		return model.Options{}, fmt.Errorf("dedup_window must be >= 0")
+	}
+
+	priority, err := model.ParsePriority(o.Priority)
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	var loc *time.Location
+	if o.Timezone != "" {
+		if loc, err = billing.LoadLocation(o.Timezone); err != nil {
+			return model.Options{}, err
+		}
+	}
+
+	return model.Options{
+		CollectCalls:       o.CollectCalls,
+		Attribution:        attribution,
+		UnknownSubscribers: unknown,
+		NegativeBalance:    negBalance,
+		Preview:            o.Preview,
+		Location:           loc,
+		Tolerant:           o.Tolerant,
+		MaxRejects:         o.MaxRejects,
+		Validation:         validation,
+		Dedup:              model.DedupConfig{Mode: dedupMode, Key: dedupKey, Window: o.DedupWindow},
+		Priority:           priority,
+	}, nil
+}
@@ -4,32 +4,95 @@ import (
 	"bufio"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
 )
 
 type PreparedCDRMeta struct {
 	ID              string
-	Path            string
 	OriginalName    string
+	Format          string // CDR input format, см. cdrformat.Registry
+	Files           []PreparedCDRFile
 	NormalizedBytes int64
 	RowsCount       int64
 	CreatedAt       time.Time
 }
 
+// PreparedCDRFile is one normalized file of a prepared upload (archives give several).
+type PreparedCDRFile struct {
+	Name            string
+	Path            string
+	NormalizedBytes int64
+	RowsCount       int64
+
+	// Gaps are the runs of blank lines removed by normalization, in file order: line numbers of
+	// the original file are restored from them for reports.
+	Gaps []LineGap `json:",omitempty"`
+}
+
+// LineGap says that blank lines of the original file were removed after the first After lines
+// of the normalized file; Removed counts the blank lines removed so far, this gap included.
+type LineGap struct {
+	After   int64
+	Removed int64
+}
+
+// sourceLine maps a line of the normalized file to the line of the original one.
+func (f PreparedCDRFile) sourceLine(line int64) int64 {
+	// последний разрыв перед строкой: в нём накоплено всё, что удалено выше неё
+	i := sort.Search(len(f.Gaps), func(i int) bool { return f.Gaps[i].After >= line })
+	if i == 0 {
+		return line
+	}
+
+	return line + f.Gaps[i-1].Removed
+}
+
+// Sources opens the normalized files for TariffCDRSources.
+func (m PreparedCDRMeta) Sources() []model.CDRSource {
+	out := make([]model.CDRSource, 0, len(m.Files))
+	for _, f := range m.Files {
+		src := model.CDRSource{
+			Name:   f.Name,
+			Open:   func() (io.ReadCloser, error) { return os.Open(f.Path) },
+			Format: m.Format,
+		}
+
+		if len(f.Gaps) > 0 {
+			src.Lines = f.sourceLine
+		}
+
+		out = append(out, src)
+	}
+
+	return out
+}
+
 type PreparedCDRStore struct {
 	mu      sync.RWMutex
 	dir     string
 	ttl     time.Duration
 	entries map[string]PreparedCDRMeta
+
+	// persistent stores keep <id>.json next to the files and reload them on start.
+	persistent bool
+
+	// pinned uploads are used by unfinished jobs and don't expire.
+	pinned map[string]int
 }
 
+// NewPreparedCDRStore keeps files in dir; empty dir means a temp dir that is not reused after restart.
 func NewPreparedCDRStore(dir string, ttl time.Duration) (*PreparedCDRStore, error) {
+	persistent := dir != ""
 	if dir == "" {
 		var err error
 		dir, err = os.MkdirTemp("", "billing-cdr-*")
@@ -42,14 +105,71 @@ func NewPreparedCDRStore(dir string, ttl time.Duration) (*PreparedCDRStore, erro
 		return nil, fmt.Errorf("mkdir temp dir: %w", err)
 	}
 
-	return &PreparedCDRStore{
-		dir:     dir,
-		ttl:     ttl,
-		entries: make(map[string]PreparedCDRMeta, 32),
-	}, nil
+	s := &PreparedCDRStore{
+		dir:        dir,
+		ttl:        ttl,
+		entries:    make(map[string]PreparedCDRMeta, 32),
+		persistent: persistent,
+		pinned:     make(map[string]int),
+	}
+
+	if persistent {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// load indexes uploads prepared before restart. Expired ones are removed by the next cleanup
+// unless a job pins them first.
+func (s *PreparedCDRStore) load() error {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("list prepared files: %w", err)
+	}
+
+	for _, name := range names {
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+
+		var meta PreparedCDRMeta
+		if json.Unmarshal(raw, &meta) != nil || meta.ID+".json" != filepath.Base(name) {
+			continue
+		}
+
+		s.entries[meta.ID] = meta
+	}
+
+	return nil
+}
+
+// Pin keeps uploads from expiring while a job needs them.
+func (s *PreparedCDRStore) Pin(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		s.pinned[id]++
+	}
+}
+
+func (s *PreparedCDRStore) Unpin(ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if s.pinned[id]--; s.pinned[id] <= 0 {
+			delete(s.pinned, id)
+		}
+	}
 }
 
-func (s *PreparedCDRStore) SaveNormalized(src io.Reader, originalName string) (PreparedCDRMeta, error) {
+// SaveNormalized normalizes every source (BOM, CRLF, empty lines) into its own file.
+func (s *PreparedCDRStore) SaveNormalized(sources []model.CDRSource, originalName, format string) (PreparedCDRMeta, error) {
 	now := time.Now()
 	s.cleanup(now)
 
@@ -58,15 +178,59 @@ func (s *PreparedCDRStore) SaveNormalized(src io.Reader, originalName string) (P
 		return PreparedCDRMeta{}, err
 	}
 
-	path := filepath.Join(s.dir, id+".cdr")
+	meta := PreparedCDRMeta{
+		ID:           id,
+		OriginalName: originalName,
+		Format:       format,
+		Files:        make([]PreparedCDRFile, 0, len(sources)),
+		CreatedAt:    now,
+	}
+
+	for i, src := range sources {
+		path := filepath.Join(s.dir, fmt.Sprintf("%s-%d.cdr", id, i))
+
+		file, err := normalizeCDRFile(src, path)
+		if err != nil {
+			removePreparedFiles(meta.Files)
+			return PreparedCDRMeta{}, err
+		}
+
+		meta.Files = append(meta.Files, file)
+		meta.NormalizedBytes += file.NormalizedBytes
+		meta.RowsCount += file.RowsCount
+	}
+
+	if s.persistent {
+		if err := writeJSONFile(filepath.Join(s.dir, id+".json"), meta); err != nil {
+			removePreparedFiles(meta.Files)
+			return PreparedCDRMeta{}, err
+		}
+	}
+
+	s.mu.Lock()
+	s.entries[id] = meta
+	s.mu.Unlock()
+
+	return meta, nil
+}
+
+func normalizeCDRFile(src model.CDRSource, path string) (PreparedCDRFile, error) {
+	in, err := src.Open()
+	if err != nil {
+		return PreparedCDRFile{}, fmt.Errorf("open %s: %w", src.Name, err)
+	}
+	defer in.Close()
+
 	f, err := os.Create(path)
 	if err != nil {
-		return PreparedCDRMeta{}, fmt.Errorf("create normalized file: %w", err)
+		return PreparedCDRFile{}, fmt.Errorf("create normalized file: %w", err)
 	}
 
 	var (
 		bytesWritten int64
 		rowsCount    int64
+		removed      int64
+		gaps         []LineGap
 		writeErr     error
 	)
 
@@ -77,7 +241,7 @@ func (s *PreparedCDRStore) SaveNormalized(src io.Reader, originalName string) (P
 		}
 	}()
 
-	sc := bufio.NewScanner(src)
+	sc := bufio.NewScanner(in)
 	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
 
 	firstLine := true
@@ -92,20 +256,28 @@ func (s *PreparedCDRStore) SaveNormalized(src io.Reader, originalName string) (P
 
 		line = strings.TrimRight(line, "\r")
 		if strings.TrimSpace(line) == "" {
+			// подряд идущие пустые строки — один разрыв
+			removed++
+			if n := len(gaps); n > 0 && gaps[n-1].After == rowsCount {
+				gaps[n-1].Removed = removed
+			} else {
+				gaps = append(gaps, LineGap{After: rowsCount, Removed: removed})
+			}
+
 			continue
 		}
 
 		n, err := bw.WriteString(line)
 		if err != nil {
 			writeErr = fmt.Errorf("write normalized line: %w", err)
-			return PreparedCDRMeta{}, writeErr
+			return PreparedCDRFile{}, writeErr
 		}
 		bytesWritten += int64(n)
 
 		n, err = bw.WriteString("\n")
 		if err != nil {
 			writeErr = fmt.Errorf("write normalized newline: %w", err)
-			return PreparedCDRMeta{}, writeErr
+			return PreparedCDRFile{}, writeErr
 		}
 		bytesWritten += int64(n)
 		rowsCount++
@@ -113,45 +285,45 @@ func (s *PreparedCDRStore) SaveNormalized(src io.Reader, originalName string) (P
 
 	if err := sc.Err(); err != nil {
 		writeErr = fmt.Errorf("read source file: %w", err)
-		return PreparedCDRMeta{}, writeErr
+		return PreparedCDRFile{}, writeErr
 	}
 
 	if err := bw.Flush(); err != nil {
 		writeErr = fmt.Errorf("flush normalized file: %w", err)
-		return PreparedCDRMeta{}, writeErr
+		return PreparedCDRFile{}, writeErr
 	}
 
 	if err := f.Close(); err != nil {
 		writeErr = fmt.Errorf("close normalized file: %w", err)
-		return PreparedCDRMeta{}, writeErr
+		return PreparedCDRFile{}, writeErr
 	}
 
-	meta := PreparedCDRMeta{
-		ID:              id,
+	return PreparedCDRFile{
+		Name:            src.Name,
 		Path:            path,
-		OriginalName:    originalName,
 		NormalizedBytes: bytesWritten,
 		RowsCount:       rowsCount,
-		CreatedAt:       now,
-	}
-
-	s.mu.Lock()
-	s.entries[id] = meta
-	s.mu.Unlock()
+		Gaps:            gaps,
+	}, nil
+}
 
-	return meta, nil
+func removePreparedFiles(files []PreparedCDRFile) {
+	for _, f := range files {
+		_ = os.Remove(f.Path)
+	}
 }
 
 func (s *PreparedCDRStore) Get(id string) (PreparedCDRMeta, bool) {
 	s.mu.RLock()
 	meta, ok := s.entries[id]
+	pinned := s.pinned[id] > 0
 	s.mu.RUnlock()
 	if !ok {
 		return PreparedCDRMeta{}, false
 	}
 
-	if time.Since(meta.CreatedAt) > s.ttl {
-		s.delete(id, meta.Path)
+	if !pinned && time.Since(meta.CreatedAt) > s.ttl {
+		s.delete(id, meta.Files)
 		return PreparedCDRMeta{}, false
 	}
 
@@ -163,19 +335,26 @@ func (s *PreparedCDRStore) cleanup(now time.Time) {
 	defer s.mu.Unlock()
 
 	for id, meta := range s.entries {
-		if now.Sub(meta.CreatedAt) <= s.ttl {
+		if now.Sub(meta.CreatedAt) <= s.ttl || s.pinned[id] > 0 {
 			continue
 		}
-		_ = os.Remove(meta.Path)
-		delete(s.entries, id)
+		s.removeLocked(id, meta.Files)
 	}
 }
 
-func (s *PreparedCDRStore) delete(id, path string) {
+func (s *PreparedCDRStore) delete(id string, files []PreparedCDRFile) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	_ = os.Remove(path)
+	s.removeLocked(id, files)
+}
+
+func (s *PreparedCDRStore) removeLocked(id string, files []PreparedCDRFile) {
+	removePreparedFiles(files)
 	delete(s.entries, id)
+
+	if s.persistent {
+		_ = os.Remove(filepath.Join(s.dir, id+".json"))
+	}
 }
 
 func randomID() (string, error) {
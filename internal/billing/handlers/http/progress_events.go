@@ -0,0 +1,128 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// progressEventEvery bounds how often a client gets progress events; updates in between are merged.
+	progressEventEvery = 250 * time.Millisecond
+	// progressKeepAlive is the idle interval after which a comment is sent, so proxies keep the stream.
+	progressKeepAlive = 15 * time.Second
+	// progressRetryMS is the reconnect delay suggested to EventSource.
+	progressRetryMS = 2000
+)
+
+// SSE events of GET /api/v1/cdr/progress/{id}/events.
+const (
+	progressEvent      = "progress"
+	progressEventDone  = "done"
+	progressEventError = "error"
+)
+
+// streamCDRProgress sends the job progress as server-sent events: "progress" on changes,
+// then "done" with the run summary or "error" (failed or canceled), after which the stream ends.
+func (h *Handler) streamCDRProgress(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.PathValue("id"))
+	if id == "" {
+		writeErr(w, http.StatusBadRequest, "bad_request", "empty progress id")
+		return
+	}
+
+	changed, stop, ok := h.jobs.Watch(id)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "progress id not found")
+		return
+	}
+	defer stop()
+
+	// поток живёт, пока идёт расчёт: общий WriteTimeout сервера оборвал бы его
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", progressRetryMS); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(progressKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		snap, ok := h.jobs.Get(id)
+		if !ok {
+			// задача истекла, пока за ней следили
+			snap = JobResponse{JobID: id, CDRProgressResponse: CDRProgressResponse{Status: jobError, Error: "progress id not found"}}
+		}
+
+		event := progressEvent
+
+		switch snap.Status {
+		case jobDone:
+			event = progressEventDone
+		case jobError, jobCanceled:
+			event = progressEventError
+		}
+
+		if err := writeSSE(w, event, snap); err != nil {
+			return
+		}
+
+		if err := rc.Flush(); err != nil || event != progressEvent {
+			return
+		}
+
+		keepAlive.Reset(progressKeepAlive)
+
+		select {
+		case <-time.After(progressEventEvery):
+		case <-r.Context().Done():
+			return
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-changed:
+				waiting = false
+			case <-keepAlive.C:
+				if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+					return
+				}
+
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+func writeSSE(w io.Writer, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+
+	return err
+}
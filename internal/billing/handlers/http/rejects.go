@@ -0,0 +1,140 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"bufio"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// maxInlineRejects caps rejected rows in a response that has a download of all of them.
+const maxInlineRejects = 1000
+
+// RejectStore keeps rows rejected in tolerant mode so they can be downloaded, fixed and resubmitted.
+// Rows are capped by model.Options.MaxRejects, so they are kept in memory.
+type RejectStore struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]rejectEntry
+}
+
+type rejectEntry struct {
+	rejects   model.RejectStats
+	createdAt time.Time
+}
+
+func NewRejectStore(ttl time.Duration) *RejectStore {
+	return &RejectStore{ttl: ttl, items: make(map[string]rejectEntry, 16)}
+}
+
+func (s *RejectStore) Save(rejects model.RejectStats) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, e := range s.items {
+		if now.Sub(e.createdAt) > s.ttl {
+			delete(s.items, k)
+		}
+	}
+
+	s.items[id] = rejectEntry{rejects: rejects, createdAt: now}
+
+	return id, nil
+}
+
+func (s *RejectStore) Get(id string) (model.RejectStats, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[id]
+	if !ok || time.Since(e.createdAt) > s.ttl {
+		delete(s.items, id)
+		return model.RejectStats{}, false
+	}
+
+	return e.rejects, true
+}
+
+// downloadRejects returns rejected rows as is, under the header row of their file if it has one
+// (ready for resubmission), or with with_errors=true as a CSV with the line number in the
+// uploaded file and the error of every row.
+func (h *Handler) downloadRejects(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	rejects, ok := h.rejects.Get(id)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "rejects not found or expired")
+		return
+	}
+
+	if parseBoolQuery(r, "with_errors", false) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="rejects-`+id+`.csv"`)
+
+		cw := csv.NewWriter(w)
+		cw.Comma = ';'
+		_ = cw.Write([]string{"file", "line", "error", "raw"})
+
+		for _, row := range rejects.Rows {
+			_ = cw.Write([]string{row.File, strconv.FormatInt(row.Line, 10), row.Err, row.Raw})
+		}
+
+		cw.Flush()
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="rejects-`+id+`.txt"`)
+
+	bw := bufio.NewWriter(w)
+
+	// строки идут по файлам; заголовок повторяется, только если у следующего файла он другой
+	var header string
+	for i, row := range rejects.Rows {
+		if hdr, ok := rejects.Headers[row.File]; ok && (i == 0 || hdr != header) {
+			header = hdr
+			_, _ = bw.WriteString(header)
+			_ = bw.WriteByte('\n')
+		}
+
+		_, _ = bw.WriteString(row.Raw)
+		_ = bw.WriteByte('\n')
+	}
+
+	_ = bw.Flush()
+}
+
+func mapRejects(in model.RejectStats) RejectsDTO {
+	out := RejectsDTO{
+		Count:     in.Count,
+		Truncated: in.Truncated,
+		Rows:      make([]RejectedRowDTO, 0, len(in.Rows)),
+	}
+
+	for _, row := range in.Rows {
+		out.Rows = append(out.Rows, RejectedRowDTO{File: row.File, Line: row.Line, Raw: row.Raw, Error: row.Err})
+	}
+
+	return out
+}
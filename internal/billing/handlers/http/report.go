@@ -0,0 +1,48 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// WriteReportJSON writes a report in the same layout as the /cdr/tariff response,
+// so files written outside HTTP (e.g. by the inbox watcher) are read by the same tools.
+func WriteReportJSON(w io.Writer, report model.Report, calc time.Duration) error {
+	resp := newTariffResponse(report, report.Calls != nil, float64(calc.Microseconds())/1000)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(resp)
+}
+
+// ParseTariffOptions parses rating options written as a /cdr/tariff query string
+// ("tolerant=true&dedup=first_wins&format=asterisk").
+func ParseTariffOptions(query string) (model.Options, error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	opt, err := tariffOptionsFromValues(q).toModel()
+	if err != nil {
+		return model.Options{}, err
+	}
+
+	opt.Format = q.Get("format")
+
+	return opt, nil
+}
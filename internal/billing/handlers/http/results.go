@@ -0,0 +1,600 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+const (
+	resultMetaSuffix   = ".meta.json"
+	resultReportSuffix = ".report.json"
+)
+
+// ResultMeta describes a persisted rating result (GET /api/v1/results).
+type ResultMeta struct {
+	ID            string  `json:"id"`
+	JobID         string  `json:"job_id,omitempty"`
+	StartedAt     string  `json:"started_at"`
+	FinishedAt    string  `json:"finished_at"`
+	CalculationMS float64 `json:"calculation_ms"`
+
+	Files       []string `json:"files"`
+	InputSHA256 string   `json:"input_sha256"`
+	InputBytes  int64    `json:"input_bytes"`
+
+	TariffVersion *TariffVersionDTO `json:"tariff_version,omitempty"`
+
+	Subscribers  int   `json:"subscribers"`
+	CallsCount   int64 `json:"calls_count"`
+	TotalCostKop int64 `json:"total_cost_kop"`
+	HasCalls     bool  `json:"has_calls"` // отчёт содержит список звонков (collect_calls)
+
+	// HasStoredCalls: calls are kept in a call store (store_calls), GET /api/v1/results/{id}/calls.
+	HasStoredCalls bool  `json:"has_stored_calls"`
+	StoredCalls    int64 `json:"stored_calls,omitempty"`
+}
+
+type TariffVersionDTO struct {
+	SHA256   string `json:"sha256"`
+	Rules    int    `json:"rules"`
+	LoadedAt string `json:"loaded_at"`
+}
+
+type ResultListResponse struct {
+	Results []ResultMeta `json:"results"`
+}
+
+// ResultResponse is GET /api/v1/results/{id}; report is the stored /cdr/start response.
+// The report is streamed from disk (see writeStoredReport), the type documents the layout.
+type ResultResponse struct {
+	Meta   ResultMeta      `json:"meta"`
+	Report json.RawMessage `json:"report"`
+}
+
+type resultEntry struct {
+	meta     ResultMeta
+	finished time.Time
+}
+
+// CallPageResponse is GET /api/v1/results/{id}/calls; items are calls as in TariffCDRResponse.Calls.
+type CallPageResponse struct {
+	Status     string            `json:"status"`
+	ResultID   string            `json:"result_id"`
+	Total      int64             `json:"total"` // calls matching the filters
+	Limit      int               `json:"limit"`
+	Items      []json.RawMessage `json:"items"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// ResultStore persists finished rating results in a directory: <id>.meta.json for listing,
+// <id>.report.json with the full response and, for store_calls runs, the call store <id>.calls.*
+// (see call_store.go). Results older than retention are removed.
+type ResultStore struct {
+	mu        sync.RWMutex
+	dir       string
+	retention time.Duration
+	entries   map[string]resultEntry
+	reserved  map[string]bool // results being written
+
+	// counts are match counts of call filters per result (QueryCalls)
+	counts map[string]*callCountCache
+}
+
+// NewResultStore opens (or creates) dir and indexes results left by previous runs.
+func NewResultStore(dir string, retention time.Duration) (*ResultStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir results dir: %w", err)
+	}
+
+	s := &ResultStore{
+		dir:       dir,
+		retention: retention,
+		entries:   make(map[string]resultEntry, 64),
+		reserved:  make(map[string]bool),
+		counts:    make(map[string]*callCountCache),
+	}
+
+	// хранилища звонков расчётов, прерванных вместе с прошлым процессом, уже никому не нужны
+	stale, _ := filepath.Glob(filepath.Join(dir, callStoreTempPrefix+"*"))
+	for _, name := range stale {
+		_ = os.Remove(name)
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"+resultMetaSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("list results: %w", err)
+	}
+
+	for _, name := range names {
+		e, err := readResultMeta(name)
+		if err != nil {
+			// битый файл не мешает подняться сервису: пропускаем его
+			continue
+		}
+
+		s.entries[e.meta.ID] = e
+	}
+
+	s.mu.Lock()
+	s.cleanupLocked(time.Now())
+	s.mu.Unlock()
+
+	return s, nil
+}
+
+func readResultMeta(path string) (resultEntry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return resultEntry{}, err
+	}
+
+	var meta ResultMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return resultEntry{}, err
+	}
+
+	finished, err := time.Parse(time.RFC3339, meta.FinishedAt)
+	if err != nil {
+		return resultEntry{}, err
+	}
+
+	if meta.ID == "" || filepath.Base(path) != meta.ID+resultMetaSuffix {
+		return resultEntry{}, errors.New("result id does not match file name")
+	}
+
+	return resultEntry{meta: meta, finished: finished}, nil
+}
+
+// callStoreTempPrefix names call stores of runs in progress.
+const callStoreTempPrefix = ".calls-"
+
+// NewCallStore creates a call store for a run; Save moves it under the id of the result.
+func (s *ResultStore) NewCallStore() (*callStoreWriter, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	return openCallStore(filepath.Join(s.dir, callStoreTempPrefix+id), nil)
+}
+
+// Save writes the result with the call store of the run (nil without store_calls).
+// meta.ID is kept if free (job results keep the job id), otherwise a new one is generated.
+func (s *ResultStore) Save(meta ResultMeta, report TariffCDRResponse, calls *callStoreWriter) (string, error) {
+	id, err := s.reserve(meta.ID)
+	if err != nil {
+		return "", err
+	}
+
+	meta.ID = id
+
+	if calls != nil {
+		meta.HasStoredCalls = true
+		meta.StoredCalls = calls.mark.Calls
+	}
+
+	// ссылка на битые строки живёт в памяти и после рестарта не работает
+	report.ResultID = id
+	report.Rejects.DownloadID = ""
+
+	// отчёт пишется первым: meta без отчёта после падения не появится в списке;
+	// запись идёт без блокировки стора, отчёт со звонками может быть большим
+	err = writeJSONFile(filepath.Join(s.dir, id+resultReportSuffix), report)
+	if err == nil && calls != nil {
+		err = calls.moveTo(filepath.Join(s.dir, id))
+	}
+
+	if err == nil {
+		err = writeJSONFile(filepath.Join(s.dir, id+resultMetaSuffix), meta)
+	}
+
+	if err != nil {
+		_ = os.Remove(filepath.Join(s.dir, id+resultReportSuffix))
+		removeCallStore(filepath.Join(s.dir, id))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.reserved, id)
+
+	if err != nil {
+		return "", err
+	}
+
+	finished, _ := time.Parse(time.RFC3339, meta.FinishedAt)
+	s.entries[id] = resultEntry{meta: meta, finished: finished}
+
+	return id, nil
+}
+
+// reserve picks the id of a result being written.
+func (s *ResultStore) reserve(id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cleanupLocked(time.Now())
+
+	_, taken := s.entries[id]
+	if id == "" || taken || s.reserved[id] || !validResultID(id) {
+		var err error
+		if id, err = randomID(); err != nil {
+			return "", err
+		}
+	}
+
+	s.reserved[id] = true
+
+	return id, nil
+}
+
+// validResultID keeps ids usable as file names (client progress_id becomes the id of a job result).
+func validResultID(id string) bool {
+	return len(id) <= 128 && !strings.ContainsAny(id, `/\`) && id != "." && id != ".."
+}
+
+func (s *ResultStore) Get(id string) (ResultMeta, bool) {
+	s.mu.RLock()
+	e, ok := s.entries[id]
+	s.mu.RUnlock()
+
+	if !ok || s.expired(e, time.Now()) {
+		return ResultMeta{}, false
+	}
+
+	return e.meta, true
+}
+
+// List returns results, newest first.
+func (s *ResultStore) List() []ResultMeta {
+	s.mu.Lock()
+	s.cleanupLocked(time.Now())
+	entries := make([]resultEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].finished.After(entries[j].finished) })
+
+	out := make([]ResultMeta, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.meta)
+	}
+
+	return out
+}
+
+// OpenReport opens the stored response JSON.
+func (s *ResultStore) OpenReport(id string) (io.ReadCloser, error) {
+	if _, ok := s.Get(id); !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return os.Open(filepath.Join(s.dir, id+resultReportSuffix))
+}
+
+// QueryCalls returns a page of the stored calls of a result.
+func (s *ResultStore) QueryCalls(id string, q callQuery) (callPage, error) {
+	if meta, ok := s.Get(id); !ok || !meta.HasStoredCalls {
+		return callPage{}, os.ErrNotExist
+	}
+
+	s.mu.Lock()
+	counts := s.counts[id]
+	if _, ok := s.entries[id]; ok && counts == nil {
+		counts = &callCountCache{}
+		s.counts[id] = counts
+	}
+	s.mu.Unlock()
+
+	return queryCallStore(filepath.Join(s.dir, id), q, counts)
+}
+
+func (s *ResultStore) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[id]; !ok {
+		return false
+	}
+
+	s.removeLocked(id)
+
+	return true
+}
+
+func (s *ResultStore) expired(e resultEntry, now time.Time) bool {
+	return s.retention > 0 && now.Sub(e.finished) > s.retention
+}
+
+func (s *ResultStore) cleanupLocked(now time.Time) {
+	for id, e := range s.entries {
+		if s.expired(e, now) {
+			s.removeLocked(id)
+		}
+	}
+}
+
+func (s *ResultStore) removeLocked(id string) {
+	delete(s.entries, id)
+	delete(s.counts, id)
+	_ = os.Remove(filepath.Join(s.dir, id+resultMetaSuffix))
+	_ = os.Remove(filepath.Join(s.dir, id+resultReportSuffix))
+	removeCallStore(filepath.Join(s.dir, id))
+}
+
+// writeJSONFile writes v atomically (temp file + rename).
+func writeJSONFile(path string, v any) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Base(path), err)
+	}
+
+	werr := json.NewEncoder(tmp).Encode(v)
+	cerr := tmp.Close()
+
+	if err := errors.Join(werr, cerr); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
+
+// inputHash hashes the rated CDR content across all sources of a run, in reading order.
+type inputHash struct {
+	h hash.Hash
+	n int64
+}
+
+func (ih *inputHash) Write(p []byte) (int, error) {
+	ih.n += int64(len(p))
+	return ih.h.Write(p)
+}
+
+func (ih *inputHash) Sum() string {
+	return hex.EncodeToString(ih.h.Sum(nil))
+}
+
+// hashSources wraps sources so that reading them feeds the returned hash.
+// Sources are read one after another by the reading goroutine, so no locking is needed.
+func hashSources(sources []model.CDRSource) ([]model.CDRSource, *inputHash) {
+	ih := &inputHash{h: sha256.New()}
+
+	out := make([]model.CDRSource, len(sources))
+	for i, src := range sources {
+		open := src.Open
+		src.Open = func() (io.ReadCloser, error) {
+			rc, err := open()
+			if err != nil {
+				return nil, err
+			}
+
+			return &stackedReadCloser{Reader: io.TeeReader(rc, ih), closers: []io.Closer{rc}}, nil
+		}
+		out[i] = src
+	}
+
+	return out, ih
+}
+
+// hashInput reads sources once more to hash them (for runs resumed from a checkpoint).
+func hashInput(sources []model.CDRSource) (*inputHash, error) {
+	ih := &inputHash{h: sha256.New()}
+
+	for _, src := range sources {
+		rc, err := src.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.Copy(ih, rc)
+		_ = rc.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ih, nil
+}
+
+func newResultMeta(id string, started, finished time.Time, sources []model.CDRSource, ih *inputHash,
+	report model.Report, resp TariffCDRResponse,
+) ResultMeta {
+	meta := ResultMeta{
+		ID:            id,
+		JobID:         id,
+		StartedAt:     started.UTC().Format(time.RFC3339),
+		FinishedAt:    finished.UTC().Format(time.RFC3339),
+		CalculationMS: resp.CalculationMS,
+		Files:         make([]string, 0, len(sources)),
+		InputSHA256:   ih.Sum(),
+		InputBytes:    ih.n,
+		Subscribers:   len(resp.Totals),
+		HasCalls:      resp.Calls != nil,
+	}
+
+	for _, src := range sources {
+		meta.Files = append(meta.Files, src.Name)
+	}
+
+	for _, t := range resp.Totals {
+		meta.CallsCount += int64(t.CallsCount)
+		meta.TotalCostKop += t.TotalCostKop
+	}
+
+	if v := report.Tariffs; v.SHA256 != "" {
+		meta.TariffVersion = &TariffVersionDTO{
+			SHA256:   v.SHA256,
+			Rules:    v.Rules,
+			LoadedAt: v.LoadedAt.UTC().Format(time.RFC3339),
+		}
+	}
+
+	return meta
+}
+
+func (h *Handler) listResults(w http.ResponseWriter, _ *http.Request) {
+	if h.results == nil {
+		writeErr(w, http.StatusNotFound, "not_found", "results persistence is disabled (set DATA_DIR)")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ResultListResponse{Results: h.results.List()})
+}
+
+func (h *Handler) getResult(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	if h.results == nil {
+		writeErr(w, http.StatusNotFound, "not_found", "results persistence is disabled (set DATA_DIR)")
+		return
+	}
+
+	meta, ok := h.results.Get(id)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "result not found or expired")
+		return
+	}
+
+	h.writeStoredReport(w, id, &meta)
+}
+
+// listResultCalls pages through the stored calls of a result with filters and sorting.
+func (h *Handler) listResultCalls(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.PathValue("id"))
+
+	if h.results == nil {
+		writeErr(w, http.StatusNotFound, "not_found", "results persistence is disabled (set DATA_DIR)")
+		return
+	}
+
+	meta, ok := h.results.Get(id)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "result not found or expired")
+		return
+	}
+
+	if !meta.HasStoredCalls {
+		writeErr(w, http.StatusNotFound, "not_found", "result has no stored calls (rate with store_calls=true)")
+		return
+	}
+
+	q, err := callQueryFromRequest(r, h.cfg.Location)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+
+	page, err := h.results.QueryCalls(id, q)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// результат удалили между проверкой и чтением
+		writeErr(w, http.StatusNotFound, "not_found", "result not found or expired")
+		return
+	case err != nil:
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CallPageResponse{
+		Status:     "ok",
+		ResultID:   id,
+		Total:      page.total,
+		Limit:      q.limit,
+		Items:      page.items,
+		NextCursor: page.cursor,
+	})
+}
+
+func (h *Handler) deleteResult(w http.ResponseWriter, r *http.Request) {
+	if h.results == nil || !h.results.Delete(strings.TrimSpace(r.PathValue("id"))) {
+		writeErr(w, http.StatusNotFound, "not_found", "result not found or expired")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, OKResponse{Status: "ok"})
+}
+
+// writeStoredReport streams a stored report from disk: with collect_calls it may be large.
+// With meta the report is wrapped into ResultResponse.
+func (h *Handler) writeStoredReport(w http.ResponseWriter, id string, meta *ResultMeta) {
+	rc, err := h.results.OpenReport(id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "internal", fmt.Sprintf("open result: %v", err))
+		return
+	}
+	defer rc.Close()
+
+	var head bytes.Buffer
+	if meta != nil {
+		enc := json.NewEncoder(&head)
+		enc.SetEscapeHTML(false)
+
+		head.WriteString(`{"meta":`)
+		if err := enc.Encode(meta); err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+			return
+		}
+		head.WriteString(`,"report":`)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	// статус уже отправлен: оборванный ответ остаётся невалидным JSON, клиент это увидит
+	_, _ = w.Write(head.Bytes())
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Printf("result %s: send report: %v", id, err)
+		return
+	}
+
+	if meta != nil {
+		_, _ = io.WriteString(w, "}\n")
+	}
+}
+
+type stackedReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (s *stackedReadCloser) Close() error {
+	var errs []error
+	for _, c := range s.closers {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,328 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/cdrinput"
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// Response formats of /cdr/tariff and /cdr/start. ndjson, csv and calls_csv stream rated calls
+// while the run goes; calls_csv and totals_csv are spreadsheet exports (see csv_export.go).
+const (
+	outputJSON      = "json"
+	outputNDJSON    = "ndjson"
+	outputCSV       = "csv"
+	outputCallsCSV  = "calls_csv"
+	outputTotalsCSV = "totals_csv"
+)
+
+// streamFlushEvery bounds how long written calls may sit in the buffer before the client gets them.
+const streamFlushEvery = 500 * time.Millisecond
+
+// StreamRecordDTO is one NDJSON line of a streamed response.
+type StreamRecordDTO struct {
+	Type    string             `json:"type"` // call | summary | error
+	Call    *RatedCallDTO      `json:"call,omitempty"`
+	Summary *TariffCDRResponse `json:"summary,omitempty"`
+	Error   *ErrorBody         `json:"error,omitempty"`
+}
+
+// csvStreamHeader: call rows, then a total row per subscriber, then one summary row (or an error row).
+var csvStreamHeader = []string{
+	"record", "start_time", "end_time", "calling_party", "called_party", "call_direction", "disposition",
+	"duration", "billable_sec", "account_code", "call_id", "trunk_name", "subscriber", "cost_kop",
+	"tariff_prefix", "tariff_destination", "tariff_priority", "calls_count", "error",
+}
+
+type responseFormat struct {
+	output string
+	csv    csvOptions
+}
+
+// parse picks the response format: the output parameter wins over Accept.
+func (o OutputOptionsDTO) parse(accept string) (responseFormat, error) {
+	csvOpt, err := o.csvOptions()
+	if err != nil {
+		return responseFormat{}, err
+	}
+
+	switch p := strings.ToLower(strings.TrimSpace(o.Output)); p {
+	case outputJSON, outputNDJSON, outputCSV, outputCallsCSV, outputTotalsCSV:
+		return responseFormat{output: p, csv: csvOpt}, nil
+	case "":
+	default:
+		return responseFormat{}, fmt.Errorf("output: want json | ndjson | csv | calls_csv | totals_csv, got %q", o.Output)
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		switch mt {
+		case "application/x-ndjson", "application/ndjson":
+			return responseFormat{output: outputNDJSON, csv: csvOpt}, nil
+		case "text/csv":
+			return responseFormat{output: outputCSV, csv: csvOpt}, nil
+		case "application/json":
+			return responseFormat{output: outputJSON, csv: csvOpt}, nil
+		}
+	}
+
+	return responseFormat{output: outputJSON, csv: csvOpt}, nil
+}
+
+// callStreamWriter writes rated calls of a run to the response as they come (Options.OnCall)
+// and the rest of the report as a trailer. Headers go out with the first record, so errors
+// before it are still answered with a regular status code.
+type callStreamWriter struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	format responseFormat
+
+	bw  *bufio.Writer
+	enc *json.Encoder
+	cw  *csv.Writer
+
+	started   bool
+	lastFlush time.Time
+}
+
+func newCallStreamWriter(w http.ResponseWriter, format responseFormat) *callStreamWriter {
+	return &callStreamWriter{w: w, rc: http.NewResponseController(w), format: format}
+}
+
+func (sw *callStreamWriter) start() error {
+	if sw.started {
+		return nil
+	}
+
+	sw.started = true
+	sw.lastFlush = time.Now()
+
+	// поток идёт, пока идёт расчёт: общий WriteTimeout сервера оборвал бы большой файл,
+	// а без full duplex HTTP/1 сервер перестаёт отдавать тело запроса после первых байт ответа
+	_ = sw.rc.SetWriteDeadline(time.Time{})
+	_ = sw.rc.EnableFullDuplex()
+
+	if sw.format.output == outputNDJSON {
+		sw.w.Header().Set("Content-Type", "application/x-ndjson")
+		sw.w.WriteHeader(http.StatusOK)
+
+		sw.bw = bufio.NewWriterSize(sw.w, 64<<10)
+		sw.enc = json.NewEncoder(sw.bw)
+		sw.enc.SetEscapeHTML(false)
+
+		return nil
+	}
+
+	sw.bw = bufio.NewWriterSize(sw.w, 64<<10)
+	sw.cw = sw.format.csv.start(sw.w, sw.bw, "calls")
+
+	if sw.format.output == outputCallsCSV {
+		return sw.cw.Write(exportCallsHeader)
+	}
+
+	return sw.cw.Write(csvStreamHeader)
+}
+
+// call is Options.OnCall.
+func (sw *callStreamWriter) call(c model.RatedCall) error {
+	if err := sw.start(); err != nil {
+		return err
+	}
+
+	var err error
+
+	switch sw.format.output {
+	case outputCallsCSV:
+		err = sw.cw.Write(exportCallRow(c, sw.format.csv.decimal))
+	case outputCSV:
+		err = sw.cw.Write(csvCallRow(mapCall(c)))
+	default:
+		dto := mapCall(c)
+		err = sw.enc.Encode(StreamRecordDTO{Type: "call", Call: &dto})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if time.Since(sw.lastFlush) >= streamFlushEvery {
+		return sw.flush()
+	}
+
+	return nil
+}
+
+// finish writes the trailer: the report without calls (ndjson) or subscriber totals (csv).
+// The calls_csv export has no trailer: totals are exported by totals_csv.
+func (sw *callStreamWriter) finish(resp TariffCDRResponse) error {
+	if err := sw.start(); err != nil {
+		return err
+	}
+
+	switch sw.format.output {
+	case outputCallsCSV:
+	case outputCSV:
+		var (
+			cost  int64
+			calls int
+		)
+
+		for _, t := range resp.Totals {
+			cost += t.TotalCostKop
+			calls += t.CallsCount
+
+			if err := sw.cw.Write(csvTrailerRow("total", t.PhoneNumber, t.TotalCostKop, t.CallsCount)); err != nil {
+				return err
+			}
+		}
+
+		if err := sw.cw.Write(csvTrailerRow("summary", "", cost, calls)); err != nil {
+			return err
+		}
+	default:
+		resp.Calls = nil
+		if err := sw.enc.Encode(StreamRecordDTO{Type: "summary", Summary: &resp}); err != nil {
+			return err
+		}
+	}
+
+	return sw.flush()
+}
+
+// fail reports a failed run: with a status code if nothing was sent yet, otherwise as the last record.
+func (sw *callStreamWriter) fail(h *Handler, err error) {
+	if !sw.started {
+		h.writeTariffErr(sw.w, err)
+		return
+	}
+
+	code := "tariff_cdr_failed"
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		code = "request_canceled"
+	}
+
+	switch sw.format.output {
+	case outputCallsCSV:
+		// в таблице это последняя строка, её видно сразу
+		_ = sw.cw.Write([]string{"ERROR: " + code + ": " + err.Error()})
+	case outputCSV:
+		row := make([]string, len(csvStreamHeader))
+		row[0] = "error"
+		row[len(row)-1] = code + ": " + err.Error()
+		_ = sw.cw.Write(row)
+	default:
+		_ = sw.enc.Encode(StreamRecordDTO{Type: "error", Error: &ErrorBody{Code: code, Message: err.Error()}})
+	}
+
+	_ = sw.flush()
+}
+
+func (sw *callStreamWriter) flush() error {
+	if sw.cw != nil {
+		sw.cw.Flush()
+
+		if err := sw.cw.Error(); err != nil {
+			return err
+		}
+	}
+
+	if err := sw.bw.Flush(); err != nil {
+		return err
+	}
+
+	sw.lastFlush = time.Now()
+
+	// не каждый ResponseWriter умеет Flush; данные всё равно уйдут, просто позже
+	if err := sw.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	return nil
+}
+
+func csvCallRow(c RatedCallDTO) []string {
+	row := []string{
+		"call", c.StartTime, c.EndTime, c.CallingParty, c.CalledParty, c.CallDirection, c.Disposition,
+		strconv.Itoa(c.Duration), strconv.Itoa(c.BillableSec), c.AccountCode, c.CallID, c.TrunkName,
+		c.Subscriber, strconv.FormatInt(c.CostKop, 10), "", "", "", "", "",
+	}
+
+	if c.Tariff != nil {
+		row[14] = c.Tariff.Prefix
+		row[15] = c.Tariff.Destination
+		row[16] = strconv.Itoa(c.Tariff.Priority)
+	}
+
+	return row
+}
+
+func csvTrailerRow(record, subscriber string, costKop int64, calls int) []string {
+	row := make([]string, len(csvStreamHeader))
+	row[0] = record
+	row[12] = subscriber
+	row[13] = strconv.FormatInt(costKop, 10)
+	row[17] = strconv.Itoa(calls)
+
+	return row
+}
+
+// runFormatted rates sources answering in a non-JSON format. Calls are written into the response
+// as they are produced; totals_csv waits for the end of the run.
+func (h *Handler) runFormatted(
+	w http.ResponseWriter,
+	r *http.Request,
+	sources []model.CDRSource,
+	opt model.Options,
+	progressID string,
+	format responseFormat,
+	calls *callStoreWriter,
+	compressed *cdrinput.Progress,
+) {
+	if format.output == outputTotalsCSV {
+		opt.CollectCalls = false
+
+		resp, err := h.runTariffing(r.Context(), sources, opt, progressID, false, calls, compressed)
+		if err != nil {
+			h.writeTariffErr(w, err)
+			return
+		}
+
+		writeTotalsCSV(w, resp.Totals, format.csv)
+
+		return
+	}
+
+	sw := newCallStreamWriter(w, format)
+	opt.OnCall = sw.call
+
+	resp, err := h.runTariffing(r.Context(), sources, opt, progressID, false, calls, compressed)
+	if err != nil {
+		sw.fail(h, err)
+		return
+	}
+
+	_ = sw.finish(resp)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+	"ukrainian_call_center_scam_goev/internal/billing/repo"
+	billing "ukrainian_call_center_scam_goev/internal/billing/service"
+)
+
+const defaultSubscribersPage = 50
+
+// postSubscribers creates a single subscriber from a JSON body,
+// any other content type is treated as a full CSV directory upload.
+func (h *Handler) postSubscribers(w http.ResponseWriter, r *http.Request) {
+	if !isJSONRequest(r) {
+		h.uploadSubscribers(w, r)
+		return
+	}
+
+	var req SubscriberDTO
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", "invalid json body")
+		return
+	}
+
+	sub := req.toModel()
+	if err := h.svc.CreateSubscriber(r.Context(), sub); err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	sub, err := h.svc.GetSubscriber(r.Context(), sub.PhoneNumber)
+	if err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, SubscriberResponse{Status: "ok", Subscriber: mapSubscriber(sub)})
+}
+
+func (h *Handler) listSubscribers(w http.ResponseWriter, r *http.Request) {
+	q := model.SubscriberQuery{
+		NameContains: r.URL.Query().Get("name"),
+		PhonePrefix:  r.URL.Query().Get("phone_prefix"),
+		Offset:       int(parseInt64Query(r, "offset", 0)),
+		Limit:        int(parseInt64Query(r, "limit", defaultSubscribersPage)),
+	}
+
+	subs, total, err := h.svc.ListSubscribers(r.Context(), q)
+	if err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	items := make([]SubscriberDTO, 0, len(subs))
+	for _, sub := range subs {
+		items = append(items, mapSubscriber(sub))
+	}
+
+	writeJSON(w, http.StatusOK, SubscriberListResponse{
+		Status: "ok",
+		Total:  total,
+		Offset: q.Offset,
+		Limit:  q.Limit,
+		Items:  items,
+	})
+}
+
+func (h *Handler) getSubscriber(w http.ResponseWriter, r *http.Request) {
+	sub, err := h.svc.GetSubscriber(r.Context(), r.PathValue("phone"))
+	if err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SubscriberResponse{Status: "ok", Subscriber: mapSubscriber(sub)})
+}
+
+func (h *Handler) updateSubscriber(w http.ResponseWriter, r *http.Request) {
+	var req SubscriberDTO
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", "invalid json body")
+		return
+	}
+
+	phone := strings.TrimSpace(r.PathValue("phone"))
+	if req.PhoneNumber != "" && strings.TrimSpace(req.PhoneNumber) != phone {
+		writeErr(w, http.StatusBadRequest, "bad_request", "phone_number in body does not match path")
+		return
+	}
+
+	req.PhoneNumber = phone
+
+	sub := req.toModel()
+	if err := h.svc.UpdateSubscriber(r.Context(), sub); err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	sub, err := h.svc.GetSubscriber(r.Context(), phone)
+	if err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SubscriberResponse{Status: "ok", Subscriber: mapSubscriber(sub)})
+}
+
+func (h *Handler) deleteSubscriber(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.DeleteSubscriber(r.Context(), r.PathValue("phone")); err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, OKResponse{Status: "ok"})
+}
+
+func writeServiceErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repo.ErrNotFound):
+		writeErr(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, repo.ErrAlreadyExists):
+		writeErr(w, http.StatusConflict, "already_exists", err.Error())
+	case errors.Is(err, billing.ErrInvalidArgument):
+		writeErr(w, http.StatusBadRequest, "bad_request", err.Error())
+	default:
+		writeErr(w, http.StatusInternalServerError, "internal", err.Error())
+	}
+}
+
+func (d SubscriberDTO) toModel() model.Subscriber {
+	return model.Subscriber{
+		PhoneNumber: d.PhoneNumber,
+		ClientName:  d.ClientName,
+		CreditLimit: model.Money(d.CreditLimitKop),
+	}
+}
+
+func mapSubscriber(sub model.Subscriber) SubscriberDTO {
+	return SubscriberDTO{
+		PhoneNumber:    sub.PhoneNumber,
+		ClientName:     sub.ClientName,
+		CreditLimitKop: int64(sub.CreditLimit),
+	}
+}
+
+func isJSONRequest(r *http.Request) bool {
+	return strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "application/json")
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (h *Handler) getTrunkZones(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, TrunkZonesResponse{
+		Status:  "ok",
+		Default: h.svc.Location().String(),
+		Trunks:  h.svc.TrunkZones(),
+	})
+}
+
+// putTrunkZones replaces all per-trunk zones: {"trunks": {"SIP_Trunk_02": "Asia/Yekaterinburg"}}.
+func (h *Handler) putTrunkZones(w http.ResponseWriter, r *http.Request) {
+	var req TrunkZonesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_request", "invalid json body")
+		return
+	}
+
+	if err := h.svc.SetTrunkZones(req.Trunks); err != nil {
+		writeServiceErr(w, err)
+		return
+	}
+
+	h.getTrunkZones(w, r)
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+// Package ingest rates CDR files dropped into a watched directory (e.g. by rsync from a switch).
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/cdrinput"
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+const (
+	doneDir   = "done"
+	failedDir = "failed"
+
+	reportSuffix = ".report.json"
+	errorSuffix  = ".error.txt"
+)
+
+// Rater is the part of the billing service the inbox needs.
+type Rater interface {
+	TariffCDRSources(ctx context.Context, sources []model.CDRSource, opt model.Options) (model.Report, error)
+	CommitRun(ctx context.Context, report model.Report) error
+}
+
+// ReportWriter serializes a report (injected so files match the HTTP response layout).
+type ReportWriter func(w io.Writer, report model.Report, calc time.Duration) error
+
+type Config struct {
+	// Dir is polled for new CDR files. done/ and failed/ are created inside it.
+	Dir string
+
+	// OutputDir receives reports; empty means next to the processed file in done/.
+	OutputDir string
+
+	PollInterval time.Duration
+
+	// StableFor is how long size and mtime must stay unchanged before a file is taken:
+	// rsync may still be writing it.
+	StableFor time.Duration
+
+	Options model.Options
+}
+
+// Inbox polls Config.Dir and rates every stable file once.
+type Inbox struct {
+	cfg    Config
+	rater  Rater
+	report ReportWriter
+
+	seen map[string]fileState
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // when size/mtime were seen for the first time
+}
+
+func New(cfg Config, rater Rater, report ReportWriter) (*Inbox, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("ingest: empty dir")
+	}
+
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 30 * time.Second
+	}
+
+	if cfg.StableFor <= 0 {
+		cfg.StableFor = time.Minute
+	}
+
+	dirs := []string{cfg.Dir, filepath.Join(cfg.Dir, doneDir), filepath.Join(cfg.Dir, failedDir)}
+	if cfg.OutputDir != "" {
+		dirs = append(dirs, cfg.OutputDir)
+	}
+
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, fmt.Errorf("ingest: mkdir %s: %w", d, err)
+		}
+	}
+
+	return &Inbox{
+		cfg:    cfg,
+		rater:  rater,
+		report: report,
+		seen:   make(map[string]fileState),
+	}, nil
+}
+
+// Run polls the directory until ctx is canceled. A file being rated is finished
+// (or fails with ctx error and stays in the inbox for the next start).
+func (in *Inbox) Run(ctx context.Context) {
+	log.Printf("ingest: watching %s every %s", in.cfg.Dir, in.cfg.PollInterval)
+
+	t := time.NewTicker(in.cfg.PollInterval)
+	defer t.Stop()
+
+	for {
+		in.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (in *Inbox) poll(ctx context.Context) {
+	entries, err := os.ReadDir(in.cfg.Dir)
+	if err != nil {
+		log.Printf("ingest: read dir: %v", err)
+		return
+	}
+
+	now := time.Now()
+	present := make(map[string]bool, len(entries))
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || skipName(name) {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		present[name] = true
+
+		st, ok := in.seen[name]
+		if !ok || st.size != info.Size() || !st.modTime.Equal(info.ModTime()) {
+			in.seen[name] = fileState{size: info.Size(), modTime: info.ModTime(), since: now}
+			continue
+		}
+
+		if now.Sub(st.since) < in.cfg.StableFor {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		in.process(ctx, name)
+		delete(in.seen, name)
+	}
+
+	// файлы, которые убрали руками, больше не отслеживаем
+	for name := range in.seen {
+		if !present[name] {
+			delete(in.seen, name)
+		}
+	}
+}
+
+// skipName ignores hidden files (rsync writes into ".name.XXXXXX" and renames at the end)
+// and partial downloads.
+func skipName(name string) bool {
+	return strings.HasPrefix(name, ".") ||
+		strings.HasSuffix(name, ".tmp") ||
+		strings.HasSuffix(name, ".part") ||
+		strings.HasSuffix(name, ".partial")
+}
+
+func (in *Inbox) process(ctx context.Context, name string) {
+	src := filepath.Join(in.cfg.Dir, name)
+	started := time.Now()
+
+	report, err := in.rate(ctx, src)
+	if err != nil && ctx.Err() != nil {
+		// остановка сервиса: файл остаётся во входящих и будет посчитан после перезапуска
+		log.Printf("ingest: %s: interrupted: %v", name, err)
+		return
+	}
+
+	// отчёт называется так же, как файл в done/: повторно присланный файл не затирает прошлый отчёт
+	done := filepath.Join(in.cfg.Dir, doneDir)
+	doneName := freeName(done, name, func(n string) bool { return exists(in.reportPath(n)) })
+
+	if err == nil {
+		err = in.writeReport(doneName, report, time.Since(started))
+	}
+
+	// балансы списываются, только когда отчёт записан; без списаний отчёт не нужен,
+	// а файл из failed/ можно подбросить снова
+	if err == nil {
+		if err = in.rater.CommitRun(context.WithoutCancel(ctx), report); err != nil {
+			_ = os.Remove(in.reportPath(doneName))
+		}
+	}
+
+	if err != nil {
+		log.Printf("ingest: %s: failed: %v", name, err)
+		in.fail(name, err)
+
+		return
+	}
+
+	dst := filepath.Join(done, doneName)
+	if err := os.Rename(src, dst); err != nil {
+		log.Printf("ingest: %s: move to %s: %v", name, doneDir, err)
+		return
+	}
+
+	log.Printf("ingest: %s: done in %s -> %s", name, time.Since(started).Round(time.Millisecond), dst)
+}
+
+// rate opens the file the way uploads are opened: gzip and zip archives are unpacked.
+func (in *Inbox) rate(ctx context.Context, path string) (model.Report, error) {
+	upload, err := cdrinput.OpenFile(path)
+	if err != nil {
+		return model.Report{}, err
+	}
+	defer upload.Close()
+
+	return in.rater.TariffCDRSources(ctx, upload.Sources, in.cfg.Options)
+}
+
+// reportPath is the report of the file named name in done/.
+func (in *Inbox) reportPath(name string) string {
+	dir := in.cfg.OutputDir
+	if dir == "" {
+		dir = filepath.Join(in.cfg.Dir, doneDir)
+	}
+
+	return filepath.Join(dir, name+reportSuffix)
+}
+
+// writeReport writes <name>.report.json atomically (temp file + rename), so consumers
+// of the output directory never see a half-written report.
+func (in *Inbox) writeReport(name string, report model.Report, calc time.Duration) error {
+	path := in.reportPath(name)
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".report-*")
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+
+	werr := in.report(tmp, report, calc)
+	cerr := tmp.Close()
+
+	if err := errors.Join(werr, cerr); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write report: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write report: %w", err)
+	}
+
+	return nil
+}
+
+// fail moves the file to failed/ and puts the error next to it.
+func (in *Inbox) fail(name string, cause error) {
+	dst, err := moveTo(filepath.Join(in.cfg.Dir, name), filepath.Join(in.cfg.Dir, failedDir))
+	if err != nil {
+		log.Printf("ingest: %s: move to %s: %v", name, failedDir, err)
+		return
+	}
+
+	msg := fmt.Sprintf("file: %s\ntime: %s\nerror: %v\n", name, time.Now().Format(time.RFC3339), cause)
+	if err := os.WriteFile(dst+errorSuffix, []byte(msg), 0o644); err != nil {
+		log.Printf("ingest: %s: write error sidecar: %v", name, err)
+	}
+}
+
+// moveTo moves src into dir under a free name (see freeName).
+func moveTo(src, dir string) (string, error) {
+	dst := filepath.Join(dir, freeName(dir, filepath.Base(src), nil))
+	if err := os.Rename(src, dst); err != nil {
+		return "", err
+	}
+
+	return dst, nil
+}
+
+// freeName returns name if dir has no such file and taken (optional) doesn't claim it;
+// otherwise name with a time suffix: an existing file is kept (the switch may re-send
+// a file with the same name).
+func freeName(dir, name string, taken func(name string) bool) string {
+	free := func(n string) bool {
+		return !exists(filepath.Join(dir, n)) && (taken == nil || !taken(n))
+	}
+
+	if free(name) {
+		return name
+	}
+
+	stamped := fmt.Sprintf("%s.%s", name, time.Now().Format("20060102T150405.000"))
+
+	candidate := stamped
+	for i := 2; !free(candidate); i++ {
+		candidate = fmt.Sprintf("%s-%d", stamped, i)
+	}
+
+	return candidate
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
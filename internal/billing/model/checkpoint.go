@@ -0,0 +1,35 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package model
+
+// Checkpoint is a point of a run where every row read so far is accounted.
+// A run with the same sources and options can be resumed from it (Options.Resume).
+type Checkpoint struct {
+	Source int   // index of the source being read
+	Offset int64 // byte offset in that source right after the last handled row
+	Line   int64 // number of that row
+
+	// ProcessedBytes is what OnProcessedBytes reported so far (progress of the resumed run starts here).
+	ProcessedBytes int64
+
+	// Tariffs the run was rated with: a run is resumed only with the same tariff table.
+	Tariffs TariffVersion
+
+	// State is the service-internal snapshot of partial totals, dedup and validation state.
+	State []byte
+
+	// Calls are the calls collected (Options.CollectCalls) since the previous checkpoint of the run,
+	// encoded by the service: State doesn't repeat them, so a checkpoint costs only what is new.
+	Calls []byte
+
+	// CallChunks are set only to resume (Options.Resume): Calls of this checkpoint and every earlier
+	// one of the run, in order.
+	CallChunks [][]byte
+}
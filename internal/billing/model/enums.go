@@ -9,7 +9,10 @@
 
 package model
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 type CallDirection uint8
 
@@ -87,3 +90,237 @@ func (d Disposition) String() string {
 		return "unknown"
 	}
 }
+
+// AttributionSource tells which CDR field identifies the billed subscriber.
+type AttributionSource uint8
+
+const (
+	AttrUnknown AttributionSource = iota
+	AttrCallingParty
+	AttrCalledParty
+	AttrAccountCode
+	AttrTrunk
+)
+
+func ParseAttributionSource(s string) AttributionSource {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "calling_party":
+		return AttrCallingParty
+	case "called_party":
+		return AttrCalledParty
+	case "account_code":
+		return AttrAccountCode
+	case "trunk":
+		return AttrTrunk
+	default:
+		return AttrUnknown
+	}
+}
+
+func (a AttributionSource) String() string {
+	switch a {
+	case AttrCallingParty:
+		return "calling_party"
+	case AttrCalledParty:
+		return "called_party"
+	case AttrAccountCode:
+		return "account_code"
+	case AttrTrunk:
+		return "trunk"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseAttributionChain("account_code,trunk,calling_party") => ordered fallback chain.
+func ParseAttributionChain(s string) ([]AttributionSource, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	items := strings.Split(s, ",")
+	chain := make([]AttributionSource, 0, len(items))
+
+	for _, it := range items {
+		src := ParseAttributionSource(it)
+		if src == AttrUnknown {
+			return nil, fmt.Errorf("attribution: unknown source %q", strings.TrimSpace(it))
+		}
+
+		chain = append(chain, src)
+	}
+
+	return chain, nil
+}
+
+// UnknownSubscriberPolicy decides what to do with calls of subscribers missing from the directory.
+type UnknownSubscriberPolicy uint8
+
+const (
+	UnknownBill UnknownSubscriberPolicy = iota // тарифицировать как есть (по умолчанию)
+	UnknownSkip                                // не включать в итоги
+	UnknownFail                                // прервать расчёт
+)
+
+func ParseUnknownSubscriberPolicy(s string) (UnknownSubscriberPolicy, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "bill":
+		return UnknownBill, nil
+	case "skip":
+		return UnknownSkip, nil
+	case "fail":
+		return UnknownFail, nil
+	default:
+		return UnknownBill, fmt.Errorf("unknown_subscribers: bad policy %q", s)
+	}
+}
+
+func (p UnknownSubscriberPolicy) String() string {
+	switch p {
+	case UnknownSkip:
+		return "skip"
+	case UnknownFail:
+		return "fail"
+	default:
+		return "bill"
+	}
+}
+
+// NegativeBalancePolicy decides what happens when a prepaid balance can't cover a call.
+type NegativeBalancePolicy uint8
+
+const (
+	BalanceFlag      NegativeBalancePolicy = iota // списать в минус и пометить абонента (по умолчанию)
+	BalanceBlock                                  // не тарифицировать звонки при балансе <= 0
+	BalanceOverdraft                              // списать сколько есть, остаток вернуть как overdraft
+)
+
+func ParseNegativeBalancePolicy(s string) (NegativeBalancePolicy, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "flag":
+		return BalanceFlag, nil
+	case "block":
+		return BalanceBlock, nil
+	case "overdraft":
+		return BalanceOverdraft, nil
+	default:
+		return BalanceFlag, fmt.Errorf("negative_balance: bad policy %q", s)
+	}
+}
+
+func (p NegativeBalancePolicy) String() string {
+	switch p {
+	case BalanceBlock:
+		return "block"
+	case BalanceOverdraft:
+		return "overdraft"
+	default:
+		return "flag"
+	}
+}
+
+// DedupMode decides what to do with rows whose dedup key was already seen.
+type DedupMode uint8
+
+const (
+	DedupOff       DedupMode = iota // без дедупликации (по умолчанию)
+	DedupFirstWins                  // оставить первую строку, повторы отбросить
+	DedupLastWins                   // оставить последнюю строку (повторы после failover приходят исправленными)
+	DedupReject                     // повтор — ошибка строки
+)
+
+func ParseDedupMode(s string) (DedupMode, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "off":
+		return DedupOff, nil
+	case "first_wins":
+		return DedupFirstWins, nil
+	case "last_wins":
+		return DedupLastWins, nil
+	case "reject":
+		return DedupReject, nil
+	default:
+		return DedupOff, fmt.Errorf("dedup: bad mode %q", s)
+	}
+}
+
+func (m DedupMode) String() string {
+	switch m {
+	case DedupFirstWins:
+		return "first_wins"
+	case DedupLastWins:
+		return "last_wins"
+	case DedupReject:
+		return "reject"
+	default:
+		return "off"
+	}
+}
+
+// DedupKey tells which fields identify a call for deduplication.
+type DedupKey uint8
+
+const (
+	DedupByCallID DedupKey = iota // только call_id (по умолчанию)
+	DedupByFull                   // call_id + start_time + calling_party
+)
+
+func ParseDedupKey(s string) (DedupKey, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "call_id":
+		return DedupByCallID, nil
+	case "full":
+		return DedupByFull, nil
+	default:
+		return DedupByCallID, fmt.Errorf("dedup_key: bad key %q", s)
+	}
+}
+
+func (k DedupKey) String() string {
+	if k == DedupByFull {
+		return "full"
+	}
+
+	return "call_id"
+}
+
+// Priority is the class of a rating run: it decides the order of runs waiting to start
+// and the share of workers a running run gets.
+type Priority int8
+
+const (
+	PriorityLow    Priority = -1 // фоновые пересчёты
+	PriorityNormal Priority = 0  // по умолчанию
+	PriorityHigh   Priority = 1  // короткие проверки, которые ждёт человек
+)
+
+func ParsePriority(s string) (Priority, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	case "high":
+		return PriorityHigh, nil
+	default:
+		return PriorityNormal, fmt.Errorf("priority: bad class %q", s)
+	}
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
@@ -14,6 +14,22 @@ import "time"
 type Subscriber struct {
 	PhoneNumber string
 	ClientName  string
+	CreditLimit Money // 0 — без лимита
+}
+
+// SubscriberQuery filters and pages the subscriber list.
+type SubscriberQuery struct {
+	NameContains string // без учёта регистра
+	PhonePrefix  string
+	Offset       int
+	Limit        int
+}
+
+// AttributionRule maps an account code or a trunk name to a subscriber phone number.
+type AttributionRule struct {
+	Source      AttributionSource // AttrAccountCode | AttrTrunk
+	Key         string
+	PhoneNumber string
 }
 
 type TariffRule struct {
@@ -27,6 +43,9 @@ type TariffRule struct {
 
 	EffectiveStart  time.Time
 	ExpiryExclusive time.Time
+
+	// Location is the zone timeband and weekday are evaluated in (the tariff's zone, not the CDR's).
+	Location *time.Location
 }
 
 type AppliedTariffRef struct {
@@ -51,6 +70,9 @@ type CDRRecord struct {
 	AccountCode string
 	CallID      string
 	TrunkName   string
+
+	// Attributes keeps source columns that are not mapped to fields (passthrough).
+	Attributes map[string]string
 }
 
 type RatedCall struct {
@@ -70,6 +92,11 @@ type RatedCall struct {
 	CallID      string
 	TrunkName   string
 
+	// SubscriberPhone is the subscriber the call was attributed to.
+	SubscriberPhone string
+
+	Attributes map[string]string
+
 	Cost   Money
 	Tariff *AppliedTariffRef
 }
@@ -81,7 +108,121 @@ type SubscriberTotal struct {
 	CallsCount  int
 }
 
+// UnknownSubscriber is a number that was not found in the subscriber directory during a run.
+type UnknownSubscriber struct {
+	PhoneNumber string
+	CallsCount  int
+	WouldBeCost Money
+}
+
+// Balance is a prepaid account of a subscriber.
+type Balance struct {
+	PhoneNumber string
+	Amount      Money
+	UpdatedAt   time.Time
+}
+
+// BalanceCharges are prepaid charges of one subscriber staged by a run.
+// They are deducted only when the run is committed (Service.CommitRun).
+type BalanceCharges struct {
+	PhoneNumber string
+	Calls       map[string]Money // по CallID: уже списанный звонок повторно не списывается
+	NoCallID    Money            // звонки без CallID сверить не с чем, они списываются всегда
+}
+
+// BalanceSummary aggregates prepaid charges of one subscriber in a run.
+// BalanceAfter is the balance the run leaves once its charges are committed.
+type BalanceSummary struct {
+	PhoneNumber    string
+	Charged        Money
+	Overdraft      Money
+	BalanceAfter   Money
+	CallsCharged   int
+	CallsBlocked   int
+	CallsDuplicate int
+}
+
+// CreditEvent is emitted once per run when a subscriber's running total crosses
+// a threshold (in percent) of its credit limit.
+type CreditEvent struct {
+	PhoneNumber  string
+	ClientName   string
+	ThresholdPct int
+	CreditLimit  Money
+	TotalCost    Money
+	CallID       string
+	At           time.Time // StartTime звонка, на котором пересекли порог
+}
+
+// RejectedRow is a CDR line that could not be parsed in tolerant mode.
+type RejectedRow struct {
+	File string // имя файла внутри архива (пусто для одиночного файла)
+	Line int64  // номер строки во входном файле, с 1
+	Raw  string
+	Err  string
+}
+
+// RejectStats summarizes rows skipped in tolerant mode.
+type RejectStats struct {
+	Count     int64
+	Rows      []RejectedRow // первые MaxRejects строк
+	Truncated bool          // Count > len(Rows)
+
+	// Headers are header rows of files that have one, by RejectedRow.File: a fixed file is
+	// resubmitted with its header, otherwise columns bound by name are lost.
+	Headers map[string]string
+}
+
+// DuplicateRow describes one dropped duplicate (line numbers start at 1).
+type DuplicateRow struct {
+	CallID      string
+	KeptFile    string
+	KeptLine    int64
+	DroppedFile string
+	DroppedLine int64
+}
+
+// DuplicateStats summarizes deduplication of a run.
+type DuplicateStats struct {
+	Mode      DedupMode
+	Count     int64
+	Rows      []DuplicateRow // первые MaxRejects повторов
+	Truncated bool
+}
+
+// FileStats describes one source file of a run (e.g. an entry of a zip archive).
+type FileStats struct {
+	Name       string
+	Rows       int64 // строки с данными (без пустых и заголовков)
+	Rejected   int64
+	Duplicates int64
+	Calls      int64 // учтённые в итогах звонки
+	Cost       Money
+}
+
 type Report struct {
 	Calls  []RatedCall
 	Totals []SubscriberTotal
+
+	UnknownSubscribers []UnknownSubscriber
+	Balances           []BalanceSummary
+	CreditEvents       []CreditEvent
+	Rejects            RejectStats
+	Violations         []RuleViolations
+	Duplicates         DuplicateStats
+	Files              []FileStats
+
+	// Tariffs is the tariff table the run started with (zero if none was loaded).
+	Tariffs TariffVersion
+
+	// Charges are staged prepaid charges, deducted by Service.CommitRun;
+	// nil for Options.Preview (balances in the report are a preview then).
+	Charges []BalanceCharges
+}
+
+// TariffVersion identifies a loaded tariff table: results keep it to show what they were rated with.
+type TariffVersion struct {
+	SHA256   string // hash of the uploaded CSV
+	Rules    int
+	LoadedAt time.Time
 }
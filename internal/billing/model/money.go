@@ -80,3 +80,16 @@ func ParseMoney(s string) (Money, error) {
 
 	return Money(v), nil
 }
+
+// Format writes kopecks as rubles with two decimals: 180 => "1.80"; decimal is the separator ('.' or ',').
+func (m Money) Format(decimal byte) string {
+	v := int64(m)
+
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	return fmt.Sprintf("%s%d%c%02d", sign, v/100, decimal, v%100)
+}
@@ -9,17 +9,108 @@
 
 package model
 
-import "time"
+import (
+	"io"
+	"time"
+)
+
+const DefaultMaxRejects = 1000
 
 // Options configures stream tariffing.
 type Options struct {
 	CollectCalls bool
 	TotalBytes   int64
 
+	// Format is the name of a registered CDR input format (empty means the native "|" format).
+	Format string
+
+	// Location is the zone of wall-clock CDR times of this upload (nil means the service default).
+	// Trunk zones configured in the service take precedence.
+	Location *time.Location
+
+	// Tolerant skips malformed rows instead of failing the whole run.
+	// Up to MaxRejects skipped rows are collected into the report (0 means DefaultMaxRejects).
+	Tolerant   bool
+	MaxRejects int
+
+	// Validation configures semantic checks of parsed rows.
+	Validation ValidationConfig
+
+	// Dedup configures detection of rows re-sent by switches (e.g. after failover).
+	Dedup DedupConfig
+
+	// Attribution is an ordered fallback chain of sources used to find the billed subscriber.
+	// Empty chain means calling party only.
+	Attribution []AttributionSource
+
+	// UnknownSubscribers tells what to do with calls of numbers missing from the subscriber directory.
+	UnknownSubscribers UnknownSubscriberPolicy
+
+	// NegativeBalance tells what to do when a prepaid balance can't cover a call.
+	NegativeBalance NegativeBalancePolicy
+
+	// Preview keeps prepaid balances as they are: the report only shows what would be charged.
+	// Otherwise charges are staged into Report.Charges and deducted by Service.CommitRun.
+	Preview bool
+
+	// Priority is the class of the run for the service scheduler.
+	Priority Priority
+
+	// OnStarted is called when the run leaves the admission queue and starts reading.
+	OnStarted func()
+
+	// OnCall receives rated calls in input order while the run goes, instead of collecting them
+	// into Report.Calls (CollectCalls is ignored then). Calls are passed one at a time from a goroutine
+	// of the run; reading waits while it lags behind, and it is not called once the run has returned.
+	// An error fails the run.
+	OnCall func(call RatedCall) error
+
 	// OnProcessedBytes is called after a CDR row is fully processed (rated and accounted).
 	// n is an approximate byte size of the processed row (used for progress UI).
 	OnProcessedBytes func(n int64)
 
+	// OnProcessedRows is called as CDR rows are handled: rated, skipped or dropped as duplicates
+	// (rows) and, among them, rejected as malformed in tolerant mode (rejected). Both are increments.
+	OnProcessedRows func(rows, rejected int64)
+
 	// DemoSleepPerLine slows down processing for demo UI (set to 0 to disable).
 	DemoSleepPerLine time.Duration
+
+	// CheckpointEvery > 0 makes the run call OnCheckpoint about that often. Offsets are exact only for
+	// sources without CRLF line ends (prepared files); an OnCheckpoint error fails the run.
+	CheckpointEvery time.Duration
+	OnCheckpoint    func(cp Checkpoint) error
+
+	// Resume continues a run with the same sources and options from its checkpoint.
+	Resume *Checkpoint
+}
+
+// DedupConfig configures duplicate CDR detection.
+type DedupConfig struct {
+	Mode DedupMode
+	Key  DedupKey
+
+	// Window bounds memory: only duplicates within the last Window rows are detected (0 means the whole file).
+	Window int
+}
+
+// CDRSource is one CDR file of a run. Open is called once, when the file's turn comes.
+type CDRSource struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+
+	// Format overrides Options.Format for this file (files of one run may come from different switches).
+	Format string
+
+	// Lines maps a line number of the source to the line of the file the user uploaded, when they
+	// differ (prepared files have blank lines removed). Reports use it; nil means the same numbers.
+	Lines func(line int64) int64
+}
+
+// ReaderSource wraps an already opened stream.
+func ReaderSource(name string, r io.Reader) CDRSource {
+	return CDRSource{
+		Name: name,
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(r), nil },
+	}
 }
@@ -0,0 +1,150 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationRule is a semantic check applied to every parsed CDR row.
+type ValidationRule uint8
+
+const (
+	RuleEndBeforeStart          ValidationRule = iota // end_time раньше start_time
+	RuleBillableExceedsDuration                       // billable_sec > duration
+	RuleUnknownDirection                              // direction не распознан
+	RuleUnknownDisposition                            // disposition не распознан
+	RuleEmptyCalledParty                              // пустой called_party
+	RuleDurationMismatch                              // duration не сходится с end_time - start_time
+
+	NumValidationRules = iota
+)
+
+var validationRuleNames = [NumValidationRules]string{
+	"end_before_start",
+	"billable_exceeds_duration",
+	"unknown_direction",
+	"unknown_disposition",
+	"empty_called_party",
+	"duration_mismatch",
+}
+
+func ParseValidationRule(s string) (ValidationRule, bool) {
+	s = strings.TrimSpace(s)
+	for i, name := range validationRuleNames {
+		if name == s {
+			return ValidationRule(i), true
+		}
+	}
+
+	return 0, false
+}
+
+func (r ValidationRule) String() string {
+	if int(r) < len(validationRuleNames) {
+		return validationRuleNames[r]
+	}
+
+	return "unknown"
+}
+
+// Severity tells what to do with a row that violates a rule.
+type Severity uint8
+
+const (
+	SeverityWarn   Severity = iota // посчитать в отчёте, строку тарифицировать (по умолчанию)
+	SeverityOff                    // правило выключено
+	SeverityReject                 // отбросить строку (в строгом режиме — прервать расчёт)
+)
+
+func ParseSeverity(s string) (Severity, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "warn":
+		return SeverityWarn, nil
+	case "off":
+		return SeverityOff, nil
+	case "reject":
+		return SeverityReject, nil
+	default:
+		return SeverityWarn, fmt.Errorf("validation: bad severity %q", s)
+	}
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityOff:
+		return "off"
+	case SeverityReject:
+		return "reject"
+	default:
+		return "warn"
+	}
+}
+
+// DefaultDurationTolerance is the allowed difference (seconds) between duration and the timestamps.
+const DefaultDurationTolerance = 1
+
+// ValidationConfig holds per-rule severities. Zero value means every rule is "warn".
+type ValidationConfig struct {
+	Severities [NumValidationRules]Severity
+
+	// DurationTolerance in seconds for duration_mismatch; nil means DefaultDurationTolerance,
+	// 0 requires an exact match.
+	DurationTolerance *int
+}
+
+// ParseValidationConfig("end_before_start:reject,unknown_direction:off") => config.
+// "all:<severity>" sets every rule and may be followed by per-rule overrides.
+func ParseValidationConfig(s string) (ValidationConfig, error) {
+	var cfg ValidationConfig
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return cfg, nil
+	}
+
+	for _, it := range strings.Split(s, ",") {
+		name, sev, ok := strings.Cut(it, ":")
+		if !ok {
+			return cfg, fmt.Errorf("validation: expected rule:severity, got %q", strings.TrimSpace(it))
+		}
+
+		severity, err := ParseSeverity(sev)
+		if err != nil {
+			return cfg, err
+		}
+
+		if strings.TrimSpace(name) == "all" {
+			for i := range cfg.Severities {
+				cfg.Severities[i] = severity
+			}
+
+			continue
+		}
+
+		rule, ok := ParseValidationRule(name)
+		if !ok {
+			return cfg, fmt.Errorf("validation: unknown rule %q", strings.TrimSpace(name))
+		}
+
+		cfg.Severities[rule] = severity
+	}
+
+	return cfg, nil
+}
+
+// RuleViolations is a per-rule counter in the report.
+type RuleViolations struct {
+	Rule     ValidationRule
+	Severity Severity
+	Count    int64
+}
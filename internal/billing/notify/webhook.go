@@ -0,0 +1,197 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+const (
+	webhookQueueSize = 1024
+
+	// webhookAttempts is how many times an event is sent before it is dropped;
+	// the pause between attempts starts at webhookRetryDelay and doubles.
+	webhookAttempts   = 5
+	webhookRetryDelay = 500 * time.Millisecond
+)
+
+// CreditEventPayload is the JSON body POSTed to the webhook for every credit threshold event.
+type CreditEventPayload struct {
+	Event          string `json:"event"` // credit_threshold
+	PhoneNumber    string `json:"phone_number"`
+	ClientName     string `json:"client_name,omitempty"`
+	ThresholdPct   int    `json:"threshold_pct"`
+	CreditLimitKop int64  `json:"credit_limit_kop"`
+	TotalCostKop   int64  `json:"total_cost_kop"`
+	CallID         string `json:"call_id,omitempty"`
+	At             string `json:"at"`
+}
+
+// Webhook delivers credit events to an HTTP endpoint from a background goroutine,
+// so the service never waits for the network. Events that don't fit into the queue are dropped.
+// Network errors, 429 and 5xx are retried; every request of one event carries the same
+// Idempotency-Key header, so the receiver can drop a repeat whose first response was lost.
+type Webhook struct {
+	url        string
+	client     *http.Client
+	queue      chan model.CreditEvent
+	retryDelay time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	stop   chan struct{} // closed by Close: pending retries give up
+	done   chan struct{}
+}
+
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return newWebhook(url, timeout, webhookRetryDelay)
+}
+
+func newWebhook(url string, timeout, retryDelay time.Duration) *Webhook {
+	w := &Webhook{
+		url:        url,
+		client:     &http.Client{Timeout: timeout},
+		queue:      make(chan model.CreditEvent, webhookQueueSize),
+		retryDelay: retryDelay,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go w.loop()
+
+	return w
+}
+
+func (w *Webhook) NotifyCredit(ev model.CreditEvent) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.queue <- ev:
+	default:
+		log.Printf("credit webhook: queue is full, drop event for %s (%d%%)", ev.PhoneNumber, ev.ThresholdPct)
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are sent once more;
+// events that still fail are not retried.
+func (w *Webhook) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+}
+
+func (w *Webhook) loop() {
+	defer close(w.done)
+
+	for ev := range w.queue {
+		if err := w.deliver(ev); err != nil {
+			log.Printf("credit webhook: drop event for %s (%d%%): %v", ev.PhoneNumber, ev.ThresholdPct, err)
+		}
+	}
+}
+
+// deliver sends ev until it is accepted, refused for good or out of attempts.
+func (w *Webhook) deliver(ev model.CreditEvent) error {
+	delay := w.retryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := w.send(ev)
+
+		var perm permanentError
+		if err == nil || errors.As(err, &perm) || attempt == webhookAttempts {
+			return err
+		}
+
+		log.Printf("credit webhook: attempt %d: %v", attempt, err)
+
+		select {
+		case <-time.After(delay):
+		case <-w.stop:
+			return err
+		}
+
+		delay *= 2
+	}
+}
+
+// permanentError is a response retrying won't change (4xx other than 429).
+type permanentError struct{ error }
+
+func (w *Webhook) send(ev model.CreditEvent) error {
+	body, err := json.Marshal(CreditEventPayload{
+		Event:          "credit_threshold",
+		PhoneNumber:    ev.PhoneNumber,
+		ClientName:     ev.ClientName,
+		ThresholdPct:   ev.ThresholdPct,
+		CreditLimitKop: int64(ev.CreditLimit),
+		TotalCostKop:   int64(ev.TotalCost),
+		CallID:         ev.CallID,
+		At:             ev.At.Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", eventKey(ev))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post %s: %w", w.url, err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("post %s: unexpected status %s", w.url, resp.Status)
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return permanentError{err}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// eventKey identifies an event: a threshold is crossed once per subscriber and call.
+func eventKey(ev model.CreditEvent) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%s|%d", ev.PhoneNumber, ev.ThresholdPct, ev.CallID, ev.At.UnixNano()))
+
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// receiver is a local webhook endpoint: it answers with statuses in turn (then 200)
+// and records every request.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []CreditEventPayload
+	keys     []string
+	accepted chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	rc := &receiver{statuses: statuses, accepted: make(chan struct{}, 16)}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p CreditEventPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+
+		rc.mu.Lock()
+		rc.bodies = append(rc.bodies, p)
+		rc.keys = append(rc.keys, r.Header.Get("Idempotency-Key"))
+
+		status := http.StatusOK
+		if len(rc.statuses) > 0 {
+			status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+		}
+		rc.mu.Unlock()
+
+		w.WriteHeader(status)
+
+		if status == http.StatusOK {
+			rc.accepted <- struct{}{}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return rc, srv
+}
+
+func (rc *receiver) requests() ([]CreditEventPayload, []string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return append([]CreditEventPayload(nil), rc.bodies...), append([]string(nil), rc.keys...)
+}
+
+func (rc *receiver) waitAccepted(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-rc.accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook did not deliver the event")
+	}
+}
+
+var testEvent = model.CreditEvent{
+	PhoneNumber:  "78123260000",
+	ClientName:   "Office Billing",
+	ThresholdPct: 80,
+	CreditLimit:  50000,
+	TotalCost:    40120,
+	CallID:       "call_000123",
+	At:           time.Date(2026, 2, 3, 14, 22, 10, 0, time.UTC),
+}
+
+func TestWebhookPayload(t *testing.T) {
+	rc, srv := newReceiver(t)
+
+	w := newWebhook(srv.URL, time.Second, time.Millisecond)
+	w.NotifyCredit(testEvent)
+	rc.waitAccepted(t)
+	w.Close()
+
+	bodies, keys := rc.requests()
+	if len(bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(bodies))
+	}
+
+	want := CreditEventPayload{
+		Event:          "credit_threshold",
+		PhoneNumber:    "78123260000",
+		ClientName:     "Office Billing",
+		ThresholdPct:   80,
+		CreditLimitKop: 50000,
+		TotalCostKop:   40120,
+		CallID:         "call_000123",
+		At:             "2026-02-03T14:22:10Z",
+	}
+	if bodies[0] != want {
+		t.Errorf("payload = %+v, want %+v", bodies[0], want)
+	}
+
+	if keys[0] == "" {
+		t.Error("no Idempotency-Key header")
+	}
+}
+
+func TestWebhookRetriesUntilAccepted(t *testing.T) {
+	rc, srv := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	w := newWebhook(srv.URL, time.Second, time.Millisecond)
+	w.NotifyCredit(testEvent)
+	rc.waitAccepted(t)
+
+	// принятое событие больше не отправляется
+	time.Sleep(50 * time.Millisecond)
+	w.Close()
+
+	bodies, keys := rc.requests()
+	if len(bodies) != 3 {
+		t.Fatalf("got %d requests, want 3 (two failures and one delivery)", len(bodies))
+	}
+
+	for i := range bodies {
+		if bodies[i] != bodies[0] || keys[i] != keys[0] {
+			t.Errorf("request %d differs from the first one: %+v %q", i, bodies[i], keys[i])
+		}
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	rc, srv := newReceiver(t, http.StatusBadRequest)
+
+	w := newWebhook(srv.URL, time.Second, time.Millisecond)
+	w.NotifyCredit(testEvent)
+
+	next := testEvent
+	next.ThresholdPct = 100
+	w.NotifyCredit(next)
+
+	// второе событие доставлено — значит, первое уже не повторяется
+	rc.waitAccepted(t)
+	w.Close()
+
+	bodies, keys := rc.requests()
+	if len(bodies) != 2 {
+		t.Fatalf("got %d requests, want 2", len(bodies))
+	}
+
+	if bodies[0].ThresholdPct != 80 || bodies[1].ThresholdPct != 100 {
+		t.Errorf("thresholds = %d, %d; want 80, 100", bodies[0].ThresholdPct, bodies[1].ThresholdPct)
+	}
+
+	if keys[0] == keys[1] {
+		t.Error("different events share an Idempotency-Key")
+	}
+}
+
+func TestWebhookGivesUpAfterAttempts(t *testing.T) {
+	statuses := make([]int, webhookAttempts+1)
+	for i := range statuses {
+		statuses[i] = http.StatusInternalServerError
+	}
+
+	rc, srv := newReceiver(t, statuses...)
+
+	w := newWebhook(srv.URL, time.Second, time.Millisecond)
+	w.NotifyCredit(testEvent)
+
+	// пауза между попытками — 1, 2, 4, 8 мс
+	time.Sleep(200 * time.Millisecond)
+	w.Close()
+
+	if bodies, _ := rc.requests(); len(bodies) != webhookAttempts {
+		t.Fatalf("got %d requests, want %d", len(bodies), webhookAttempts)
+	}
+}
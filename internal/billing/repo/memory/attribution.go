@@ -0,0 +1,76 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package memory
+
+import (
+	"context"
+	"sync/atomic"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+type attrSnap struct {
+	byAccountCode map[string]string
+	byTrunk       map[string]string
+}
+
+type AttributionMemoryRepo struct {
+	v atomic.Value // *attrSnap
+}
+
+func NewAttributionMemoryRepo() *AttributionMemoryRepo {
+	r := &AttributionMemoryRepo{}
+	r.v.Store(&attrSnap{byAccountCode: map[string]string{}, byTrunk: map[string]string{}})
+
+	return r
+}
+
+func (r *AttributionMemoryRepo) ReplaceAll(ctx context.Context, rules []model.AttributionRule) error {
+	_ = ctx
+
+	s := &attrSnap{byAccountCode: map[string]string{}, byTrunk: map[string]string{}}
+
+	for _, rule := range rules {
+		switch rule.Source {
+		case model.AttrAccountCode:
+			s.byAccountCode[rule.Key] = rule.PhoneNumber
+		case model.AttrTrunk:
+			s.byTrunk[rule.Key] = rule.PhoneNumber
+		}
+	}
+
+	r.v.Store(s)
+
+	return nil
+}
+
+func (r *AttributionMemoryRepo) Lookup(
+	ctx context.Context,
+	source model.AttributionSource,
+	key string,
+) (string, bool, error) {
+	_ = ctx
+
+	s := r.v.Load().(*attrSnap)
+
+	var (
+		phone string
+		ok    bool
+	)
+
+	switch source {
+	case model.AttrAccountCode:
+		phone, ok = s.byAccountCode[key]
+	case model.AttrTrunk:
+		phone, ok = s.byTrunk[key]
+	}
+
+	return phone, ok, nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+const (
+	// chargedCallsTTL is how long a deducted call is remembered: a file re-sent later is charged again.
+	chargedCallsTTL = 90 * 24 * time.Hour
+	// maxChargedCalls bounds the remembered calls; the oldest are forgotten first.
+	maxChargedCalls = 2_000_000
+)
+
+// BalanceMemoryRepo keeps prepaid balances. Balances change on every committed run,
+// so unlike the reference data repos it uses a plain mutex instead of snapshots.
+type BalanceMemoryRepo struct {
+	mu       sync.Mutex
+	balances map[string]*model.Balance
+
+	// charged remembers deducted calls by (phone, CallID); order is the same calls, oldest first.
+	charged map[chargedKey]struct{}
+	order   []chargedCall
+}
+
+type chargedKey struct {
+	phone  string
+	callID string
+}
+
+type chargedCall struct {
+	key chargedKey
+	at  time.Time
+}
+
+func NewBalanceMemoryRepo() *BalanceMemoryRepo {
+	return &BalanceMemoryRepo{
+		balances: make(map[string]*model.Balance),
+		charged:  make(map[chargedKey]struct{}),
+	}
+}
+
+func (r *BalanceMemoryRepo) TopUp(ctx context.Context, phone string, amount model.Money) (model.Balance, error) {
+	_ = ctx
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b := r.balances[phone]
+	if b == nil {
+		b = &model.Balance{PhoneNumber: phone}
+		r.balances[phone] = b
+	}
+
+	b.Amount += amount
+	b.UpdatedAt = time.Now()
+
+	return *b, nil
+}
+
+func (r *BalanceMemoryRepo) Get(ctx context.Context, phone string) (model.Balance, bool, error) {
+	_ = ctx
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b := r.balances[phone]
+	if b == nil {
+		return model.Balance{}, false, nil
+	}
+
+	return *b, true, nil
+}
+
+func (r *BalanceMemoryRepo) Charged(ctx context.Context, phone, callID string) (bool, error) {
+	_ = ctx
+
+	if callID == "" {
+		return false, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expireLocked(time.Now())
+
+	_, ok := r.charged[chargedKey{phone: phone, callID: callID}]
+
+	return ok, nil
+}
+
+func (r *BalanceMemoryRepo) Commit(ctx context.Context, charges []model.BalanceCharges) error {
+	_ = ctx
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.expireLocked(now)
+
+	for _, c := range charges {
+		b := r.balances[c.PhoneNumber]
+		if b == nil {
+			continue
+		}
+
+		amount := c.NoCallID
+
+		for callID, m := range c.Calls {
+			k := chargedKey{phone: c.PhoneNumber, callID: callID}
+			if _, ok := r.charged[k]; ok {
+				continue
+			}
+
+			r.charged[k] = struct{}{}
+			r.order = append(r.order, chargedCall{key: k, at: now})
+			amount += m
+		}
+
+		b.Amount -= amount
+		b.UpdatedAt = now
+	}
+
+	r.expireLocked(now)
+
+	return nil
+}
+
+// expireLocked forgets calls older than chargedCallsTTL and the oldest ones over maxChargedCalls.
+func (r *BalanceMemoryRepo) expireLocked(now time.Time) {
+	n := 0
+	for n < len(r.order) && (len(r.order)-n > maxChargedCalls || now.Sub(r.order[n].at) > chargedCallsTTL) {
+		delete(r.charged, r.order[n].key)
+		n++
+	}
+
+	// срез сдвигается вперёд; при следующем росте append скопирует только живую часть
+	r.order = r.order[n:]
+}
@@ -11,17 +11,27 @@ package memory
 
 import (
 	"context"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
 
 	"ukrainian_call_center_scam_goev/internal/billing/model"
+	"ukrainian_call_center_scam_goev/internal/billing/repo"
 )
 
 type subSnap struct {
 	byPhone map[string]model.Subscriber
+	phones  []string // sorted
 }
 
+// SubscriberMemoryRepo keeps subscribers in an immutable snapshot.
+// Readers never lock; writers are serialized and publish a modified copy (copy-on-write).
 type SubscriberMemoryRepo struct {
-	v atomic.Value // *subSnap
+	writeMu sync.Mutex
+	v       atomic.Value // *subSnap
 }
 
 func NewSubscriberMemoryRepo() *SubscriberMemoryRepo {
@@ -34,12 +44,24 @@ func NewSubscriberMemoryRepo() *SubscriberMemoryRepo {
 func (r *SubscriberMemoryRepo) ReplaceAll(ctx context.Context, subs []model.Subscriber) error {
 	_ = ctx
 
+	// снимок строится под writeMu: иначе Create/Update между построением и публикацией
+	// потерялись бы, а два ReplaceAll могли бы опубликоваться не в порядке вызова
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
 	m := make(map[string]model.Subscriber, len(subs))
 	for _, s := range subs {
 		m[s.PhoneNumber] = s
 	}
 
-	r.v.Store(&subSnap{byPhone: m})
+	phones := make([]string, 0, len(m))
+	for p := range m {
+		phones = append(phones, p)
+	}
+
+	sort.Strings(phones)
+
+	r.v.Store(&subSnap{byPhone: m, phones: phones})
 
 	return nil
 }
@@ -52,3 +74,100 @@ func (r *SubscriberMemoryRepo) GetByPhone(ctx context.Context, phone string) (mo
 
 	return sub, ok, nil
 }
+
+func (r *SubscriberMemoryRepo) Create(ctx context.Context, sub model.Subscriber) error {
+	_ = ctx
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	s := r.v.Load().(*subSnap)
+	if _, ok := s.byPhone[sub.PhoneNumber]; ok {
+		return repo.ErrAlreadyExists
+	}
+
+	m := maps.Clone(s.byPhone)
+	m[sub.PhoneNumber] = sub
+
+	i := sort.SearchStrings(s.phones, sub.PhoneNumber)
+	phones := slices.Insert(slices.Clone(s.phones), i, sub.PhoneNumber)
+
+	r.v.Store(&subSnap{byPhone: m, phones: phones})
+
+	return nil
+}
+
+func (r *SubscriberMemoryRepo) Update(ctx context.Context, sub model.Subscriber) error {
+	_ = ctx
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	s := r.v.Load().(*subSnap)
+	if _, ok := s.byPhone[sub.PhoneNumber]; !ok {
+		return repo.ErrNotFound
+	}
+
+	m := maps.Clone(s.byPhone)
+	m[sub.PhoneNumber] = sub
+
+	// набор номеров не меняется — отсортированный срез можно переиспользовать
+	r.v.Store(&subSnap{byPhone: m, phones: s.phones})
+
+	return nil
+}
+
+func (r *SubscriberMemoryRepo) Delete(ctx context.Context, phone string) error {
+	_ = ctx
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	s := r.v.Load().(*subSnap)
+	if _, ok := s.byPhone[phone]; !ok {
+		return repo.ErrNotFound
+	}
+
+	m := maps.Clone(s.byPhone)
+	delete(m, phone)
+
+	i := sort.SearchStrings(s.phones, phone)
+	phones := slices.Delete(slices.Clone(s.phones), i, i+1)
+
+	r.v.Store(&subSnap{byPhone: m, phones: phones})
+
+	return nil
+}
+
+func (r *SubscriberMemoryRepo) List(ctx context.Context, q model.SubscriberQuery) ([]model.Subscriber, int, error) {
+	_ = ctx
+
+	s := r.v.Load().(*subSnap)
+	name := strings.ToLower(q.NameContains)
+
+	var (
+		out   []model.Subscriber
+		total int
+	)
+
+	// номера отсортированы, поэтому по префиксу достаточно пройти один непрерывный диапазон
+	for i := sort.SearchStrings(s.phones, q.PhonePrefix); i < len(s.phones); i++ {
+		phone := s.phones[i]
+		if !strings.HasPrefix(phone, q.PhonePrefix) {
+			break
+		}
+
+		sub := s.byPhone[phone]
+		if name != "" && !strings.Contains(strings.ToLower(sub.ClientName), name) {
+			continue
+		}
+
+		if total >= q.Offset && (q.Limit <= 0 || len(out) < q.Limit) {
+			out = append(out, sub)
+		}
+
+		total++
+	}
+
+	return out, total, nil
+}
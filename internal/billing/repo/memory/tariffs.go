@@ -15,8 +15,10 @@ import (
 	"sync/atomic"
 
 	"ukrainian_call_center_scam_goev/internal/billing/model"
+	"ukrainian_call_center_scam_goev/internal/billing/repo"
 )
 
+// tariffSnap is never modified after it is stored: ReplaceAll builds a new one.
 type tariffSnap struct {
 	rules        []model.TariffRule
 	byPrefix     map[string][]int
@@ -61,21 +63,20 @@ func (r *TariffMemoryRepo) ReplaceAll(ctx context.Context, rules []model.TariffR
 	return nil
 }
 
-func (r *TariffMemoryRepo) VisitByNumber(
-	ctx context.Context,
-	number string,
-	visit func(rule *model.TariffRule, prefixLen int) bool,
-) error {
+func (r *TariffMemoryRepo) Snapshot(ctx context.Context) (repo.TariffTable, error) {
 	_ = ctx
 
-	s := r.v.Load().(*tariffSnap)
+	return r.v.Load().(*tariffSnap), nil
+}
+
+func (s *tariffSnap) VisitByNumber(number string, visit func(rule *model.TariffRule, prefixLen int) bool) {
 	if len(s.rules) == 0 {
-		return nil
+		return
 	}
 
 	n := normalizeNumber(number)
 	if n == "" {
-		return nil
+		return
 	}
 
 	maxL := s.maxPrefixLen
@@ -94,12 +95,10 @@ func (r *TariffMemoryRepo) VisitByNumber(
 
 		for _, idx := range idxs {
 			if !visit(&s.rules[idx], l) {
-				return nil
+				return
 			}
 		}
 	}
-
-	return nil
 }
 
 func normalizeNumber(s string) string {
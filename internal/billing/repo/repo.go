@@ -11,16 +11,55 @@ package repo
 
 import (
 	"context"
+	"errors"
 
 	"ukrainian_call_center_scam_goev/internal/billing/model"
 )
 
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+)
+
 type SubscriberRepository interface {
 	ReplaceAll(ctx context.Context, subs []model.Subscriber) error
 	GetByPhone(ctx context.Context, phone string) (model.Subscriber, bool, error)
+
+	// Create fails with ErrAlreadyExists, Update and Delete fail with ErrNotFound.
+	Create(ctx context.Context, sub model.Subscriber) error
+	Update(ctx context.Context, sub model.Subscriber) error
+	Delete(ctx context.Context, phone string) error
+
+	// List returns a page of subscribers ordered by phone number and the total number of matches.
+	List(ctx context.Context, q model.SubscriberQuery) ([]model.Subscriber, int, error)
 }
 
 type TariffRepository interface {
 	ReplaceAll(ctx context.Context, rules []model.TariffRule) error
-	VisitByNumber(ctx context.Context, number string, visit func(rule *model.TariffRule, prefixLen int) bool) error
+
+	// Snapshot returns the current table; later ReplaceAll calls don't change it.
+	Snapshot(ctx context.Context) (TariffTable, error)
+}
+
+// TariffTable is an immutable tariff table: a run rates every row against the same one.
+type TariffTable interface {
+	// VisitByNumber visits rules whose prefix matches number, longest prefix first, until visit returns false.
+	VisitByNumber(number string, visit func(rule *model.TariffRule, prefixLen int) bool)
+}
+
+type AttributionRepository interface {
+	ReplaceAll(ctx context.Context, rules []model.AttributionRule) error
+	Lookup(ctx context.Context, source model.AttributionSource, key string) (string, bool, error)
+}
+
+type BalanceRepository interface {
+	TopUp(ctx context.Context, phone string, amount model.Money) (model.Balance, error)
+	Get(ctx context.Context, phone string) (model.Balance, bool, error)
+
+	// Charged reports whether call callID of phone was already deducted by a committed run.
+	Charged(ctx context.Context, phone, callID string) (bool, error)
+
+	// Commit deducts staged charges of a run at once. Every (phone, CallID) is deducted at most once:
+	// a call another run has committed since staging is skipped. Phones without an account are skipped.
+	Commit(ctx context.Context, charges []model.BalanceCharges) error
 }
@@ -0,0 +1,60 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"strings"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// resolveSubscriberPhone walks the attribution chain and returns the phone number of the
+// subscriber the call is billed to. If no source resolves, the call stays on CallingParty.
+func (s *Service) resolveSubscriberPhone(
+	ctx context.Context,
+	chain []model.AttributionSource,
+	cdr model.CDRRecord,
+) (string, error) {
+	for _, src := range chain {
+		switch src {
+		case model.AttrCallingParty:
+			if cdr.CallingParty != "" {
+				return cdr.CallingParty, nil
+			}
+		case model.AttrCalledParty:
+			// для входящих абонент — тот, кому звонят
+			if cdr.Direction == model.DirIncoming && cdr.CalledParty != "" {
+				return cdr.CalledParty, nil
+			}
+		case model.AttrAccountCode, model.AttrTrunk:
+			key := cdr.AccountCode
+			if src == model.AttrTrunk {
+				key = cdr.TrunkName
+			}
+
+			key = strings.TrimSpace(key)
+			if key == "" {
+				continue
+			}
+
+			phone, ok, err := s.attribution.Lookup(ctx, src, key)
+			if err != nil {
+				return "", err
+			}
+
+			if ok {
+				return phone, nil
+			}
+		}
+	}
+
+	return cdr.CallingParty, nil
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+	"ukrainian_call_center_scam_goev/internal/billing/repo"
+)
+
+// TopUpBalance adds amount to the prepaid balance of phone, opening the account on first top-up.
+func (s *Service) TopUpBalance(ctx context.Context, phone string, amount model.Money) (model.Balance, error) {
+	phone = strings.TrimSpace(phone)
+	if phone == "" {
+		return model.Balance{}, fmt.Errorf("%w: phone_number is required", ErrInvalidArgument)
+	}
+
+	if amount <= 0 {
+		return model.Balance{}, fmt.Errorf("%w: top-up amount must be positive", ErrInvalidArgument)
+	}
+
+	return s.balances.TopUp(ctx, phone, amount)
+}
+
+func (s *Service) GetBalance(ctx context.Context, phone string) (model.Balance, error) {
+	phone = strings.TrimSpace(phone)
+
+	b, ok, err := s.balances.Get(ctx, phone)
+	if err != nil {
+		return model.Balance{}, err
+	}
+
+	if !ok {
+		return model.Balance{}, fmt.Errorf("balance %q: %w", phone, repo.ErrNotFound)
+	}
+
+	return b, nil
+}
+
+// CommitRun settles a successful run: deducts its staged prepaid charges (Report.Charges)
+// and delivers its credit threshold events to the alert sink. Call it once, after the report is kept;
+// a failed or canceled run is simply not committed.
+func (s *Service) CommitRun(ctx context.Context, report model.Report) error {
+	if len(report.Charges) > 0 {
+		if err := s.balances.Commit(ctx, report.Charges); err != nil {
+			return err
+		}
+	}
+
+	s.notifyCredit(report.CreditEvents)
+
+	return nil
+}
+
+// balanceLedger stages prepaid charges of a run. A balance is read once, on the first call
+// of the subscriber, and then charged locally in input order, so decisions of the policy don't depend
+// on worker timing. It is used only by the row sequencer and needs no lock of its own.
+type balanceLedger struct {
+	repo   repo.BalanceRepository
+	policy model.NegativeBalancePolicy
+
+	accounts map[string]*ledgerAccount
+}
+
+type ledgerAccount struct {
+	prepaid bool
+	balance model.Money
+	charges model.BalanceCharges
+	summary model.BalanceSummary
+	touched bool // the account has a line in the report
+}
+
+func newBalanceLedger(balances repo.BalanceRepository, policy model.NegativeBalancePolicy) *balanceLedger {
+	return &balanceLedger{repo: balances, policy: policy, accounts: make(map[string]*ledgerAccount)}
+}
+
+func (l *balanceLedger) account(ctx context.Context, phone string) (*ledgerAccount, error) {
+	if a := l.accounts[phone]; a != nil {
+		return a, nil
+	}
+
+	bal, ok, err := l.repo.Get(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &ledgerAccount{
+		prepaid: ok,
+		balance: bal.Amount,
+		charges: model.BalanceCharges{PhoneNumber: phone, Calls: make(map[string]model.Money)},
+		summary: model.BalanceSummary{PhoneNumber: phone},
+	}
+	l.accounts[phone] = a
+
+	return a, nil
+}
+
+// charge stages a rated call. It returns false when the call must not be accounted (blocked by policy).
+// A call already charged (in this run or a committed one) is accounted, but not charged again.
+func (l *balanceLedger) charge(ctx context.Context, phone, callID string, cost model.Money) (bool, error) {
+	if cost <= 0 {
+		return true, nil
+	}
+
+	a, err := l.account(ctx, phone)
+	if err != nil || !a.prepaid {
+		return true, err
+	}
+
+	a.touched = true
+
+	if callID != "" {
+		_, dup := a.charges.Calls[callID]
+		if !dup {
+			if dup, err = l.repo.Charged(ctx, phone, callID); err != nil {
+				return false, err
+			}
+		}
+
+		if dup {
+			a.summary.CallsDuplicate++
+			return true, nil
+		}
+	}
+
+	charged := cost
+
+	switch l.policy {
+	case model.BalanceBlock:
+		if a.balance <= 0 {
+			// звонок не помечается списанным: после пополнения его можно перетарифицировать
+			a.summary.CallsBlocked++
+			return false, nil
+		}
+	case model.BalanceOverdraft:
+		if available := max(a.balance, 0); cost > available {
+			charged = available
+			a.summary.Overdraft += cost - available
+		}
+	}
+
+	a.balance -= charged
+	a.summary.CallsCharged++
+	a.summary.Charged += charged
+
+	if callID != "" {
+		a.charges.Calls[callID] = charged
+	} else {
+		a.charges.NoCallID += charged
+	}
+
+	return true, nil
+}
+
+// summaries builds the report section: balances as the run leaves them.
+func (l *balanceLedger) summaries() []model.BalanceSummary {
+	out := make([]model.BalanceSummary, 0, len(l.accounts))
+
+	for _, a := range l.accounts {
+		if !a.touched {
+			continue
+		}
+
+		v := a.summary
+		v.BalanceAfter = a.balance
+		out = append(out, v)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].PhoneNumber < out[j].PhoneNumber })
+
+	return out
+}
+
+// staged returns the charges to commit (non-nil, even if there are none).
+func (l *balanceLedger) staged() []model.BalanceCharges {
+	out := []model.BalanceCharges{}
+
+	for _, a := range l.accounts {
+		if len(a.charges.Calls) > 0 || a.charges.NoCallID != 0 {
+			out = append(out, a.charges)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].PhoneNumber < out[j].PhoneNumber })
+
+	return out
+}
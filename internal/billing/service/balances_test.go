@@ -0,0 +1,80 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+func TestResentFileIsReportedButNotChargedAgain(t *testing.T) {
+	svc := newTestService(t, 4, creditSubscribers)
+	ctx := context.Background()
+
+	const prepaid = "78123260037"
+
+	if _, err := svc.TopUpBalance(ctx, prepaid, 100000); err != nil {
+		t.Fatal(err)
+	}
+
+	cdr := testCDR(200, "78123260000", prepaid)
+	opt := model.Options{CollectCalls: true}
+
+	run := func() model.Report {
+		report, err := svc.TariffCDRStream(ctx, strings.NewReader(cdr), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := svc.CommitRun(ctx, report); err != nil {
+			t.Fatal(err)
+		}
+
+		return report
+	}
+
+	first := run()
+
+	charged, err := svc.GetBalance(ctx, prepaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := run()
+
+	// звонки и итоги повторного файла те же, меняется только секция списаний
+	if !reflect.DeepEqual(second.Totals, first.Totals) || len(second.Calls) != len(first.Calls) {
+		t.Fatalf("resent file reports other usage:\n got %+v\nwant %+v", second.Totals, first.Totals)
+	}
+
+	if len(second.Balances) != 1 {
+		t.Fatalf("balances = %+v, want one prepaid subscriber", second.Balances)
+	}
+
+	got := second.Balances[0]
+	want := model.BalanceSummary{PhoneNumber: prepaid, BalanceAfter: charged.Amount, CallsDuplicate: 100}
+
+	if got != want {
+		t.Errorf("balance summary of the resent file = %+v, want %+v", got, want)
+	}
+
+	after, err := svc.GetBalance(ctx, prepaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after.Amount != charged.Amount {
+		t.Errorf("balance after the resent file = %d, want %d", after.Amount, charged.Amount)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// callStreamBacklog is how many rows of a streaming run may wait for their calls to be written.
+const callStreamBacklog = 1024
+
+// callStream hands rated calls to Options.OnCall from a writer goroutine of the run, so a slow
+// consumer holds back only its own run, not the shared workers. The reader takes a slot for every
+// row before enqueuing it and the slot is freed when the row's call is written (or when the row turns
+// out to have no call): the calls channel never fills up and the sequencer never waits for it.
+type callStream struct {
+	emit  func(call model.RatedCall) error
+	fail  func(err error)
+	slots chan struct{}
+	calls chan model.RatedCall
+
+	queued   sync.WaitGroup // calls passed and not written yet
+	stop     chan struct{}
+	stopOnce sync.Once
+	exited   chan struct{}
+}
+
+// newCallStream starts the writer; fail gets the first OnCall error (calls after it are dropped).
+func newCallStream(emit func(call model.RatedCall) error, fail func(err error)) *callStream {
+	cs := &callStream{
+		emit:   emit,
+		fail:   fail,
+		slots:  make(chan struct{}, callStreamBacklog),
+		calls:  make(chan model.RatedCall, callStreamBacklog),
+		stop:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+
+	go cs.run()
+
+	return cs
+}
+
+func (cs *callStream) run() {
+	defer close(cs.exited)
+
+	var err error
+
+	for {
+		select {
+		case call := <-cs.calls:
+			if err == nil {
+				if err = cs.emit(call); err != nil {
+					cs.fail(fmt.Errorf("stream calls: %w", err))
+				}
+			}
+
+			<-cs.slots
+			cs.queued.Done()
+		case <-cs.stop:
+			return
+		}
+	}
+}
+
+// reserve takes a slot for a row about to be enqueued; it waits while the consumer lags behind.
+func (cs *callStream) reserve(ctx, stopCtx context.Context) error {
+	select {
+	case cs.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-stopCtx.Done():
+		return ErrStopped
+	}
+}
+
+// pass hands the call of an accounted row to the writer; nil only frees the row's slot.
+func (cs *callStream) pass(call *model.RatedCall) {
+	if call == nil {
+		<-cs.slots
+		return
+	}
+
+	cs.queued.Add(1)
+	cs.calls <- *call
+}
+
+// flushed is closed when every call passed so far is written. Rows must not be accounted meanwhile.
+func (cs *callStream) flushed() <-chan struct{} {
+	ch := make(chan struct{})
+
+	go func() {
+		cs.queued.Wait()
+		close(ch)
+	}()
+
+	return ch
+}
+
+// halt stops the writer and waits for it: OnCall is not called after halt returns.
+// Calls not written yet are dropped; the run has no rows in flight by then.
+func (cs *callStream) halt() {
+	cs.stopOnce.Do(func() { close(cs.stop) })
+	<-cs.exited
+
+	for {
+		select {
+		case <-cs.calls:
+			cs.queued.Done()
+		default:
+			return
+		}
+	}
+}
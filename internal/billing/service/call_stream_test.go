@@ -0,0 +1,116 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+func TestSlowCallStreamDoesNotHoldOtherRuns(t *testing.T) {
+	const subscribers = "phone_number;client_name\n78123260000;Office Billing\n"
+
+	svc := newTestService(t, 2, subscribers)
+	cdr := testCDR(5000, "78123260000")
+
+	release := make(chan struct{})
+	slowDone := make(chan error, 1)
+
+	var streamed atomic.Int64
+
+	// клиент первого расчёта не читает ответ, пока не закончится второй расчёт
+	go func() {
+		_, err := svc.TariffCDRStream(context.Background(), strings.NewReader(cdr), model.Options{
+			OnCall: func(model.RatedCall) error {
+				<-release
+				streamed.Add(1)
+
+				return nil
+			},
+		})
+		slowDone <- err
+	}()
+
+	fast := make(chan error, 1)
+
+	go func() {
+		_, err := svc.TariffCDRStream(context.Background(), strings.NewReader(cdr), model.Options{})
+		fast <- err
+	}()
+
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		close(release)
+		t.Fatal("a run waits for the slow call stream of another run")
+	}
+
+	close(release)
+
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := streamed.Load(); got != 5000 {
+		t.Errorf("streamed %d calls, want 5000", got)
+	}
+}
+
+func TestCallStreamIsNotCalledAfterRunReturns(t *testing.T) {
+	const subscribers = "phone_number;client_name\n78123260000;Office Billing\n"
+
+	svc := newTestService(t, 4, subscribers)
+	cdr := testCDR(5000, "78123260000")
+
+	var (
+		returned atomic.Bool
+		late     atomic.Int64
+		n        atomic.Int64
+	)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stop := errors.New("client went away")
+
+	_, err := svc.TariffCDRStream(ctx, strings.NewReader(cdr), model.Options{
+		OnCall: func(model.RatedCall) error {
+			if returned.Load() {
+				late.Add(1)
+			}
+
+			if n.Add(1) == 100 {
+				cancel(stop)
+			}
+
+			time.Sleep(10 * time.Microsecond)
+
+			return nil
+		},
+	})
+	returned.Store(true)
+
+	if !errors.Is(err, stop) {
+		t.Fatalf("err = %v, want %v", err, stop)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if got := late.Load(); got != 0 {
+		t.Errorf("OnCall was called %d times after the run returned", got)
+	}
+}
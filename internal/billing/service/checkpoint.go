@@ -0,0 +1,391 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/cdrformat"
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// checkpointState is model.Checkpoint.State: everything a run accumulated up to the checkpoint.
+// Fields are exported for gob only.
+type checkpointState struct {
+	Seq        uint64
+	Totals     []model.SubscriberTotal
+	Unknowns   []model.UnknownSubscriber
+	Ledger     []ledgerAccountState
+	Events     []model.CreditEvent
+	Files      []model.FileStats
+	Rejects    model.RejectStats
+	Violations [model.NumValidationRules]int64
+	Dedup      dedupState
+}
+
+type ledgerAccountState struct {
+	Phone   string
+	Prepaid bool
+	Balance model.Money
+	Charges model.BalanceCharges
+	Summary model.BalanceSummary
+	Touched bool
+}
+
+type checkpointCall struct {
+	Seq  uint64
+	Call model.RatedCall
+}
+
+type dedupState struct {
+	Seen    []dedupSeen
+	Ring    []dedupKeyState
+	Next    int
+	Pending []dedupRowState // last_wins: rows not yet rated
+	Stats   model.DuplicateStats
+}
+
+type dedupKeyState struct {
+	CallID  string
+	Start   int64
+	Calling string
+}
+
+type dedupSeen struct {
+	Key  dedupKeyState
+	File string
+	Line int64
+}
+
+type dedupRowState struct {
+	File    string
+	Line    int64
+	FileIdx int
+	CDR     model.CDRRecord
+	Bytes   int64
+}
+
+// checkpointDue reports whether it is time to take a checkpoint.
+func (rd *cdrReader) checkpointDue() bool {
+	return rd.opt.OnCheckpoint != nil && rd.opt.CheckpointEvery > 0 &&
+		time.Since(rd.lastCheckpoint) >= rd.opt.CheckpointEvery
+}
+
+// checkpoint waits until the workers have accounted every enqueued row, snapshots the run
+// and hands it to OnCheckpoint. offset and line point right after the last row read from source src.
+func (rd *cdrReader) checkpoint(src int, offset, line int64) bool {
+	batch := rd.batch
+
+	if !rd.quiesce() {
+		return false
+	}
+
+	var state bytes.Buffer
+	if err := gob.NewEncoder(&state).Encode(rd.snapshot()); err != nil {
+		batch.setErr(fmt.Errorf("checkpoint: %w", err))
+		return false
+	}
+
+	// звонки дописываются частями: в точку идут только накопленные после предыдущей
+	calls, savedCalls, err := rd.newCalls()
+	if err != nil {
+		batch.setErr(fmt.Errorf("checkpoint: %w", err))
+		return false
+	}
+
+	err = rd.opt.OnCheckpoint(model.Checkpoint{
+		Source:         src,
+		Offset:         offset,
+		Line:           line,
+		ProcessedBytes: atomic.LoadInt64(&batch.processedBytes),
+		Tariffs:        rd.tariffs,
+		State:          state.Bytes(),
+		Calls:          calls,
+	})
+	if err != nil {
+		batch.setErr(fmt.Errorf("checkpoint: %w", err))
+		return false
+	}
+
+	rd.savedCalls = savedCalls
+	rd.lastCheckpoint = time.Now()
+
+	return true
+}
+
+// quiesce waits for the workers to finish rows of this run.
+func (rd *cdrReader) quiesce() bool {
+	batch := rd.batch
+
+	select {
+	case <-batch.idle():
+	case <-rd.ctx.Done():
+		batch.setErr(context.Cause(rd.ctx))
+		return false
+	case <-rd.svc.stopCtx.Done():
+		batch.setErr(ErrStopped)
+		return false
+	}
+
+	return batch.getErr() == nil
+}
+
+// newCalls encodes collected calls the previous checkpoints don't have yet; nil if there are none.
+// The second result is the number of collected calls the checkpoint covers.
+func (rd *cdrReader) newCalls() ([]byte, int, error) {
+	b := rd.batch
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.calls) == rd.savedCalls {
+		return nil, rd.savedCalls, nil
+	}
+
+	chunk := make([]checkpointCall, 0, len(b.calls)-rd.savedCalls)
+	for _, c := range b.calls[rd.savedCalls:] {
+		chunk = append(chunk, checkpointCall{Seq: c.seq, Call: c.call})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(chunk); err != nil {
+		return nil, 0, err
+	}
+
+	return buf.Bytes(), len(b.calls), nil
+}
+
+func (rd *cdrReader) snapshot() checkpointState {
+	b := rd.batch
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st := checkpointState{
+		Seq:        rd.seq,
+		Totals:     make([]model.SubscriberTotal, 0, len(b.totals)),
+		Unknowns:   make([]model.UnknownSubscriber, 0, len(b.unknowns)),
+		Ledger:     make([]ledgerAccountState, 0, len(b.ledger.accounts)),
+		Events:     b.events,
+		Files:      b.files,
+		Rejects:    b.rejects,
+		Violations: rd.validator.counts,
+		Dedup:      rd.dedup.state(),
+	}
+
+	for _, t := range b.totals {
+		st.Totals = append(st.Totals, *t)
+	}
+
+	for _, u := range b.unknowns {
+		st.Unknowns = append(st.Unknowns, *u)
+	}
+
+	// счета читаются один раз за расчёт: после перезапуска их балансы берутся отсюда, а не из хранилища
+	for phone, a := range b.ledger.accounts {
+		st.Ledger = append(st.Ledger, ledgerAccountState{
+			Phone:   phone,
+			Prepaid: a.prepaid,
+			Balance: a.balance,
+			Charges: a.charges,
+			Summary: a.summary,
+			Touched: a.touched,
+		})
+	}
+
+	return st
+}
+
+// restore loads a checkpoint into a fresh run.
+func (rd *cdrReader) restore(cp *model.Checkpoint) error {
+	var st checkpointState
+	if err := gob.NewDecoder(bytes.NewReader(cp.State)).Decode(&st); err != nil {
+		return fmt.Errorf("%w: bad checkpoint: %v", ErrInvalidArgument, err)
+	}
+
+	b := rd.batch
+	if len(st.Files) != len(b.files) {
+		return fmt.Errorf("%w: checkpoint has %d files, run has %d", ErrInvalidArgument, len(st.Files), len(b.files))
+	}
+
+	rd.seq = st.Seq
+
+	for i := range st.Totals {
+		b.totals[st.Totals[i].PhoneNumber] = &st.Totals[i]
+	}
+
+	for i := range st.Unknowns {
+		b.unknowns[st.Unknowns[i].PhoneNumber] = &st.Unknowns[i]
+	}
+
+	for _, a := range st.Ledger {
+		if a.Charges.Calls == nil {
+			a.Charges.Calls = make(map[string]model.Money)
+		}
+
+		b.ledger.accounts[a.Phone] = &ledgerAccount{
+			prepaid: a.Prepaid,
+			balance: a.Balance,
+			charges: a.Charges,
+			summary: a.Summary,
+			touched: a.Touched,
+		}
+	}
+
+	b.events = st.Events
+
+	if b.collectCalls {
+		for _, raw := range cp.CallChunks {
+			var chunk []checkpointCall
+			if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&chunk); err != nil {
+				return fmt.Errorf("%w: bad checkpoint calls: %v", ErrInvalidArgument, err)
+			}
+
+			for _, c := range chunk {
+				b.calls = append(b.calls, ratedCallSeq{seq: c.Seq, call: c.Call})
+			}
+		}
+
+		rd.savedCalls = len(b.calls)
+	}
+
+	for i := range b.files {
+		name := b.files[i].Name
+		b.files[i] = st.Files[i]
+		b.files[i].Name = name
+	}
+
+	b.rejects = st.Rejects
+	rd.validator.counts = st.Violations
+	rd.dedup.restore(st.Dedup)
+
+	atomic.StoreInt64(&b.processedBytes, cp.ProcessedBytes)
+
+	if b.onProcessedBytes != nil && cp.ProcessedBytes > 0 {
+		b.onProcessedBytes(cp.ProcessedBytes)
+	}
+
+	// строки, которые last_wins ещё держит, посчитает воркер, когда их оценит
+	var rows, rejected int64
+	for _, f := range st.Files {
+		rows += f.Rows
+		rejected += f.Rejected
+	}
+
+	b.processedRows(rows-int64(len(st.Dedup.Pending)), rejected)
+
+	return nil
+}
+
+// skipTo positions r of a resumed source at offset. The first line is replayed to the parser first:
+// formats with a header row bind columns by it.
+func skipTo(r io.Reader, parser cdrformat.Parser, offset int64) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	first, err := br.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && first != "") {
+		return nil, fmt.Errorf("resume: read first line: %w", err)
+	}
+
+	if int64(len(first)) > offset {
+		return nil, fmt.Errorf("%w: checkpoint offset %d is inside the first line", ErrInvalidArgument, offset)
+	}
+
+	_, _, _ = parser.Parse(strings.TrimPrefix(strings.TrimRight(first, "\r\n"), cdrformat.UTF8BOM))
+
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("resume: seek: %w", err)
+		}
+
+		return r, nil
+	}
+
+	if _, err := io.CopyN(io.Discard, br, offset-int64(len(first))); err != nil {
+		return nil, fmt.Errorf("resume: skip to offset %d: %w", offset, err)
+	}
+
+	return br, nil
+}
+
+func (d *cdrDedup) state() dedupState {
+	st := dedupState{
+		Seen:    make([]dedupSeen, 0, len(d.seen)),
+		Next:    d.next,
+		Pending: make([]dedupRowState, 0, len(d.pending)),
+		Stats:   d.stats,
+	}
+
+	for k, pos := range d.seen {
+		st.Seen = append(st.Seen, dedupSeen{Key: k.state(), File: pos.file, Line: pos.line})
+	}
+
+	if d.ring != nil {
+		st.Ring = make([]dedupKeyState, len(d.ring))
+		for i, k := range d.ring {
+			st.Ring[i] = k.state()
+		}
+	}
+
+	for _, r := range d.pending {
+		st.Pending = append(st.Pending, dedupRowState{
+			File:    r.pos.file,
+			Line:    r.pos.line,
+			FileIdx: r.file,
+			CDR:     r.cdr,
+			Bytes:   r.bytes,
+		})
+	}
+
+	return st
+}
+
+func (d *cdrDedup) restore(st dedupState) {
+	for _, s := range st.Seen {
+		d.seen[s.Key.key()] = dedupPos{file: s.File, line: s.Line}
+	}
+
+	// окно зависит от опций расчёта, при возобновлении они те же
+	if d.ring != nil && len(st.Ring) == len(d.ring) {
+		for i, k := range st.Ring {
+			d.ring[i] = k.key()
+		}
+
+		d.next = st.Next
+	}
+
+	for _, r := range st.Pending {
+		row := &dedupRow{pos: dedupPos{file: r.File, line: r.Line}, file: r.FileIdx, cdr: r.CDR, bytes: r.Bytes}
+		if row.cdr.CallID != "" {
+			d.held[d.key(&row.cdr)] = row
+		}
+
+		d.pending = append(d.pending, row)
+	}
+
+	d.stats = st.Stats
+}
+
+func (k dedupKey) state() dedupKeyState {
+	return dedupKeyState{CallID: k.callID, Start: k.start, Calling: k.calling}
+}
+
+func (k dedupKeyState) key() dedupKey {
+	return dedupKey{callID: k.CallID, start: k.Start, calling: k.Calling}
+}
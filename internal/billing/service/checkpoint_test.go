@@ -0,0 +1,100 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+func TestResumedRunReportsLikeUninterrupted(t *testing.T) {
+	cdr := testCDR(3000, "78123260000", "78123260037")
+	sources := []model.CDRSource{{
+		Name: "cdr.txt",
+		Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(cdr)), nil },
+	}}
+
+	newService := func() *Service {
+		svc := newTestService(t, 4, creditSubscribers)
+
+		// предоплаты хватает на часть звонков: дальше баланс уходит в минус
+		if _, err := svc.TopUpBalance(context.Background(), "78123260037", 5000); err != nil {
+			t.Fatal(err)
+		}
+
+		return svc
+	}
+
+	opt := model.Options{CollectCalls: true}
+
+	want, err := newService().TariffCDRSources(context.Background(), sources, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// первый запуск обрывается посреди файла; чекпоинты сохраняются, как это делает обработчик задач
+	var (
+		last   *model.Checkpoint
+		chunks [][]byte
+	)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	killed := errors.New("killed")
+
+	interrupted := opt
+	interrupted.CheckpointEvery = time.Nanosecond
+	interrupted.OnCheckpoint = func(cp model.Checkpoint) error {
+		if cp.Calls != nil {
+			chunks = append(chunks, cp.Calls)
+		}
+
+		last = &cp
+		if cp.Line >= 1000 {
+			cancel(killed)
+		}
+
+		return nil
+	}
+
+	if _, err := newService().TariffCDRSources(ctx, sources, interrupted); !errors.Is(err, killed) {
+		t.Fatalf("interrupted run: err = %v, want %v", err, killed)
+	}
+
+	if last == nil || last.Line >= 3000 {
+		t.Fatalf("no checkpoint in the middle of the file: %+v", last)
+	}
+
+	resumed := opt
+	resumed.Resume = last
+	resumed.Resume.CallChunks = chunks
+
+	got, err := newService().TariffCDRSources(context.Background(), sources, resumed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(want.CreditEvents) == 0 || len(want.Charges) == 0 {
+		t.Fatalf("test data produce no credit events or charges: %+v", want)
+	}
+
+	// сервисы загружали тарифы каждый в своё время
+	got.Tariffs.LoadedAt = want.Tariffs.LoadedAt
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed report differs:\n got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"sort"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// creditThresholds are percentages of a credit limit that raise an event.
+var creditThresholds = [...]int{50, 80, 100}
+
+// CreditAlertSink receives credit limit threshold events of a run once it is committed
+// (Service.CommitRun), so failed or canceled runs send nothing. NotifyCredit must not block.
+type CreditAlertSink interface {
+	NotifyCredit(ev model.CreditEvent)
+}
+
+// SetCreditAlertSink installs a sink for credit threshold events (nil disables delivery).
+// Events are still included into the report regardless of the sink.
+func (s *Service) SetCreditAlertSink(sink CreditAlertSink) {
+	s.creditSink.Store(&sink)
+}
+
+func (s *Service) notifyCredit(events []model.CreditEvent) {
+	p := s.creditSink.Load()
+	if p == nil || *p == nil {
+		return
+	}
+
+	for _, ev := range events {
+		(*p).NotifyCredit(ev)
+	}
+}
+
+// crossedThresholds returns thresholds passed when a total grows from prev to cur.
+func crossedThresholds(prev, cur, limit model.Money) []int {
+	if limit <= 0 || cur <= prev {
+		return nil
+	}
+
+	var out []int
+
+	for _, pct := range creditThresholds {
+		bound := int64(limit) * int64(pct)
+		if int64(prev)*100 < bound && int64(cur)*100 >= bound {
+			out = append(out, pct)
+		}
+	}
+
+	return out
+}
+
+func sortCreditEvents(events []model.CreditEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].PhoneNumber != events[j].PhoneNumber {
+			return events[i].PhoneNumber < events[j].PhoneNumber
+		}
+
+		return events[i].ThresholdPct < events[j].ThresholdPct
+	})
+}
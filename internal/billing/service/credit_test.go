@@ -0,0 +1,153 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+	"ukrainian_call_center_scam_goev/internal/billing/repo/memory"
+	"ukrainian_call_center_scam_goev/internal/config"
+)
+
+// recordingSink keeps delivered credit events.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []model.CreditEvent
+}
+
+func (s *recordingSink) NotifyCredit(ev model.CreditEvent) {
+	s.mu.Lock()
+	s.events = append(s.events, ev)
+	s.mu.Unlock()
+}
+
+func (s *recordingSink) delivered() []model.CreditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]model.CreditEvent(nil), s.events...)
+}
+
+func newTestService(t *testing.T, workers int, subscribers string) *Service {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.CDRWorkers = workers
+
+	svc := New(
+		memory.NewTariffMemoryRepo(),
+		memory.NewSubscriberMemoryRepo(),
+		memory.NewAttributionMemoryRepo(),
+		memory.NewBalanceMemoryRepo(),
+		cfg,
+	)
+	t.Cleanup(svc.Close)
+
+	tariffs, err := os.Open("../../../example/tariffs.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tariffs.Close()
+
+	ctx := context.Background()
+	if err := svc.LoadTariffs(ctx, tariffs); err != nil {
+		t.Fatalf("load tariffs: %v", err)
+	}
+
+	if err := svc.LoadSubscribers(ctx, strings.NewReader(subscribers)); err != nil {
+		t.Fatalf("load subscribers: %v", err)
+	}
+
+	return svc
+}
+
+// testCDR builds n calls of the given subscribers in turn, each a bit longer than the previous one.
+func testCDR(n int, phones ...string) string {
+	var sb strings.Builder
+
+	for i := range n {
+		sec := 30 + i%300
+		fmt.Fprintf(&sb, "2026-02-03 10:%02d:%02d|2026-02-03 11:%02d:%02d|%s|+79162914177|outgoing|answered|%d|%d|0.00||call_%06d|Local\n",
+			i/60%60, i%60, i/60%60, i%60, phones[i%len(phones)], sec, sec, i)
+	}
+
+	return sb.String()
+}
+
+const creditSubscribers = "phone_number;client_name;credit_limit\n" +
+	"78123260000;Office Billing;500.00\n" +
+	"78123260037;Иванов Иван Иванович;300.00\n"
+
+func TestCreditEventsFollowInputOrder(t *testing.T) {
+	cdr := testCDR(5000, "78123260000", "78123260037")
+
+	run := func(workers int) []model.CreditEvent {
+		svc := newTestService(t, workers, creditSubscribers)
+
+		report, err := svc.TariffCDRStream(context.Background(), strings.NewReader(cdr), model.Options{})
+		if err != nil {
+			t.Fatalf("rate with %d workers: %v", workers, err)
+		}
+
+		return report.CreditEvents
+	}
+
+	want := run(1)
+	if len(want) != 6 {
+		t.Fatalf("got %d credit events, want 6 (3 thresholds of 2 subscribers): %+v", len(want), want)
+	}
+
+	// с несколькими воркерами порог приписывается тому же звонку, что и при последовательном расчёте
+	for range 5 {
+		if got := run(8); !reflect.DeepEqual(got, want) {
+			t.Fatalf("events with 8 workers differ:\n got %+v\nwant %+v", got, want)
+		}
+	}
+}
+
+func TestCreditEventsDeliveredOnCommit(t *testing.T) {
+	svc := newTestService(t, 4, creditSubscribers)
+
+	sink := &recordingSink{}
+	svc.SetCreditAlertSink(sink)
+
+	ctx := context.Background()
+	cdr := testCDR(5000, "78123260000", "78123260037")
+
+	// упавший расчёт ничего не отправляет
+	_, err := svc.TariffCDRStream(ctx, strings.NewReader(cdr+"broken row\n"), model.Options{})
+	if err == nil {
+		t.Fatal("run with a broken row succeeded")
+	}
+
+	report, err := svc.TariffCDRStream(ctx, strings.NewReader(cdr), model.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sink.delivered(); len(got) != 0 {
+		t.Fatalf("%d events delivered before commit", len(got))
+	}
+
+	if err := svc.CommitRun(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sink.delivered(); !reflect.DeepEqual(got, report.CreditEvents) {
+		t.Fatalf("delivered %+v, want report events %+v", got, report.CreditEvents)
+	}
+}
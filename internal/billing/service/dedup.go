@@ -0,0 +1,188 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"fmt"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+type dedupKey struct {
+	callID  string
+	start   int64
+	calling string
+}
+
+// dedupPos is a row position: source file and line number.
+type dedupPos struct {
+	file string
+	line int64
+}
+
+// dedupRow is a parsed row waiting for the dedup decision.
+type dedupRow struct {
+	pos   dedupPos
+	file  int // index of the source in the run
+	cdr   model.CDRRecord
+	bytes int64
+}
+
+// cdrDedup drops rows re-sent by switches. It is used only by the reading goroutine.
+//
+// first_wins/reject remember keys of rated rows. last_wins can't un-rate a call that is already
+// accounted (balances are charged once per call_id), so it holds rows back until no newer
+// duplicate can arrive: until the window is full or until the end of the stream.
+type cdrDedup struct {
+	cfg     model.DedupConfig
+	maxRows int
+
+	seen map[dedupKey]dedupPos // key -> position of the kept row
+	ring []dedupKey            // bounded mode: keys in arrival order
+	next int
+
+	held    map[dedupKey]*dedupRow // last_wins: rows not yet rated
+	pending []*dedupRow
+
+	stats model.DuplicateStats
+}
+
+func newCDRDedup(cfg model.DedupConfig, maxRows int) *cdrDedup {
+	d := &cdrDedup{
+		cfg:     cfg,
+		maxRows: maxRows,
+		seen:    make(map[dedupKey]dedupPos),
+		held:    make(map[dedupKey]*dedupRow),
+		stats:   model.DuplicateStats{Mode: cfg.Mode},
+	}
+	if cfg.Window > 0 && cfg.Mode != model.DedupLastWins {
+		d.ring = make([]dedupKey, cfg.Window)
+	}
+
+	return d
+}
+
+func (d *cdrDedup) key(cdr *model.CDRRecord) dedupKey {
+	k := dedupKey{callID: cdr.CallID}
+	if d.cfg.Key == model.DedupByFull {
+		k.start = cdr.StartTime.Unix()
+		k.calling = cdr.CallingParty
+	}
+
+	return k
+}
+
+// admit returns rows ready for rating (in order) and rows dropped as duplicates
+// (their bytes must still be reported as processed). For reject mode a duplicate is returned as an error.
+func (d *cdrDedup) admit(row dedupRow) (ready []dedupRow, dropped int64, err error) {
+	switch {
+	case d.cfg.Mode == model.DedupLastWins:
+		return d.admitLast(row)
+	case d.cfg.Mode == model.DedupOff || row.cdr.CallID == "":
+		// строки без call_id сравнивать не с чем
+		return []dedupRow{row}, 0, nil
+	}
+
+	k := d.key(&row.cdr)
+
+	if kept, ok := d.seen[k]; ok {
+		d.record(row.cdr.CallID, kept, row.pos)
+
+		if d.cfg.Mode == model.DedupReject {
+			return nil, 0, fmt.Errorf("duplicate call_id %q (first seen at %s)", row.cdr.CallID, kept)
+		}
+
+		return nil, row.bytes, nil
+	}
+
+	if d.ring != nil {
+		if old := d.ring[d.next]; old != (dedupKey{}) {
+			delete(d.seen, old)
+		}
+
+		d.ring[d.next] = k
+		d.next = (d.next + 1) % len(d.ring)
+	}
+
+	d.seen[k] = row.pos
+
+	return []dedupRow{row}, 0, nil
+}
+
+func (d *cdrDedup) admitLast(row dedupRow) ([]dedupRow, int64, error) {
+	k := d.key(&row.cdr)
+	if prev, ok := d.held[k]; ok && row.cdr.CallID != "" {
+		d.record(row.cdr.CallID, row.pos, prev.pos)
+
+		dropped := prev.bytes
+		*prev = row // новая версия занимает место старой, порядок звонков не меняется
+
+		return nil, dropped, nil
+	}
+
+	// строки без call_id тоже проходят через очередь, чтобы не обогнать удерживаемые
+	r := row
+	if row.cdr.CallID != "" {
+		d.held[k] = &r
+	}
+
+	d.pending = append(d.pending, &r)
+
+	if d.cfg.Window <= 0 || len(d.pending) <= d.cfg.Window {
+		return nil, 0, nil
+	}
+
+	oldest := d.pending[0]
+	d.pending[0] = nil
+	d.pending = d.pending[1:]
+
+	if oldest.cdr.CallID != "" {
+		delete(d.held, d.key(&oldest.cdr))
+	}
+
+	return []dedupRow{*oldest}, 0, nil
+}
+
+// flush returns rows still held by last_wins at the end of the stream.
+func (d *cdrDedup) flush() []dedupRow {
+	out := make([]dedupRow, 0, len(d.pending))
+	for _, r := range d.pending {
+		out = append(out, *r)
+	}
+
+	d.pending = nil
+	clear(d.held)
+
+	return out
+}
+
+func (d *cdrDedup) record(callID string, kept, dropped dedupPos) {
+	d.stats.Count++
+	if len(d.stats.Rows) >= d.maxRows {
+		d.stats.Truncated = true
+		return
+	}
+
+	d.stats.Rows = append(d.stats.Rows, model.DuplicateRow{
+		CallID:      callID,
+		KeptFile:    kept.file,
+		KeptLine:    kept.line,
+		DroppedFile: dropped.file,
+		DroppedLine: dropped.line,
+	})
+}
+
+func (p dedupPos) String() string {
+	if p.file == "" {
+		return fmt.Sprintf("line %d", p.line)
+	}
+
+	return fmt.Sprintf("%s line %d", p.file, p.line)
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"reflect"
+	"testing"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// dedupRun feeds rows with the given call_ids (line i+1, one byte each) through cdrDedup and
+// returns the lines handed to rating, in order, and the bytes of dropped rows.
+func dedupRun(d *cdrDedup, callIDs []string) (lines []int64, dropped int64, err error) {
+	for i, id := range callIDs {
+		ready, n, err := d.admit(dedupRow{
+			pos:   dedupPos{line: int64(i + 1)},
+			cdr:   model.CDRRecord{CallID: id},
+			bytes: 1,
+		})
+		if err != nil {
+			return lines, dropped, err
+		}
+
+		dropped += n
+
+		for _, r := range ready {
+			lines = append(lines, r.pos.line)
+		}
+	}
+
+	for _, r := range d.flush() {
+		lines = append(lines, r.pos.line)
+	}
+
+	return lines, dropped, nil
+}
+
+func TestCDRDedup(t *testing.T) {
+	// dupRows: пары (оставленная строка, отброшенная строка)
+	tests := []struct {
+		name    string
+		cfg     model.DedupConfig
+		callIDs []string
+		want    []int64
+		dupRows [][2]int64
+		wantErr bool
+	}{
+		{
+			name:    "off keeps every row",
+			cfg:     model.DedupConfig{Mode: model.DedupOff},
+			callIDs: []string{"a", "a", "b"},
+			want:    []int64{1, 2, 3},
+		},
+		{
+			name:    "first_wins drops later rows",
+			cfg:     model.DedupConfig{Mode: model.DedupFirstWins},
+			callIDs: []string{"a", "b", "a", "c", "b"},
+			want:    []int64{1, 2, 4},
+			dupRows: [][2]int64{{1, 3}, {2, 5}},
+		},
+		{
+			name:    "first_wins catches a repeat inside the window",
+			cfg:     model.DedupConfig{Mode: model.DedupFirstWins, Window: 2},
+			callIDs: []string{"a", "b", "b"},
+			want:    []int64{1, 2},
+			dupRows: [][2]int64{{2, 3}},
+		},
+		{
+			name:    "first_wins forgets keys evicted from the ring",
+			cfg:     model.DedupConfig{Mode: model.DedupFirstWins, Window: 2},
+			callIDs: []string{"a", "b", "c", "a", "c"},
+			want:    []int64{1, 2, 3, 4},
+			dupRows: [][2]int64{{3, 5}},
+		},
+		{
+			name:    "first_wins passes rows without call_id",
+			cfg:     model.DedupConfig{Mode: model.DedupFirstWins},
+			callIDs: []string{"", "a", "", "a"},
+			want:    []int64{1, 2, 3},
+			dupRows: [][2]int64{{2, 4}},
+		},
+		{
+			name:    "last_wins replaces the held row in place",
+			cfg:     model.DedupConfig{Mode: model.DedupLastWins},
+			callIDs: []string{"a", "b", "a", "a"},
+			want:    []int64{4, 2},
+			dupRows: [][2]int64{{3, 1}, {4, 3}},
+		},
+		{
+			name:    "last_wins keeps rows without call_id in their place",
+			cfg:     model.DedupConfig{Mode: model.DedupLastWins},
+			callIDs: []string{"a", "", "a", ""},
+			want:    []int64{3, 2, 4},
+			dupRows: [][2]int64{{3, 1}},
+		},
+		{
+			name:    "last_wins releases the oldest row when the window is full",
+			cfg:     model.DedupConfig{Mode: model.DedupLastWins, Window: 2},
+			callIDs: []string{"a", "b", "a", "c"},
+			want:    []int64{3, 2, 4},
+			dupRows: [][2]int64{{3, 1}},
+		},
+		{
+			name:    "last_wins rates a repeat after its row left the window",
+			cfg:     model.DedupConfig{Mode: model.DedupLastWins, Window: 2},
+			callIDs: []string{"a", "b", "c", "a"},
+			want:    []int64{1, 2, 3, 4},
+		},
+		{
+			name:    "reject fails on a repeat",
+			cfg:     model.DedupConfig{Mode: model.DedupReject},
+			callIDs: []string{"a", "b", "a"},
+			want:    []int64{1, 2},
+			dupRows: [][2]int64{{1, 3}},
+			wantErr: true,
+		},
+		{
+			name:    "reject passes rows without call_id",
+			cfg:     model.DedupConfig{Mode: model.DedupReject},
+			callIDs: []string{"", ""},
+			want:    []int64{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newCDRDedup(tt.cfg, 100)
+
+			got, dropped, err := dedupRun(d, tt.callIDs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rated lines = %v, want %v", got, tt.want)
+			}
+
+			var dups [][2]int64
+			for _, r := range d.stats.Rows {
+				dups = append(dups, [2]int64{r.KeptLine, r.DroppedLine})
+			}
+
+			if !reflect.DeepEqual(dups, tt.dupRows) {
+				t.Errorf("duplicate rows = %v, want %v", dups, tt.dupRows)
+			}
+
+			// reject возвращает повтор ошибкой, его байты учитывает тот, кто читает файл
+			if wantDropped := int64(len(tt.dupRows)); !tt.wantErr && dropped != wantDropped {
+				t.Errorf("dropped bytes = %d, want %d", dropped, wantDropped)
+			}
+		})
+	}
+}
@@ -12,6 +12,8 @@ package billing
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"strconv"
@@ -22,24 +24,39 @@ import (
 )
 
 const (
-	tariffsHeader     = "prefix;destination;rate_per_min;connection_fee;timeband;weekday;priority;effective_date;expiry_date" //nolint:lll
+	tariffsHeader = "prefix;destination;rate_per_min;connection_fee;timeband;weekday;priority;effective_date;expiry_date" //nolint:lll
+	// tariffsZoneHeader is the extended header with the zone timebands are evaluated in.
+	tariffsZoneHeader = tariffsHeader + ";timezone"
 	subscribersHeader = "phone_number;client_name"
+	// subscribersCreditHeader is the extended header with an optional postpaid credit limit column.
+	subscribersCreditHeader = "phone_number;client_name;credit_limit"
+	attributionHeader       = "source;key;phone_number"
 )
 
 func (s *Service) LoadTariffs(ctx context.Context, r io.Reader) error {
-	sc := bufio.NewScanner(r)
+	hash := sha256.New()
+	sc := bufio.NewScanner(io.TeeReader(r, hash))
 	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 	rules := make([]model.TariffRule, 0)
 
 	sc.Scan()
 
-	if sc.Text() != tariffsHeader {
-		return fmt.Errorf("expected tariffs header: %q, actual: %q", tariffsHeader, sc.Text())
+	withZone := sc.Text() == tariffsZoneHeader
+	if sc.Text() != tariffsHeader && !withZone {
+		return fmt.Errorf("expected tariffs header: %q or %q, actual: %q", tariffsHeader, tariffsZoneHeader, sc.Text())
 	}
 
 	for sc.Scan() {
 		fields := strings.Split(sc.Text(), ";")
 
+		loc := s.loc
+		if withZone && len(fields) > 9 && strings.TrimSpace(fields[9]) != "" {
+			var err error
+			if loc, err = LoadLocation(fields[9]); err != nil {
+				return fmt.Errorf("tariffs: bad timezone: %w", err)
+			}
+		}
+
 		rate, err := model.ParseMoney(fields[2])
 		if err != nil {
 			return fmt.Errorf("tariffs: bad rate_per_min %q: %w", fields[2], err)
@@ -65,12 +82,12 @@ func (s *Service) LoadTariffs(ctx context.Context, r io.Reader) error {
 			return fmt.Errorf("tariffs: bad priority %q: %w", fields[6], err)
 		}
 
-		eff, err := time.ParseInLocation(dateLayout, fields[7], s.loc)
+		eff, err := time.ParseInLocation(dateLayout, fields[7], loc)
 		if err != nil {
 			return fmt.Errorf("tariffs: bad effective_date %q: %w", fields[7], err)
 		}
 
-		exp, err := time.ParseInLocation(dateLayout, fields[8], s.loc)
+		exp, err := time.ParseInLocation(dateLayout, fields[8], loc)
 		if err != nil {
 			return fmt.Errorf("tariffs: bad effective_date %q: %w", fields[8], err)
 		}
@@ -84,7 +101,8 @@ func (s *Service) LoadTariffs(ctx context.Context, r io.Reader) error {
 			WeekdayMask:     wd,
 			Priority:        priority,
 			EffectiveStart:  eff,
-			ExpiryExclusive: exp.Add(time.Hour * 24),
+			ExpiryExclusive: exp.AddDate(0, 0, 1), // не +24h: в день перехода на летнее время сутки короче
+			Location:        loc,
 		})
 	}
 
@@ -92,7 +110,53 @@ func (s *Service) LoadTariffs(ctx context.Context, r io.Reader) error {
 		return fmt.Errorf("read tariffs: %w", err)
 	}
 
-	return s.tariffs.ReplaceAll(ctx, rules)
+	s.tariffsMu.Lock()
+	defer s.tariffsMu.Unlock()
+
+	if err := s.tariffs.ReplaceAll(ctx, rules); err != nil {
+		return err
+	}
+
+	table, err := s.tariffs.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.tariffSet.Store(&tariffSet{
+		version: &model.TariffVersion{
+			SHA256:   hex.EncodeToString(hash.Sum(nil)),
+			Rules:    len(rules),
+			LoadedAt: time.Now(),
+		},
+		table: table,
+	})
+
+	return nil
+}
+
+// currentTariffs returns the tariff set runs started now rate against.
+func (s *Service) currentTariffs(ctx context.Context) (*tariffSet, error) {
+	if set := s.tariffSet.Load(); set != nil {
+		return set, nil
+	}
+
+	// тарифы ещё не загружены: пустая таблица репозитория
+	table, err := s.tariffs.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tariffSet{table: table}, nil
+}
+
+// TariffVersion describes the current tariff table; ok=false until tariffs are loaded.
+func (s *Service) TariffVersion() (model.TariffVersion, bool) {
+	set := s.tariffSet.Load()
+	if set == nil || set.version == nil {
+		return model.TariffVersion{}, false
+	}
+
+	return *set.version, true
 }
 
 func (s *Service) LoadSubscribers(ctx context.Context, r io.Reader) error {
@@ -102,13 +166,26 @@ func (s *Service) LoadSubscribers(ctx context.Context, r io.Reader) error {
 
 	sc.Scan()
 
-	if sc.Text() != subscribersHeader {
-		return fmt.Errorf("expected tariffs header: %q, actual: %q", subscribersHeader, sc.Text())
+	withCredit := sc.Text() == subscribersCreditHeader
+	if sc.Text() != subscribersHeader && !withCredit {
+		return fmt.Errorf("expected subscribers header: %q or %q, actual: %q",
+			subscribersHeader, subscribersCreditHeader, sc.Text())
 	}
 
 	for sc.Scan() {
 		fields := strings.Split(sc.Text(), ";")
-		subs = append(subs, model.Subscriber{PhoneNumber: fields[0], ClientName: fields[1]})
+		sub := model.Subscriber{PhoneNumber: fields[0], ClientName: fields[1]}
+
+		if withCredit && len(fields) > 2 {
+			limit, err := model.ParseMoney(fields[2])
+			if err != nil {
+				return fmt.Errorf("subscribers: bad credit_limit %q: %w", fields[2], err)
+			}
+
+			sub.CreditLimit = limit
+		}
+
+		subs = append(subs, sub)
 	}
 
 	if err := sc.Err(); err != nil {
@@ -117,3 +194,39 @@ func (s *Service) LoadSubscribers(ctx context.Context, r io.Reader) error {
 
 	return s.subs.ReplaceAll(ctx, subs)
 }
+
+func (s *Service) LoadAttribution(ctx context.Context, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	rules := make([]model.AttributionRule, 0)
+
+	sc.Scan()
+
+	if sc.Text() != attributionHeader {
+		return fmt.Errorf("expected attribution header: %q, actual: %q", attributionHeader, sc.Text())
+	}
+
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), ";")
+		if len(fields) < 3 {
+			return fmt.Errorf("attribution: expected 3 fields, got %d", len(fields))
+		}
+
+		src := model.ParseAttributionSource(fields[0])
+		if src != model.AttrAccountCode && src != model.AttrTrunk {
+			return fmt.Errorf("attribution: bad source %q (want account_code or trunk)", fields[0])
+		}
+
+		rules = append(rules, model.AttributionRule{
+			Source:      src,
+			Key:         strings.TrimSpace(fields[1]),
+			PhoneNumber: strings.TrimSpace(fields[2]),
+		})
+	}
+
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read attribution: %w", err)
+	}
+
+	return s.attribution.ReplaceAll(ctx, rules)
+}
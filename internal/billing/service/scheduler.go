@@ -0,0 +1,268 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// runQueueSize bounds rows of one run waiting for a worker. The reader of the run blocks
+// beyond it, so a huge upload holds only a small part of its file in memory.
+const runQueueSize = 256
+
+// priorityWeight is how many rows a run hands to the workers per round of the dispatcher.
+func priorityWeight(p model.Priority) int {
+	switch p {
+	case model.PriorityLow:
+		return 1
+	case model.PriorityHigh:
+		return 16
+	default:
+		return 4
+	}
+}
+
+// runQueue holds rows of one run until the dispatcher takes them.
+type runQueue struct {
+	weight int
+	rows   []cdrJob      // guarded by cdrScheduler.mu
+	space  chan struct{} // signalled when rows were taken
+}
+
+// cdrScheduler dispatches rows of concurrent runs to the shared worker pool by weighted round robin:
+// a 50M-row upload and a 100-row check alternate instead of the check waiting behind the whole upload.
+type cdrScheduler struct {
+	mu     sync.Mutex
+	active []*runQueue // runs with rows, in round order
+	pos    int         // run whose turn it is
+	credit int         // rows left in its turn
+
+	ready chan struct{} // signalled when rows were added
+}
+
+func newCDRScheduler() *cdrScheduler {
+	return &cdrScheduler{ready: make(chan struct{}, 1)}
+}
+
+func newRunQueue(p model.Priority) *runQueue {
+	return &runQueue{
+		weight: priorityWeight(p),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// push queues a row of run q, waiting while the run's queue is full.
+func (sc *cdrScheduler) push(ctx, stopCtx context.Context, q *runQueue, job cdrJob) error {
+	for {
+		sc.mu.Lock()
+		if len(q.rows) < runQueueSize {
+			q.rows = append(q.rows, job)
+			if len(q.rows) == 1 {
+				sc.active = append(sc.active, q)
+			}
+			sc.mu.Unlock()
+
+			signal(sc.ready)
+
+			return nil
+		}
+		sc.mu.Unlock()
+
+		select {
+		case <-q.space:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-stopCtx.Done():
+			return ErrStopped
+		}
+	}
+}
+
+// pop takes the next row by weighted round robin. Rows of canceled runs are dropped
+// here all at once: otherwise a canceled run would drain at its share of the workers.
+func (sc *cdrScheduler) pop(stopCtx context.Context) (cdrJob, bool) {
+	for {
+		sc.mu.Lock()
+
+		if len(sc.active) == 0 {
+			sc.mu.Unlock()
+
+			select {
+			case <-sc.ready:
+				continue
+			case <-stopCtx.Done():
+				return cdrJob{}, false
+			}
+		}
+
+		if sc.pos >= len(sc.active) {
+			sc.pos = 0
+		}
+
+		q := sc.active[sc.pos]
+		if sc.credit <= 0 {
+			sc.credit = q.weight
+		}
+
+		if q.rows[0].ctx.Err() != nil {
+			dropped := q.rows
+			q.rows = nil
+			sc.removeLocked()
+			sc.mu.Unlock()
+
+			signal(q.space)
+
+			for _, job := range dropped {
+				job.batch.finishOne()
+			}
+
+			continue
+		}
+
+		job := q.rows[0]
+		q.rows[0] = cdrJob{}
+		q.rows = q.rows[1:]
+		sc.credit--
+
+		switch {
+		case len(q.rows) == 0:
+			q.rows = nil
+			sc.removeLocked()
+		case sc.credit == 0:
+			sc.pos++
+		}
+
+		sc.mu.Unlock()
+
+		signal(q.space)
+
+		return job, true
+	}
+}
+
+// removeLocked takes the current run out of the round; the next run gets a full turn.
+func (sc *cdrScheduler) removeLocked() {
+	sc.active = slices.Delete(sc.active, sc.pos, sc.pos+1)
+	sc.credit = 0
+}
+
+// dispatch feeds the workers until the service stops.
+func (s *Service) dispatch() {
+	defer s.wg.Done()
+
+	for {
+		job, ok := s.sched.pop(s.stopCtx)
+		if !ok {
+			return
+		}
+
+		select {
+		case s.jobs <- job:
+		case <-s.stopCtx.Done():
+			return
+		}
+	}
+}
+
+// runAdmission limits the number of runs reading at the same time (config max_concurrent_runs).
+// Runs beyond the limit wait in priority order (FIFO within a class).
+type runAdmission struct {
+	mu      sync.Mutex
+	limit   int // 0 means unlimited
+	running int
+	waiting []*admitWaiter
+}
+
+type admitWaiter struct {
+	prio  model.Priority
+	ready chan struct{} // closed when the waiter got a slot
+}
+
+// acquire waits for a slot for a run.
+func (a *runAdmission) acquire(ctx, stopCtx context.Context, prio model.Priority) error {
+	a.mu.Lock()
+	if len(a.waiting) == 0 && (a.limit == 0 || a.running < a.limit) {
+		a.running++
+		a.mu.Unlock()
+
+		return nil
+	}
+
+	w := &admitWaiter{prio: prio, ready: make(chan struct{})}
+
+	// выше приоритет — ближе к началу, внутри класса по времени прихода
+	i := len(a.waiting)
+	for i > 0 && a.waiting[i-1].prio < prio {
+		i--
+	}
+
+	a.waiting = slices.Insert(a.waiting, i, w)
+	a.mu.Unlock()
+
+	var err error
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	case <-stopCtx.Done():
+		err = ErrStopped
+	}
+
+	a.mu.Lock()
+	if i := slices.Index(a.waiting, w); i >= 0 {
+		a.waiting = slices.Delete(a.waiting, i, i+1)
+		a.mu.Unlock()
+
+		return err
+	}
+	a.mu.Unlock()
+
+	// слот успели выдать одновременно с отменой
+	a.release()
+
+	return err
+}
+
+func (a *runAdmission) release() {
+	a.mu.Lock()
+	a.running--
+	grant := a.grantLocked()
+	a.mu.Unlock()
+
+	for _, w := range grant {
+		close(w.ready)
+	}
+}
+
+// grantLocked hands free slots to the first waiters.
+func (a *runAdmission) grantLocked() []*admitWaiter {
+	var grant []*admitWaiter
+
+	for len(a.waiting) > 0 && (a.limit == 0 || a.running < a.limit) {
+		grant = append(grant, a.waiting[0])
+		a.waiting = a.waiting[1:]
+		a.running++
+	}
+
+	return grant
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// pushRows queues n rows of a run copied from job; seq of the rows counts up from job.seq.
+func pushRows(t *testing.T, sc *cdrScheduler, q *runQueue, job cdrJob, n int) {
+	t.Helper()
+
+	for range n {
+		job.batch.incPending()
+
+		if err := sc.push(context.Background(), context.Background(), q, job); err != nil {
+			t.Fatal(err)
+		}
+
+		job.seq++
+	}
+}
+
+func TestSchedulerGivesRunsRowsByPriority(t *testing.T) {
+	sc := newCDRScheduler()
+	ctx := context.Background()
+
+	high, normal := newRunQueue(model.PriorityHigh), newRunQueue(model.PriorityNormal)
+	pushRows(t, sc, high, cdrJob{ctx: ctx, batch: newCDRBatch(false)}, 40)
+	pushRows(t, sc, normal, cdrJob{ctx: ctx, batch: newCDRBatch(false), seq: 1000}, 40)
+
+	var got strings.Builder
+
+	for range 40 {
+		job, ok := sc.pop(ctx)
+		if !ok {
+			t.Fatal("pop: scheduler stopped")
+		}
+
+		if job.seq < 1000 {
+			got.WriteByte('H')
+		} else {
+			got.WriteByte('N')
+		}
+	}
+
+	// за круг срочный расчёт отдаёт 16 строк, обычный — 4
+	round := strings.Repeat("H", 16) + strings.Repeat("N", 4)
+	if want := round + round; got.String() != want {
+		t.Errorf("rows taken in order\n%s\nwant\n%s", got.String(), want)
+	}
+}
+
+func TestSchedulerDropsRowsOfCanceledRun(t *testing.T) {
+	sc := newCDRScheduler()
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	canceled := newCDRBatch(false)
+	pushRows(t, sc, newRunQueue(model.PriorityNormal), cdrJob{ctx: canceledCtx, batch: canceled}, 100)
+
+	live := cdrJob{ctx: context.Background(), batch: newCDRBatch(false), seq: 1000}
+	pushRows(t, sc, newRunQueue(model.PriorityNormal), live, 10)
+
+	cancel()
+
+	var seqs []uint64
+
+	for range 10 {
+		job, ok := sc.pop(context.Background())
+		if !ok {
+			t.Fatal("pop: scheduler stopped")
+		}
+
+		seqs = append(seqs, job.seq)
+	}
+
+	if i := slices.IndexFunc(seqs, func(seq uint64) bool { return seq < 1000 }); i >= 0 {
+		t.Errorf("row %d of the canceled run was dispatched: %v", seqs[i], seqs)
+	}
+
+	// отброшенные строки засчитаны как завершённые: читатель отменённого расчёта не ждёт их
+	select {
+	case <-canceled.idle():
+	case <-time.After(time.Second):
+		t.Fatal("rows of the canceled run are still pending")
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if len(sc.active) != 0 {
+		t.Errorf("%d runs left in the round, want none", len(sc.active))
+	}
+}
+
+func TestAdmissionGrantedWhileCanceledReturnsSlot(t *testing.T) {
+	a := &runAdmission{limit: 1}
+
+	if err := a.acquire(context.Background(), context.Background(), model.PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	canceled := errors.New("canceled")
+
+	done := make(chan error, 1)
+
+	go func() { done <- a.acquire(ctx, context.Background(), model.PriorityNormal) }()
+
+	waitFor(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		return len(a.waiting) == 1
+	})
+
+	// отмена и освобождение слота совпадают: ожидающий уже ушёл по отмене, а release успевает
+	// отдать ему слот, пока тот ждёт блокировку
+	a.mu.Lock()
+	cancel(canceled)
+	time.Sleep(20 * time.Millisecond)
+
+	a.running--
+	grant := a.grantLocked()
+	a.mu.Unlock()
+
+	for _, w := range grant {
+		close(w.ready)
+	}
+
+	err := <-done
+
+	a.mu.Lock()
+	running := a.running
+	a.mu.Unlock()
+
+	switch {
+	case err == nil && running != 1:
+		t.Errorf("acquire succeeded, running = %d, want 1", running)
+	case err != nil && running != 0:
+		t.Errorf("acquire failed with %v, running = %d, want the granted slot back (0)", err, running)
+	case err != nil && !errors.Is(err, canceled):
+		t.Errorf("acquire err = %v, want %v", err, canceled)
+	}
+
+	if err == nil {
+		a.release()
+	}
+
+	// свободный слот сразу достаётся следующему расчёту
+	if err := a.acquire(context.Background(), context.Background(), model.PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor polls cond until it holds (or fails the test after a second).
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached in time")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
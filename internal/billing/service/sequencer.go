@@ -0,0 +1,73 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"sync"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// ratedRow is a row rated by a worker. Rating doesn't depend on other rows, accounting does
+// (prepaid balances, credit thresholds, streamed calls), so it happens later, in input order.
+type ratedRow struct {
+	sub  model.Subscriber
+	cdr  model.CDRRecord
+	cost model.Money
+	best *model.TariffRule
+	file int
+}
+
+// rowSequencer accounts rated rows of a run in input order. Workers finish rows out of order,
+// so a finished row waits in the reorder buffer until every earlier row is finished too; the worker
+// that closes the gap accounts the waiting rows. The buffer holds at most the rows in flight
+// (run queue + worker queue + workers).
+type rowSequencer struct {
+	mu      sync.Mutex
+	next    uint64               // seq of the row the sequencer waits for
+	held    map[uint64]*ratedRow // finished rows after a gap; nil value: row without anything to account
+	account func(seq uint64, row *ratedRow) error
+	err     error
+}
+
+func newRowSequencer(account func(seq uint64, row *ratedRow) error) *rowSequencer {
+	return &rowSequencer{held: make(map[uint64]*ratedRow, 64), account: account}
+}
+
+// done reports that row seq is finished; row is nil if there is nothing to account
+// (skipped unknown subscriber, failed, canceled). The first accounting error sticks.
+func (q *rowSequencer) done(seq uint64, row *ratedRow) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.err != nil {
+		return q.err
+	}
+
+	if seq != q.next {
+		q.held[seq] = row
+		return nil
+	}
+
+	for {
+		if q.err = q.account(q.next, row); q.err != nil {
+			return q.err
+		}
+
+		q.next++
+
+		var ok bool
+		if row, ok = q.held[q.next]; !ok {
+			return nil
+		}
+
+		delete(q.held, q.next)
+	}
+}
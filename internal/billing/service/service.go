@@ -11,34 +11,44 @@ package billing
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"ukrainian_call_center_scam_goev/internal/billing/cdrformat"
 	"ukrainian_call_center_scam_goev/internal/billing/model"
 	"ukrainian_call_center_scam_goev/internal/billing/repo"
+	"ukrainian_call_center_scam_goev/internal/config"
 )
 
-const (
-	cdrLayout  = "2006-01-02 15:04:05"
-	dateLayout = "2006-01-02"
-)
+const dateLayout = "2006-01-02"
 
-type Config struct {
-	Location     *time.Location
-	CDRWorkers   int
-	CDRQueueSize int
-}
+// ErrStopped is returned by runs interrupted by Close.
+var ErrStopped = errors.New("billing service stopped")
 
 type Service struct {
-	tariffs repo.TariffRepository
-	subs    repo.SubscriberRepository
-	loc     *time.Location
+	tariffs     repo.TariffRepository
+	subs        repo.SubscriberRepository
+	attribution repo.AttributionRepository
+	balances    repo.BalanceRepository
+	loc         *time.Location
+	formats     *cdrformat.Registry
 
 	cdrWorkers int
 
-	jobs chan cdrJob
+	creditSink atomic.Pointer[CreditAlertSink]
+	trunkZones atomic.Pointer[trunkZones]
+
+	// tariffsMu serializes tariff loads: the table and its version are published together.
+	tariffsMu sync.Mutex
+	tariffSet atomic.Pointer[tariffSet]
+
+	// rows go reader -> sched (a queue per run) -> dispatch -> jobs -> workers
+	jobs      chan cdrJob
+	sched     *cdrScheduler
+	admission runAdmission
 
 	closeOnce sync.Once
 	closed    atomic.Bool
@@ -51,23 +61,39 @@ type Service struct {
 func New(
 	tariffs repo.TariffRepository,
 	subs repo.SubscriberRepository,
-	location *time.Location,
-	cdrWorkers int,
+	attribution repo.AttributionRepository,
+	balances repo.BalanceRepository,
+	cfg config.Config,
 ) *Service {
 	s := &Service{
-		tariffs:    tariffs,
-		subs:       subs,
-		loc:        location,
-		cdrWorkers: cdrWorkers,
+		tariffs:     tariffs,
+		subs:        subs,
+		attribution: attribution,
+		balances:    balances,
+		loc:         cfg.Location,
+		formats:     cdrformat.NewRegistry(),
+		cdrWorkers:  max(cfg.CDRWorkers, 1),
 	}
 
-	s.jobs = make(chan cdrJob)
+	if s.loc == nil {
+		s.loc = time.UTC
+	}
+
+	// небольшой буфер сглаживает передачу строк воркерам; очередь каждого расчёта — в планировщике
+	s.jobs = make(chan cdrJob, max(cfg.CDRQueueSize, 0))
+	s.sched = newCDRScheduler()
+	s.admission.limit = max(cfg.MaxConcurrentRuns, 0)
 	s.stopCtx, s.stopCancel = context.WithCancel(context.Background())
 	s.startWorkers()
 
 	return s
 }
 
+// Formats returns the registry of CDR input formats.
+func (s *Service) Formats() *cdrformat.Registry {
+	return s.formats
+}
+
 func (s *Service) Close() {
 	s.closeOnce.Do(func() {
 		s.closed.Store(true)
@@ -85,7 +111,9 @@ func (s *Service) ensureOpen() error {
 }
 
 func (s *Service) startWorkers() {
-	s.wg.Add(s.cdrWorkers)
+	s.wg.Add(s.cdrWorkers + 1)
+
+	go s.dispatch()
 
 	for range s.cdrWorkers {
 		go s.cdrWorker()
@@ -100,41 +128,83 @@ func (s *Service) cdrWorker() {
 		case <-s.stopCtx.Done():
 			return
 		case job := <-s.jobs:
-			b := job.batch
-			if job.ctx.Err() != nil {
-				b.finishOne()
-				continue
-			}
-
-			subPhone := job.cdr.CallingParty
-
-			sub, ok, err := s.subs.GetByPhone(job.ctx, subPhone)
-			if err != nil {
-				b.setErr(err)
-				b.finishOne()
-
-				continue
-			}
-
-			if !ok {
-				sub = model.Subscriber{PhoneNumber: subPhone}
-			}
-
-			var (
-				best *model.TariffRule
-				cost model.Money
-			)
-
-			if job.cdr.Direction == model.DirOutgoing {
-				best = s.matchBestTariff(job.ctx, job.cdr.CalledParty, job.cdr.StartTime)
-				cost = calcCost(job.cdr, best)
-			}
-
-			b.add(sub, job.cdr, cost, best, job.seq)
-			if b.onProcessedBytes != nil && job.bytes > 0 {
-				b.onProcessedBytes(job.bytes)
-			}
-			b.finishOne()
+			job.batch.finishRow(job.seq, s.rateJob(job))
+			job.batch.processedRows(1, 0)
+			job.batch.finishOne()
 		}
 	}
 }
+
+// rateJob rates a single CDR row; nil means there is nothing to account.
+func (s *Service) rateJob(job cdrJob) *ratedRow {
+	b := job.batch
+	if job.ctx.Err() != nil {
+		return nil
+	}
+
+	subPhone, err := s.resolveSubscriberPhone(job.ctx, b.attribution, job.cdr)
+	if err != nil {
+		b.setErr(err)
+		return nil
+	}
+
+	sub, ok, err := s.subs.GetByPhone(job.ctx, subPhone)
+	if err != nil {
+		b.setErr(err)
+		return nil
+	}
+
+	var (
+		best *model.TariffRule
+		cost model.Money
+	)
+
+	if job.cdr.Direction == model.DirOutgoing {
+		best = matchBestTariff(b.tariffs, job.cdr.CalledParty, job.cdr.StartTime)
+		cost = calcCost(job.cdr, best)
+	}
+
+	if !ok {
+		sub = model.Subscriber{PhoneNumber: subPhone}
+		b.addUnknown(subPhone, cost)
+
+		switch b.unknownPolicy {
+		case model.UnknownSkip:
+			b.processed(job.bytes)
+			return nil
+		case model.UnknownFail:
+			b.setErr(fmt.Errorf("cdr: unknown subscriber %q (call_id %q)", subPhone, job.cdr.CallID))
+			return nil
+		}
+	}
+
+	b.processed(job.bytes)
+
+	return &ratedRow{sub: sub, cdr: job.cdr, cost: cost, best: best, file: job.file}
+}
+
+// accountRow adds a rated row to the run. Rows come here in input order (rowSequencer).
+func (s *Service) accountRow(ctx context.Context, b *cdrBatch, seq uint64, row *ratedRow) error {
+	call, err := s.accountCall(ctx, b, seq, row)
+
+	// слот строки в потоке звонков освобождается и тогда, когда звонка нет
+	if b.stream != nil {
+		b.stream.pass(call)
+	}
+
+	return err
+}
+
+// accountCall charges and adds a rated row; nil if there is no call to report.
+func (s *Service) accountCall(ctx context.Context, b *cdrBatch, seq uint64, row *ratedRow) (*model.RatedCall, error) {
+	if row == nil {
+		return nil, nil
+	}
+
+	accounted, err := b.ledger.charge(ctx, row.sub.PhoneNumber, row.cdr.CallID, row.cost)
+	if err != nil || !accounted {
+		return nil, err
+	}
+
+	return b.add(row.sub, row.cdr, row.cost, row.best, seq, row.file), nil
+}
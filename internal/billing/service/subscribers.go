@@ -0,0 +1,112 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+	"ukrainian_call_center_scam_goev/internal/billing/repo"
+)
+
+const maxSubscribersPage = 1000
+
+// ErrInvalidArgument marks validation errors of the service API.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+func (s *Service) GetSubscriber(ctx context.Context, phone string) (model.Subscriber, error) {
+	sub, ok, err := s.subs.GetByPhone(ctx, strings.TrimSpace(phone))
+	if err != nil {
+		return model.Subscriber{}, err
+	}
+
+	if !ok {
+		return model.Subscriber{}, fmt.Errorf("subscriber %q: %w", phone, repo.ErrNotFound)
+	}
+
+	return sub, nil
+}
+
+func (s *Service) CreateSubscriber(ctx context.Context, sub model.Subscriber) error {
+	sub, err := normalizeSubscriber(sub)
+	if err != nil {
+		return err
+	}
+
+	if err := s.subs.Create(ctx, sub); err != nil {
+		return fmt.Errorf("subscriber %q: %w", sub.PhoneNumber, err)
+	}
+
+	return nil
+}
+
+func (s *Service) UpdateSubscriber(ctx context.Context, sub model.Subscriber) error {
+	sub, err := normalizeSubscriber(sub)
+	if err != nil {
+		return err
+	}
+
+	if err := s.subs.Update(ctx, sub); err != nil {
+		return fmt.Errorf("subscriber %q: %w", sub.PhoneNumber, err)
+	}
+
+	return nil
+}
+
+func (s *Service) DeleteSubscriber(ctx context.Context, phone string) error {
+	phone = strings.TrimSpace(phone)
+	if err := s.subs.Delete(ctx, phone); err != nil {
+		return fmt.Errorf("subscriber %q: %w", phone, err)
+	}
+
+	return nil
+}
+
+func (s *Service) ListSubscribers(ctx context.Context, q model.SubscriberQuery) ([]model.Subscriber, int, error) {
+	if q.Offset < 0 {
+		return nil, 0, fmt.Errorf("%w: offset must be >= 0", ErrInvalidArgument)
+	}
+
+	if q.Limit <= 0 || q.Limit > maxSubscribersPage {
+		return nil, 0, fmt.Errorf("%w: limit must be in 1..%d", ErrInvalidArgument, maxSubscribersPage)
+	}
+
+	q.NameContains = strings.TrimSpace(q.NameContains)
+	q.PhonePrefix = strings.TrimSpace(q.PhonePrefix)
+
+	return s.subs.List(ctx, q)
+}
+
+func normalizeSubscriber(sub model.Subscriber) (model.Subscriber, error) {
+	sub.PhoneNumber = strings.TrimSpace(sub.PhoneNumber)
+	sub.ClientName = strings.TrimSpace(sub.ClientName)
+
+	if sub.PhoneNumber == "" {
+		return model.Subscriber{}, fmt.Errorf("%w: phone_number is required", ErrInvalidArgument)
+	}
+
+	if strings.ContainsAny(sub.PhoneNumber, "; \t") {
+		return model.Subscriber{}, fmt.Errorf("%w: phone_number must not contain spaces or ';'", ErrInvalidArgument)
+	}
+
+	if sub.CreditLimit < 0 {
+		return model.Subscriber{}, fmt.Errorf("%w: credit_limit must be >= 0", ErrInvalidArgument)
+	}
+
+	// ";" сломает справочник в CSV-формате
+	if strings.Contains(sub.ClientName, ";") {
+		return model.Subscriber{}, fmt.Errorf("%w: client_name must not contain ';'", ErrInvalidArgument)
+	}
+
+	return sub, nil
+}
@@ -12,6 +12,7 @@ package billing
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
@@ -20,13 +21,16 @@ import (
 	"sync/atomic"
 	"time"
 
+	"ukrainian_call_center_scam_goev/internal/billing/cdrformat"
 	"ukrainian_call_center_scam_goev/internal/billing/model"
+	"ukrainian_call_center_scam_goev/internal/billing/repo"
 )
 
 type cdrJob struct {
 	ctx   context.Context
 	batch *cdrBatch
 	seq   uint64
+	file  int // index of the source file in the run
 	cdr   model.CDRRecord
 	bytes int64 // approximate byte size of the source row (for progress UI)
 }
@@ -40,30 +44,51 @@ type ratedCallSeq struct {
 // It is updated concurrently by background workers.
 type cdrBatch struct {
 	collectCalls     bool
+	attribution      []model.AttributionSource
+	tariffs          repo.TariffTable
+	unknownPolicy    model.UnknownSubscriberPolicy
 	onProcessedBytes func(n int64)
+	onProcessedRows  func(rows, rejected int64)
 	demoSleepPerLine time.Duration
 
 	cancel     context.CancelFunc
 	cancelOnce sync.Once
 
-	mu     sync.Mutex
-	totals map[string]*model.SubscriberTotal
-	calls  []ratedCallSeq
+	mu       sync.Mutex
+	totals   map[string]*model.SubscriberTotal
+	unknowns map[string]*model.UnknownSubscriber
+	events   []model.CreditEvent
+	calls    []ratedCallSeq
+	stream   *callStream // Options.OnCall; calls are not collected then
 
-	readingDone atomic.Bool
-	pending     int64
+	// seq accounts rated rows in input order; ledger is used only from there.
+	seq    *rowSequencer
+	ledger *balanceLedger
+
+	// files: Rows/Rejected/Duplicates are written by the reading goroutine, Calls/Cost under mu.
+	files []model.FileStats
+
+	// pending counts rows handed to the workers and not finished yet; only the reading goroutine
+	// adds to it, so it may wait for it between rows (checkpoints) and after the last one (done).
+	pending        sync.WaitGroup
+	processedBytes int64 // for checkpoints
 
 	errMu sync.Mutex
 	err   error
 
-	doneOnce sync.Once
-	done     chan struct{}
+	// rejects are written only by the reading goroutine.
+	maxRejects int
+	rejects    model.RejectStats
+
+	// done is closed when reading is over and every row is finished.
+	done chan struct{}
 }
 
 func newCDRBatch(collectCalls bool) *cdrBatch {
 	b := &cdrBatch{
 		collectCalls: collectCalls,
 		totals:       make(map[string]*model.SubscriberTotal, 1024),
+		unknowns:     make(map[string]*model.UnknownSubscriber),
 		done:         make(chan struct{}),
 	}
 	if collectCalls {
@@ -104,33 +129,108 @@ func (b *cdrBatch) getErr() error {
 }
 
 func (b *cdrBatch) incPending() {
-	atomic.AddInt64(&b.pending, 1)
+	b.pending.Add(1)
 }
 
 func (b *cdrBatch) finishOne() {
-	if atomic.AddInt64(&b.pending, -1) == 0 && b.readingDone.Load() {
-		b.doneOnce.Do(func() { close(b.done) })
-	}
+	b.pending.Done()
+}
+
+// idle is closed when every row enqueued so far is finished and its call is streamed.
+// Called by the reading goroutine only.
+func (b *cdrBatch) idle() <-chan struct{} {
+	ch := make(chan struct{})
+
+	go func() {
+		b.pending.Wait()
+
+		if b.stream != nil {
+			<-b.stream.flushed()
+		}
+
+		close(ch)
+	}()
+
+	return ch
 }
 
 func (b *cdrBatch) markReadingDone() {
-	b.readingDone.Store(true)
+	go func() {
+		b.pending.Wait()
+		close(b.done)
+	}()
+}
+
+// header remembers the header row of a file for resubmission of its rejected rows.
+func (b *cdrBatch) header(file, raw string) {
+	if b.rejects.Headers == nil {
+		b.rejects.Headers = make(map[string]string)
+	}
+
+	b.rejects.Headers[file] = raw
+}
+
+// reject records a malformed row in tolerant mode.
+func (b *cdrBatch) reject(file string, lineNo int64, raw string, err error) {
+	b.rejects.Count++
+	if len(b.rejects.Rows) >= b.maxRejects {
+		b.rejects.Truncated = true
+		return
+	}
+
+	b.rejects.Rows = append(b.rejects.Rows, model.RejectedRow{File: file, Line: lineNo, Raw: raw, Err: err.Error()})
+}
+
+// processed reports a fully handled row to the progress callback.
+func (b *cdrBatch) processed(bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+
+	atomic.AddInt64(&b.processedBytes, bytes)
+
+	if b.onProcessedBytes != nil {
+		b.onProcessedBytes(bytes)
+	}
+}
+
+// processedRows reports handled rows to the progress callback.
+func (b *cdrBatch) processedRows(rows, rejected int64) {
+	if b.onProcessedRows != nil && rows > 0 {
+		b.onProcessedRows(rows, rejected)
+	}
+}
+
+func (b *cdrBatch) addUnknown(phone string, cost model.Money) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	if atomic.LoadInt64(&b.pending) == 0 {
-		b.doneOnce.Do(func() { close(b.done) })
+	u := b.unknowns[phone]
+	if u == nil {
+		u = &model.UnknownSubscriber{PhoneNumber: phone}
+		b.unknowns[phone] = u
 	}
+
+	u.CallsCount++
+	u.WouldBeCost += cost
 }
 
+// add accounts a rated call. Rows come in input order, so a threshold is attributed
+// to the call that really crossed it. The call is returned when the batch builds calls.
 func (b *cdrBatch) add(
 	sub model.Subscriber,
 	cdr model.CDRRecord,
 	cost model.Money,
 	best *model.TariffRule,
 	seq uint64,
-) {
+	file int,
+) *model.RatedCall {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	b.files[file].Calls++
+	b.files[file].Cost += cost
+
 	t := b.totals[sub.PhoneNumber]
 	if t == nil {
 		t = &model.SubscriberTotal{PhoneNumber: sub.PhoneNumber, ClientName: sub.ClientName}
@@ -140,11 +240,24 @@ func (b *cdrBatch) add(
 		t.ClientName = sub.ClientName
 	}
 
+	prev := t.TotalCost
 	t.TotalCost += cost
 	t.CallsCount++
 
-	if !b.collectCalls {
-		return
+	for _, pct := range crossedThresholds(prev, t.TotalCost, sub.CreditLimit) {
+		b.events = append(b.events, model.CreditEvent{
+			PhoneNumber:  sub.PhoneNumber,
+			ClientName:   sub.ClientName,
+			ThresholdPct: pct,
+			CreditLimit:  sub.CreditLimit,
+			TotalCost:    t.TotalCost,
+			CallID:       cdr.CallID,
+			At:           cdr.StartTime,
+		})
+	}
+
+	if !b.collectCalls && b.stream == nil {
+		return nil
 	}
 
 	var ref *model.AppliedTariffRef
@@ -152,143 +265,177 @@ func (b *cdrBatch) add(
 		ref = &model.AppliedTariffRef{Prefix: best.Prefix, Destination: best.Destination, Priority: best.Priority}
 	}
 
-	b.calls = append(b.calls, ratedCallSeq{
-		seq: seq,
-		call: model.RatedCall{
-			StartTime:    cdr.StartTime,
-			EndTime:      cdr.EndTime,
-			CallingParty: cdr.CallingParty,
-			CalledParty:  cdr.CalledParty,
-			Direction:    cdr.Direction,
-			Disposition:  cdr.Disposition,
-			Duration:     cdr.Duration,
-			BillableSec:  cdr.BillableSec,
-			AccountCode:  cdr.AccountCode,
-			CallID:       cdr.CallID,
-			TrunkName:    cdr.TrunkName,
-			Cost:         cost,
-			Tariff:       ref,
-		},
-	})
+	call := model.RatedCall{
+		StartTime:    cdr.StartTime,
+		EndTime:      cdr.EndTime,
+		CallingParty: cdr.CallingParty,
+		CalledParty:  cdr.CalledParty,
+		Direction:    cdr.Direction,
+		Disposition:  cdr.Disposition,
+		Duration:     cdr.Duration,
+		BillableSec:  cdr.BillableSec,
+		AccountCode:  cdr.AccountCode,
+		CallID:       cdr.CallID,
+		TrunkName:    cdr.TrunkName,
+
+		SubscriberPhone: sub.PhoneNumber,
+		Attributes:      cdr.Attributes,
+
+		Cost:   cost,
+		Tariff: ref,
+	}
+
+	if b.collectCalls {
+		b.calls = append(b.calls, ratedCallSeq{seq: seq, call: call})
+	}
+
+	return &call
+}
+
+// finishRow hands a finished row to the sequencer; a failing accounting stops the run.
+func (b *cdrBatch) finishRow(seq uint64, row *ratedRow) {
+	if err := b.seq.done(seq, row); err != nil {
+		b.setErr(err)
+	}
 }
 
 // TariffCDRStream reads CDR stream in the caller goroutine and enqueues parsed rows into
 // a global in-service queue. Background workers (started in New) are always running
 // and consume from this queue.
 func (s *Service) TariffCDRStream(ctx context.Context, r io.Reader, opt model.Options) (model.Report, error) {
+	return s.TariffCDRSources(ctx, []model.CDRSource{model.ReaderSource("", r)}, opt)
+}
+
+// TariffCDRSources rates several CDR files (e.g. entries of a zip archive) in one run.
+// Files are read one after another; dedup and totals span all of them, header detection is per file.
+func (s *Service) TariffCDRSources(ctx context.Context, sources []model.CDRSource, opt model.Options) (model.Report, error) {
 	if err := s.ensureOpen(); err != nil {
 		return model.Report{}, err
 	}
 
-	// Separate ctx for jobs so we can cancel workers' work on parse errors.
-	jobCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	batch := newCDRBatch(opt.CollectCalls)
-	batch.cancel = cancel
-	batch.onProcessedBytes = opt.OnProcessedBytes
-	batch.demoSleepPerLine = opt.DemoSleepPerLine
-
-	sc := bufio.NewScanner(r)
-	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-
-	var seq uint64
+	// форматы проверяются до чтения, чтобы не упасть на середине многофайлового расчёта
+	formats := make([]cdrformat.Format, len(sources))
+	for i, src := range sources {
+		name := src.Format
+		if name == "" {
+			name = opt.Format
+		}
 
-	for sc.Scan() {
-		if jobCtx.Err() != nil {
-			batch.setErr(jobCtx.Err())
-			break
+		f, err := s.formats.Get(name)
+		if err != nil {
+			return model.Report{}, err
 		}
 
-		line := sc.Text()
-		// Approx bytes for progress UI: token bytes + '\n'.
-		// Scanner strips '\n'. For the last line without newline this is slightly optimistic,
-		// but ProgressStore caps read_bytes by total_bytes.
-		lineBytes := int64(len(sc.Bytes()) + 1)
+		formats[i] = f
+	}
 
-		fields := strings.Split(line, "|")
-		if len(fields) < 12 {
-			batch.setErr(fmt.Errorf("cdr: expected at least 12 fields, got %d", len(fields)))
-			cancel()
-			break
-		}
+	// сверх лимита одновременных расчётов ждём своей очереди
+	if err := s.admission.acquire(ctx, s.stopCtx, opt.Priority); err != nil {
+		return model.Report{}, err
+	}
+	defer s.admission.release()
 
-		start, err := time.ParseInLocation(cdrLayout, fields[0], s.loc)
-		if err != nil {
-			batch.setErr(fmt.Errorf("cdr: bad StartTime %q: %w", fields[0], err))
-			cancel()
+	if opt.OnStarted != nil {
+		opt.OnStarted()
+	}
 
-			break
-		}
+	// тарифы на старте: если их перезагрузят во время расчёта, строки считаются и отчёт
+	// показывает версию по той же таблице
+	set, err := s.currentTariffs(ctx)
+	if err != nil {
+		return model.Report{}, err
+	}
 
-		end, err := time.ParseInLocation(cdrLayout, fields[1], s.loc)
-		if err != nil {
-			batch.setErr(fmt.Errorf("cdr: bad EndTime %q: %w", fields[1], err))
-			cancel()
+	var tariffs model.TariffVersion
+	if set.version != nil {
+		tariffs = *set.version
+	}
 
-			break
-		}
+	// Separate ctx for jobs so we can cancel workers' work on parse errors.
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		duration, err := atoiFast(fields[6])
-		if err != nil {
-			batch.setErr(fmt.Errorf("cdr: bad Duration %q: %w", fields[6], err))
-			cancel()
+	batch := newCDRBatch(opt.CollectCalls && opt.OnCall == nil)
+	batch.cancel = cancel
+	batch.attribution = opt.Attribution
+	batch.tariffs = set.table
+	batch.unknownPolicy = opt.UnknownSubscribers
+	batch.ledger = newBalanceLedger(s.balances, opt.NegativeBalance)
+	batch.seq = newRowSequencer(func(seq uint64, row *ratedRow) error {
+		return s.accountRow(jobCtx, batch, seq, row)
+	})
 
-			break
-		}
+	// звонки пишет отдельная горутина расчёта; при выходе она остановлена, и OnCall больше не вызывается
+	if opt.OnCall != nil {
+		batch.stream = newCallStream(opt.OnCall, batch.setErr)
+		defer batch.stream.halt()
+	}
 
-		bill, err := atoiFast(fields[7])
-		if err != nil {
-			batch.setErr(fmt.Errorf("cdr: bad BillableSec %q: %w", fields[7], err))
-			cancel()
+	batch.maxRejects = opt.MaxRejects
+	if batch.maxRejects <= 0 {
+		batch.maxRejects = model.DefaultMaxRejects
+	}
 
-			break
-		}
+	batch.onProcessedBytes = opt.OnProcessedBytes
+	batch.onProcessedRows = opt.OnProcessedRows
+	batch.demoSleepPerLine = opt.DemoSleepPerLine
 
-		dir := model.ParseCallDirection(fields[4])
-		disp := model.ParseDisposition(fields[5])
+	batch.files = make([]model.FileStats, len(sources))
+	for i, src := range sources {
+		batch.files[i].Name = src.Name
+	}
 
-		cdr := model.CDRRecord{
-			StartTime: start,
-			EndTime:   end,
+	rd := &cdrReader{
+		svc:       s,
+		ctx:       jobCtx,
+		cancel:    cancel,
+		batch:     batch,
+		queue:     newRunQueue(opt.Priority),
+		opt:       opt,
+		validator: newCDRValidator(opt.Validation),
+		dedup:     newCDRDedup(opt.Dedup, batch.maxRejects),
+		tariffs:   tariffs,
+
+		lastCheckpoint: time.Now(),
+	}
 
-			CallingParty: fields[2],
-			CalledParty:  fields[3],
+	// для одиночного файла имя в позициях строк не нужно: "line 42" понятнее
+	rd.named = len(sources) > 1
 
-			Direction:   dir,
-			Disposition: disp,
+	var from resumePos
 
-			Duration:    duration,
-			BillableSec: bill,
+	if cp := opt.Resume; cp != nil {
+		if cp.Tariffs.SHA256 != tariffs.SHA256 {
+			return model.Report{}, fmt.Errorf("%w: tariffs differ from the ones the run was started with", ErrInvalidArgument)
+		}
 
-			AccountCode: fields[9],
-			CallID:      fields[10],
-			TrunkName:   fields[11],
+		if cp.Source < 0 || cp.Source >= len(sources) {
+			return model.Report{}, fmt.Errorf("%w: checkpoint source %d out of range", ErrInvalidArgument, cp.Source)
 		}
 
-		batch.incPending()
-		job := cdrJob{ctx: jobCtx, batch: batch, seq: seq, cdr: cdr, bytes: lineBytes}
-		seq++
+		if err := rd.restore(cp); err != nil {
+			return model.Report{}, err
+		}
 
-		select {
-		case s.jobs <- job:
-		case <-jobCtx.Done():
-			batch.setErr(jobCtx.Err())
-			batch.finishOne()
-		case <-s.stopCtx.Done():
-			batch.setErr(fmt.Errorf("billing service stopped"))
-			batch.finishOne()
-			cancel()
+		from = resumePos{source: cp.Source, offset: cp.Offset, line: cp.Line}
+		batch.seq.next = rd.seq
+	}
+
+	zones := s.cdrZones(opt.Location)
+
+	for i := from.source; i < len(sources); i++ {
+		start := resumePos{source: i}
+		if i == from.source {
+			start = from
 		}
 
-		if batch.getErr() != nil {
+		if !rd.readSource(i, sources[i], formats[i].NewParser(zones), start) {
 			break
 		}
 	}
 
-	if err := sc.Err(); err != nil {
-		batch.setErr(fmt.Errorf("read cdr: %w", err))
-		cancel()
+	if batch.getErr() == nil {
+		rd.enqueueAll(rd.dedup.flush())
 	}
 
 	batch.markReadingDone()
@@ -296,11 +443,24 @@ func (s *Service) TariffCDRStream(ctx context.Context, r io.Reader, opt model.Op
 	select {
 	case <-batch.done:
 	case <-ctx.Done():
-		batch.setErr(ctx.Err())
-		return model.Report{}, ctx.Err()
+		batch.setErr(context.Cause(ctx))
+		s.drain(batch)
+
+		return model.Report{}, context.Cause(ctx)
 	case <-s.stopCtx.Done():
-		batch.setErr(fmt.Errorf("billing service stopped"))
-		return model.Report{}, fmt.Errorf("billing service stopped")
+		batch.setErr(ErrStopped)
+		s.drain(batch)
+
+		return model.Report{}, ErrStopped
+	}
+
+	// отчёт собирается после того, как OnCall получил все звонки
+	if batch.stream != nil && batch.getErr() == nil {
+		select {
+		case <-batch.stream.flushed():
+		case <-ctx.Done():
+			batch.setErr(context.Cause(ctx))
+		}
 	}
 
 	if err := batch.getErr(); err != nil {
@@ -315,6 +475,20 @@ func (s *Service) TariffCDRStream(ctx context.Context, r io.Reader, opt model.Op
 
 	sort.Slice(totals, func(i, j int) bool { return totals[i].PhoneNumber < totals[j].PhoneNumber })
 
+	unknowns := make([]model.UnknownSubscriber, 0, len(batch.unknowns))
+	for _, v := range batch.unknowns {
+		unknowns = append(unknowns, *v)
+	}
+
+	sort.Slice(unknowns, func(i, j int) bool { return unknowns[i].PhoneNumber < unknowns[j].PhoneNumber })
+
+	var charges []model.BalanceCharges
+	if !opt.Preview {
+		charges = batch.ledger.staged()
+	}
+
+	sortCreditEvents(batch.events)
+
 	var calls []model.RatedCall
 	if opt.CollectCalls {
 		sort.Slice(batch.calls, func(i, j int) bool { return batch.calls[i].seq < batch.calls[j].seq })
@@ -325,5 +499,236 @@ func (s *Service) TariffCDRStream(ctx context.Context, r io.Reader, opt model.Op
 		}
 	}
 
-	return model.Report{Calls: calls, Totals: totals}, nil
+	return model.Report{
+		Calls:              calls,
+		Totals:             totals,
+		UnknownSubscribers: unknowns,
+		Balances:           batch.ledger.summaries(),
+		CreditEvents:       batch.events,
+		Rejects:            batch.rejects,
+		Violations:         rd.validator.report(),
+		Duplicates:         rd.dedup.stats,
+		Files:              batch.files,
+		Tariffs:            tariffs,
+		Charges:            charges,
+	}, nil
+}
+
+// drain waits for rows already handed to the workers of a canceled run: they see the canceled ctx
+// and are skipped, so this is quick. Afterwards nobody touches the batch and collected calls can go.
+func (s *Service) drain(batch *cdrBatch) {
+	select {
+	case <-batch.done:
+	case <-s.stopCtx.Done():
+		// строки из очереди уже не будут посчитаны: ждём, пока воркеры выйдут и больше не тронут расчёт
+		s.wg.Wait()
+	}
+
+	batch.mu.Lock()
+	batch.calls = nil
+	batch.mu.Unlock()
+}
+
+// cdrReader is the reading side of a run: it parses, validates and dedups rows
+// and hands them to the workers. It lives in the caller goroutine.
+type cdrReader struct {
+	svc    *Service
+	ctx    context.Context
+	cancel context.CancelFunc
+	batch  *cdrBatch
+	queue  *runQueue
+	opt    model.Options
+
+	validator *cdrValidator
+	dedup     *cdrDedup
+
+	named bool // use file names in row positions
+	seq   uint64
+
+	tariffs        model.TariffVersion
+	lastCheckpoint time.Time
+	savedCalls     int // collected calls the checkpoints already have
+}
+
+// resumePos is where reading starts: the beginning of a source or a checkpoint inside it.
+type resumePos struct {
+	source int
+	offset int64
+	line   int64
+}
+
+// readSource reads one source file and reports whether the run may go on.
+func (rd *cdrReader) readSource(idx int, src model.CDRSource, parser cdrformat.Parser, from resumePos) bool {
+	batch := rd.batch
+	stats := &batch.files[idx]
+
+	rc, err := src.Open()
+	if err != nil {
+		batch.setErr(fmt.Errorf("open %s: %w", src.Name, err))
+		return false
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if from.offset > 0 {
+		if r, err = skipTo(rc, parser, from.offset); err != nil {
+			batch.setErr(err)
+			return false
+		}
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var (
+		lineNo  = from.line
+		offset  = from.offset // bytes of rows already handled
+		posName string
+	)
+
+	if rd.named {
+		posName = src.Name
+	}
+
+	for sc.Scan() {
+		// строки до текущей уже учтены: подходящий момент для контрольной точки
+		if rd.checkpointDue() && !rd.checkpoint(idx, offset, lineNo) {
+			return false
+		}
+
+		lineNo++
+
+		if rd.ctx.Err() != nil {
+			batch.setErr(context.Cause(rd.ctx))
+			return false
+		}
+
+		line := sc.Text()
+		if lineNo == 1 {
+			// Excel пишет CSV с BOM: без этого не узнать заголовок и не разобрать первую строку
+			line = strings.TrimPrefix(line, cdrformat.UTF8BOM)
+		}
+		// Approx bytes for progress UI: token bytes + '\n'.
+		// Scanner strips '\n'. For the last line without newline this is slightly optimistic,
+		// but ProgressStore caps read_bytes by total_bytes.
+		lineBytes := int64(len(sc.Bytes()) + 1)
+		offset += lineBytes
+
+		if strings.TrimSpace(line) == "" {
+			batch.processed(lineBytes)
+			continue
+		}
+
+		cdr, ok, err := parser.Parse(line)
+		if err == nil && !ok {
+			// первая пропущенная строка — заголовок файла
+			if lineNo == 1 {
+				batch.header(posName, line)
+			}
+
+			batch.processed(lineBytes)
+			continue
+		}
+
+		// в отчётах — номер строки в исходном файле пользователя
+		srcLine := lineNo
+		if src.Lines != nil {
+			srcLine = src.Lines(lineNo)
+		}
+
+		stats.Rows++
+
+		var ready []dedupRow
+
+		if err == nil {
+			err = rd.validator.check(&cdr)
+		}
+
+		if err == nil {
+			var dropped int64
+
+			row := dedupRow{pos: dedupPos{file: posName, line: srcLine}, file: idx, cdr: cdr, bytes: lineBytes}
+			dupsBefore := rd.dedup.stats.Count
+
+			ready, dropped, err = rd.dedup.admit(row)
+			stats.Duplicates += rd.dedup.stats.Count - dupsBefore
+			batch.processed(dropped)
+
+			// отброшенный дубликат тоже обработанная строка
+			if dropped > 0 {
+				batch.processedRows(1, 0)
+			}
+		}
+
+		if err != nil {
+			if rd.opt.Tolerant {
+				stats.Rejected++
+				batch.reject(posName, srcLine, line, err)
+				batch.processed(lineBytes)
+				batch.processedRows(1, 1)
+
+				continue
+			}
+
+			batch.setErr(fmt.Errorf("%s: %w", dedupPos{file: posName, line: srcLine}, err))
+			rd.cancel()
+
+			return false
+		}
+
+		if !rd.enqueueAll(ready) {
+			return false
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		name := src.Name
+		if name == "" {
+			name = "cdr"
+		}
+
+		batch.setErr(fmt.Errorf("read %s: %w", name, err))
+		rd.cancel()
+
+		return false
+	}
+
+	return true
+}
+
+// enqueue hands a row to the workers and reports whether reading may go on.
+func (rd *cdrReader) enqueue(row dedupRow) bool {
+	batch := rd.batch
+
+	if batch.stream != nil {
+		if err := batch.stream.reserve(rd.ctx, rd.svc.stopCtx); err != nil {
+			batch.setErr(err)
+			return false
+		}
+	}
+
+	batch.incPending()
+	job := cdrJob{ctx: rd.ctx, batch: batch, seq: rd.seq, file: row.file, cdr: row.cdr, bytes: row.bytes}
+	rd.seq++
+
+	if err := rd.svc.sched.push(rd.ctx, rd.svc.stopCtx, rd.queue, job); err != nil {
+		batch.setErr(err)
+		batch.finishOne()
+
+		if errors.Is(err, ErrStopped) {
+			rd.cancel()
+		}
+	}
+
+	return batch.getErr() == nil
+}
+
+func (rd *cdrReader) enqueueAll(rows []dedupRow) bool {
+	for _, row := range rows {
+		if !rd.enqueue(row) {
+			return false
+		}
+	}
+
+	return true
 }
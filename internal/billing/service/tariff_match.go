@@ -10,22 +10,40 @@
 package billing
 
 import (
-	"context"
 	"time"
 
 	"ukrainian_call_center_scam_goev/internal/billing/model"
+	"ukrainian_call_center_scam_goev/internal/billing/repo"
 )
 
-func (s *Service) matchBestTariff(ctx context.Context, called string, at time.Time) *model.TariffRule {
-	atMin := at.Hour()*60 + at.Minute()
-	wd := weekdayBit(at.Weekday())
+// tariffSet is a loaded tariff table with its version (nil until tariffs are loaded).
+// A run takes the current set once and rates against it, so a reload during the run
+// changes neither its rates nor the version in its report.
+type tariffSet struct {
+	version *model.TariffVersion
+	table   repo.TariffTable
+}
+
+func matchBestTariff(table repo.TariffTable, called string, at time.Time) *model.TariffRule {
+	var (
+		best *model.TariffRule
 
-	var best *model.TariffRule
+		// таймбенд и день недели считаются в зоне тарифа; зона почти всегда одна на все правила
+		loc   *time.Location
+		atMin int
+		wd    uint8
+		ready bool
+	)
 
 	bestPriority := -1
 	bestPrefixLen := -1
 
-	_ = s.tariffs.VisitByNumber(ctx, called, func(rule *model.TariffRule, prefixLen int) bool {
+	table.VisitByNumber(called, func(rule *model.TariffRule, prefixLen int) bool {
+		if !ready || rule.Location != loc {
+			loc, ready = rule.Location, true
+			atMin, wd = wallClock(at, loc)
+		}
+
 		if !isApplicable(rule, at, atMin, wd) {
 			return true
 		}
@@ -42,6 +60,15 @@ func (s *Service) matchBestTariff(ctx context.Context, called string, at time.Ti
 	return best
 }
 
+// wallClock returns minutes since midnight and weekday bit of at in loc (nil keeps at's own zone).
+func wallClock(at time.Time, loc *time.Location) (int, uint8) {
+	if loc != nil {
+		at = at.In(loc)
+	}
+
+	return at.Hour()*60 + at.Minute(), weekdayBit(at.Weekday())
+}
+
 func isApplicable(rule *model.TariffRule, at time.Time, atMin int, wd uint8) bool {
 	if at.Before(rule.EffectiveStart) || !at.Before(rule.ExpiryExclusive) {
 		return false
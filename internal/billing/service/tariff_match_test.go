@@ -0,0 +1,87 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// doubledTariffs is example/tariffs.csv with every rate set to 9.99.
+func doubledTariffs(t *testing.T) string {
+	t.Helper()
+
+	raw, err := os.ReadFile("../../../example/tariffs.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	for i := 1; i < len(lines); i++ {
+		fields := strings.Split(lines[i], ";")
+		fields[2] = "9.99"
+		lines[i] = strings.Join(fields, ";")
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestRunRatesAgainstTariffsOfItsStart(t *testing.T) {
+	const subscribers = "phone_number;client_name\n78123260000;Office Billing\n"
+
+	cdr := testCDR(2000, "78123260000")
+	ctx := context.Background()
+
+	want, err := newTestService(t, 4, subscribers).TariffCDRStream(ctx, strings.NewReader(cdr), model.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := newTestService(t, 4, subscribers)
+	version, _ := svc.TariffVersion()
+
+	pr, pw := io.Pipe()
+	half := strings.Index(cdr[len(cdr)/2:], "\n") + len(cdr)/2 + 1
+
+	go func() {
+		_, _ = io.WriteString(pw, cdr[:half])
+
+		// вторая половина файла читается уже после перезагрузки тарифов
+		if err := svc.LoadTariffs(ctx, strings.NewReader(doubledTariffs(t))); err != nil {
+			t.Errorf("reload tariffs: %v", err)
+		}
+
+		_, _ = io.WriteString(pw, cdr[half:])
+		_ = pw.Close()
+	}()
+
+	got, err := svc.TariffCDRStream(ctx, pr, model.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got.Totals, want.Totals) {
+		t.Errorf("totals changed by a reload during the run:\n got %+v\nwant %+v", got.Totals, want.Totals)
+	}
+
+	if got.Tariffs != version {
+		t.Errorf("report tariff version = %+v, want the one of the start %+v", got.Tariffs, version)
+	}
+
+	if now, _ := svc.TariffVersion(); now == version {
+		t.Error("tariffs were not reloaded")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/cdrformat"
+)
+
+// trunkZones is an immutable snapshot: names are kept for listing, locations for parsing.
+type trunkZones struct {
+	names map[string]string
+	locs  map[string]*time.Location
+}
+
+// SetTrunkZones replaces per-trunk time zones ({"SIP_Trunk_02": "Asia/Yekaterinburg"}).
+// Wall-clock CDR times of a trunk are read in its zone.
+func (s *Service) SetTrunkZones(zones map[string]string) error {
+	next := &trunkZones{
+		names: make(map[string]string, len(zones)),
+		locs:  make(map[string]*time.Location, len(zones)),
+	}
+
+	for trunk, name := range zones {
+		trunk = strings.TrimSpace(trunk)
+		if trunk == "" {
+			return fmt.Errorf("%w: empty trunk name", ErrInvalidArgument)
+		}
+
+		loc, err := LoadLocation(name)
+		if err != nil {
+			return fmt.Errorf("%w: trunk %q: %w", ErrInvalidArgument, trunk, err)
+		}
+
+		next.names[trunk] = loc.String()
+		next.locs[trunk] = loc
+	}
+
+	s.trunkZones.Store(next)
+
+	return nil
+}
+
+// TrunkZones returns configured per-trunk time zones.
+func (s *Service) TrunkZones() map[string]string {
+	if z := s.trunkZones.Load(); z != nil {
+		return maps.Clone(z.names)
+	}
+
+	return map[string]string{}
+}
+
+// Location is the default zone of CDR times and tariffs.
+func (s *Service) Location() *time.Location {
+	return s.loc
+}
+
+// cdrZones combines the upload zone (or the service default) with trunk zones.
+func (s *Service) cdrZones(upload *time.Location) cdrformat.Zones {
+	z := cdrformat.Zones{Default: s.loc}
+	if upload != nil {
+		z.Default = upload
+	}
+
+	if tz := s.trunkZones.Load(); tz != nil {
+		z.Trunks = tz.locs
+	}
+
+	return z
+}
+
+// LoadLocation is time.LoadLocation with a readable error; empty name is not allowed.
+func LoadLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("empty time zone")
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+
+	return loc, nil
+}
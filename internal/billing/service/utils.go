@@ -9,11 +9,7 @@
 
 package billing
 
-import (
-	"fmt"
-	"strings"
-	"time"
-)
+import "time"
 
 func weekdayBit(w time.Weekday) uint8 {
 	if w == time.Sunday {
@@ -22,23 +18,3 @@ func weekdayBit(w time.Weekday) uint8 {
 
 	return uint8(w)
 }
-
-func atoiFast(s string) (int, error) {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return 0, fmt.Errorf("empty int")
-	}
-
-	n := 0
-
-	for i := range len(s) {
-		c := s[i]
-		if c < '0' || c > '9' {
-			return 0, fmt.Errorf("bad int %q", s)
-		}
-
-		n = n*10 + int(c-'0')
-	}
-
-	return n, nil
-}
@@ -0,0 +1,111 @@
+// Copyright (c) 2023-2026, KNS Group LLC ("YADRO").
+// All Rights Reserved.
+// This software contains the intellectual property of YADRO
+// or is licensed to YADRO from third parties. Use of this
+// software and the intellectual property contained therein is expressly
+// limited to the terms and conditions of the License Agreement under which
+// it is provided by YADRO.
+//
+
+package billing
+
+import (
+	"fmt"
+	"time"
+
+	"ukrainian_call_center_scam_goev/internal/billing/model"
+)
+
+// cdrValidator applies semantic rules to parsed rows.
+// It is used only by the reading goroutine, so counters are not synchronized.
+type cdrValidator struct {
+	cfg       model.ValidationConfig
+	tolerance int
+	counts    [model.NumValidationRules]int64
+}
+
+func newCDRValidator(cfg model.ValidationConfig) *cdrValidator {
+	v := &cdrValidator{cfg: cfg, tolerance: model.DefaultDurationTolerance}
+	if cfg.DurationTolerance != nil {
+		v.tolerance = *cfg.DurationTolerance
+	}
+
+	return v
+}
+
+// check counts every violated rule and returns an error for the first one with "reject" severity.
+func (v *cdrValidator) check(cdr *model.CDRRecord) error {
+	var rejectErr error
+
+	for rule := range model.ValidationRule(model.NumValidationRules) {
+		sev := v.cfg.Severities[rule]
+		if sev == model.SeverityOff {
+			continue
+		}
+
+		msg := v.violation(rule, cdr)
+		if msg == "" {
+			continue
+		}
+
+		v.counts[rule]++
+
+		if sev == model.SeverityReject && rejectErr == nil {
+			rejectErr = fmt.Errorf("validation %s: %s", rule, msg)
+		}
+	}
+
+	return rejectErr
+}
+
+// violation returns a description of the rule violation or "" if the row is fine.
+func (v *cdrValidator) violation(rule model.ValidationRule, cdr *model.CDRRecord) string {
+	switch rule {
+	case model.RuleEndBeforeStart:
+		if !cdr.EndTime.IsZero() && cdr.EndTime.Before(cdr.StartTime) {
+			return fmt.Sprintf("end_time %s is before start_time %s",
+				cdr.EndTime.Format(time.DateTime), cdr.StartTime.Format(time.DateTime))
+		}
+	case model.RuleBillableExceedsDuration:
+		if cdr.BillableSec > cdr.Duration {
+			return fmt.Sprintf("billable_sec %d exceeds duration %d", cdr.BillableSec, cdr.Duration)
+		}
+	case model.RuleUnknownDirection:
+		if cdr.Direction == model.DirUnknown {
+			return "unknown direction"
+		}
+	case model.RuleUnknownDisposition:
+		if cdr.Disposition == model.DispUnknown {
+			return "unknown disposition"
+		}
+	case model.RuleEmptyCalledParty:
+		if cdr.CalledParty == "" {
+			return "empty called_party"
+		}
+	case model.RuleDurationMismatch:
+		// без end_time или с перепутанными временами сверять нечего — это ловит end_before_start
+		if cdr.EndTime.IsZero() || cdr.EndTime.Before(cdr.StartTime) {
+			return ""
+		}
+
+		span := int(cdr.EndTime.Sub(cdr.StartTime).Seconds())
+		if diff := span - cdr.Duration; diff > v.tolerance || -diff > v.tolerance {
+			return fmt.Sprintf("duration %d differs from end_time - start_time = %d", cdr.Duration, span)
+		}
+	}
+
+	return ""
+}
+
+func (v *cdrValidator) report() []model.RuleViolations {
+	out := make([]model.RuleViolations, 0, model.NumValidationRules)
+	for rule := range model.ValidationRule(model.NumValidationRules) {
+		out = append(out, model.RuleViolations{
+			Rule:     rule,
+			Severity: v.cfg.Severities[rule],
+			Count:    v.counts[rule],
+		})
+	}
+
+	return out
+}
@@ -9,10 +9,256 @@
 
 package config
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
 
+// Config is the effective configuration of the service: defaults, then the JSON file, then env.
 type Config struct {
-	Location     *time.Location
-	CDRWorkers   int
-	CDRQueueSize int
+	Addr string `json:"addr"`
+
+	// Timezone is the default zone of CDR times and of tariffs without their own zone (IANA).
+	Timezone string         `json:"timezone"`
+	Location *time.Location `json:"-"` // resolved Timezone
+
+	// CDRWorkers rate rows in parallel (0 in the file or env means GOMAXPROCS).
+	// CDRQueueSize is the buffer between the dispatcher and the workers.
+	CDRWorkers   int `json:"cdr_workers"`
+	CDRQueueSize int `json:"cdr_queue_size"`
+
+	// MaxConcurrentRuns limits rating runs reading at the same time (0 means unlimited).
+	MaxConcurrentRuns int `json:"max_concurrent_runs"`
+
+	// MaxZipBytes limits zip uploads, which are spooled to disk before rating (0 means unlimited).
+	MaxZipBytes int64 `json:"max_zip_bytes"`
+
+	CreditWebhookURL string `json:"credit_webhook_url"`
+
+	// DataDir keeps results, prepared files and job checkpoints across restarts (empty means memory only).
+	DataDir            string   `json:"data_dir"`
+	ResultsRetention   Duration `json:"results_retention"`
+	CheckpointInterval Duration `json:"checkpoint_interval"`
+
+	Ingest IngestConfig `json:"ingest"`
+}
+
+// IngestConfig configures the watched inbox directory (disabled when Dir is empty).
+type IngestConfig struct {
+	Dir       string   `json:"dir"`
+	OutputDir string   `json:"output_dir"`
+	Poll      Duration `json:"poll"`
+	Stable    Duration `json:"stable"`
+
+	// Options are rating options in query-string form, e.g. "tolerant=true&dedup=first_wins".
+	Options string `json:"options"`
+}
+
+// Duration is time.Duration written as a Go duration string ("30s", "720h") in JSON.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("want a duration string like \"30s\", got %s", b)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("want a duration like \"30s\", got %q", s)
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+func Default() Config {
+	return Config{
+		Addr:               ":8080",
+		Timezone:           "UTC",
+		CDRQueueSize:       64,
+		MaxConcurrentRuns:  4,
+		MaxZipBytes:        4 << 30,
+		ResultsRetention:   Duration(30 * 24 * time.Hour),
+		CheckpointInterval: Duration(30 * time.Second),
+		Ingest: IngestConfig{
+			Poll:   Duration(30 * time.Second),
+			Stable: Duration(time.Minute),
+		},
+	}
+}
+
+// envVars maps environment variables onto the config; they override the file.
+var envVars = []struct {
+	name string
+	set  func(c *Config, v string) error
+}{
+	{"ADDR", func(c *Config, v string) error { c.Addr = v; return nil }},
+	{"BILLING_TIMEZONE", func(c *Config, v string) error { c.Timezone = v; return nil }},
+	{"CDR_WORKERS", func(c *Config, v string) error { return setInt(&c.CDRWorkers, v) }},
+	{"CDR_QUEUE_SIZE", func(c *Config, v string) error { return setInt(&c.CDRQueueSize, v) }},
+	{"MAX_CONCURRENT_RUNS", func(c *Config, v string) error { return setInt(&c.MaxConcurrentRuns, v) }},
+	{"MAX_ZIP_BYTES", func(c *Config, v string) error { return setInt64(&c.MaxZipBytes, v) }},
+	{"CREDIT_WEBHOOK_URL", func(c *Config, v string) error { c.CreditWebhookURL = v; return nil }},
+	{"DATA_DIR", func(c *Config, v string) error { c.DataDir = v; return nil }},
+	{"RESULTS_RETENTION", func(c *Config, v string) error { return setDuration(&c.ResultsRetention, v) }},
+	{"CHECKPOINT_INTERVAL", func(c *Config, v string) error { return setDuration(&c.CheckpointInterval, v) }},
+	{"INGEST_DIR", func(c *Config, v string) error { c.Ingest.Dir = v; return nil }},
+	{"INGEST_OUTPUT_DIR", func(c *Config, v string) error { c.Ingest.OutputDir = v; return nil }},
+	{"INGEST_POLL", func(c *Config, v string) error { return setDuration(&c.Ingest.Poll, v) }},
+	{"INGEST_STABLE", func(c *Config, v string) error { return setDuration(&c.Ingest.Stable, v) }},
+	{"INGEST_OPTIONS", func(c *Config, v string) error { c.Ingest.Options = v; return nil }},
+}
+
+// Load builds the config from defaults, the JSON file at path (optional) and env (lookup is os.LookupEnv
+// in production), then validates it. Errors name the offending setting.
+func Load(path string, lookup func(string) (string, bool)) (Config, error) {
+	c := Default()
+
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("config file: %w", err)
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+
+		if err := dec.Decode(&c); err != nil {
+			return Config{}, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+
+	for _, e := range envVars {
+		v, ok := lookup(e.name)
+		if !ok || v == "" {
+			continue
+		}
+
+		if err := e.set(&c, strings.TrimSpace(v)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return Config{}, errors.Join(errs...)
+	}
+
+	if c.CDRWorkers == 0 {
+		c.CDRWorkers = runtime.GOMAXPROCS(0)
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return c, nil
+}
+
+// Validate checks the config and resolves Location. All problems are reported at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(strings.TrimSpace(c.Addr) != "", "addr: must not be empty")
+
+	if loc, err := time.LoadLocation(strings.TrimSpace(c.Timezone)); err != nil || c.Timezone == "" {
+		errs = append(errs, fmt.Errorf("timezone: unknown time zone %q", c.Timezone))
+	} else {
+		c.Location = loc
+	}
+
+	check(c.CDRWorkers >= 1 && c.CDRWorkers <= 1024, "cdr_workers: must be in 1..1024, got %d", c.CDRWorkers)
+	check(c.CDRQueueSize >= 0 && c.CDRQueueSize <= 1_000_000,
+		"cdr_queue_size: must be in 0..1000000, got %d", c.CDRQueueSize)
+	check(c.MaxConcurrentRuns >= 0, "max_concurrent_runs: must be >= 0 (0 means unlimited), got %d", c.MaxConcurrentRuns)
+	check(c.MaxZipBytes >= 0, "max_zip_bytes: must be >= 0 (0 means unlimited), got %d", c.MaxZipBytes)
+
+	if c.CreditWebhookURL != "" {
+		u, err := url.Parse(c.CreditWebhookURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"credit_webhook_url: want an http(s) URL, got %q", c.CreditWebhookURL)
+	}
+
+	check(c.ResultsRetention >= 0, "results_retention: must be >= 0, got %s", c.ResultsRetention)
+	check(c.CheckpointInterval >= 0, "checkpoint_interval: must be >= 0, got %s", c.CheckpointInterval)
+
+	if c.Ingest.Dir != "" {
+		check(c.Ingest.Poll > 0, "ingest.poll: must be > 0, got %s", c.Ingest.Poll)
+		check(c.Ingest.Stable >= 0, "ingest.stable: must be >= 0, got %s", c.Ingest.Stable)
+	} else {
+		check(c.Ingest.OutputDir == "", "ingest.output_dir: set without ingest.dir")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted is the config safe to show over the API: credentials and query of the webhook URL are hidden.
+func (c Config) Redacted() Config {
+	if u, err := url.Parse(c.CreditWebhookURL); err == nil && c.CreditWebhookURL != "" {
+		if u.RawQuery != "" {
+			u.RawQuery = "xxxxx"
+		}
+
+		c.CreditWebhookURL = u.Redacted()
+	}
+
+	return c
+}
+
+func setInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("want an integer, got %q", v)
+	}
+
+	*dst = n
+
+	return nil
+}
+
+func setInt64(dst *int64, v string) error {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("want an integer, got %q", v)
+	}
+
+	*dst = n
+
+	return nil
+}
+
+func setDuration(dst *Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("want a duration like \"30s\", got %q", v)
+	}
+
+	*dst = Duration(d)
+
+	return nil
 }
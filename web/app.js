@@ -1,10 +1,3 @@
-function randomID() {
-    if (globalThis.crypto && typeof globalThis.crypto.randomUUID === "function") {
-        return globalThis.crypto.randomUUID();
-    }
-    return `${Date.now()}-${Math.random().toString(16).slice(2)}`;
-}
-
 const API_BASE = "";
 
 function qs(id) {
@@ -151,70 +144,137 @@ function setupPreparedCDR() {
     const uploadProgressText = qs("cdrUploadProgressText");
     const uploadStatus = qs("cdrUploadStatus");
     const startBtn = qs("cdrStartBtn");
+    const cancelBtn = qs("cdrCancelBtn");
     const collectCalls = qs("collectCalls");
+    const tolerant = qs("tolerant");
+    const preview = qs("preview");
     const processingWrap = qs("cdrProcessingWrap");
     const processingProgress = qs("cdrProcessingProgress");
     const processingText = qs("cdrProcessingText");
 
     let preparedID = "";
-    let pollTimer = null;
-    let pollingActive = false;
-
-    function stopPolling() {
-        pollingActive = false;
-        if (pollTimer) {
-            clearTimeout(pollTimer);
-            pollTimer = null;
+    let jobID = "";
+
+    // с DATA_DIR звонки остаются на сервере (store_calls) и читаются страницами
+    let callStorage = false;
+    fetch(`${API_BASE}/api/v1/config`, { cache: "no-store" })
+        .then((resp) => (resp.ok ? resp.json() : null))
+        .then((cfg) => { callStorage = Boolean(cfg?.data_dir); })
+        .catch(() => {});
+    let progressSource = null;
+
+    function stopWatching() {
+        cancelBtn.style.display = "none";
+        if (progressSource) {
+            progressSource.close();
+            progressSource = null;
         }
     }
 
-    function startPolling(progressID, statusEl) {
-        stopPolling();
-        pollingActive = true;
-
-        const poll = async () => {
-            try {
-                const resp = await fetch(`${API_BASE}/api/v1/cdr/progress/${encodeURIComponent(progressID)}`, { cache: "no-store" });
-                if (resp.ok) {
-                    const p = await resp.json();
-                    if (p.progress_pct === null || Number(p.total_bytes || 0) <= 0) {
-                        setProgressIndeterminate(processingProgress);
-                        processingText.textContent = `Расчет... ${Math.round((p.read_bytes || 0) / 1024)} KB processed`;
-                    } else {
-                        const pct = Math.max(0, Math.min(100, Number(p.progress_pct || 0)));
-                        setProgressDeterminate(processingProgress, pct);
-                        processingText.textContent = `Расчет... ${pct}% (${Math.round((p.read_bytes || 0) / 1024)} KB / ${Math.round((p.total_bytes || 0) / 1024)} KB)`;
-                    }
-
-                    if (p.status === "error" && p.error) {
-                        setStatus(statusEl, "err", `Ошибка расчета: ${p.error}`);
-                    }
-                }
-            } catch (_) {
-                // ignore polling glitches
-            } finally {
-                if (pollingActive) {
-                    pollTimer = setTimeout(poll, 250);
-                }
-            }
+    function formatETA(sec) {
+        if (sec >= 3600) return `${Math.floor(sec / 3600)} ч ${Math.floor((sec % 3600) / 60)} мин`;
+        if (sec >= 60) return `${Math.floor(sec / 60)} мин ${sec % 60} с`;
+        return `${sec} с`;
+    }
+
+    function showJobProgress(p) {
+        if (p.status === "queued") {
+            setProgressIndeterminate(processingProgress);
+            processingText.textContent = "В очереди: ждём завершения других расчётов...";
+            return;
+        }
+
+        if (p.status === "interrupted") {
+            setProgressIndeterminate(processingProgress);
+            processingText.textContent = "Расчет прерван перезапуском сервиса: ждём продолжения...";
+            return;
+        }
+
+        const rows = Number(p.rows_processed || 0);
+        let text;
+        if (p.progress_pct === null || Number(p.total_bytes || 0) <= 0) {
+            setProgressIndeterminate(processingProgress);
+            text = `Расчет... ${Math.round((p.read_bytes || 0) / 1024)} KB processed`;
+        } else {
+            const pct = Math.max(0, Math.min(100, Number(p.progress_pct || 0)));
+            setProgressDeterminate(processingProgress, pct);
+            text = `Расчет... ${pct}% (${Math.round((p.read_bytes || 0) / 1024)} KB / ${Math.round((p.total_bytes || 0) / 1024)} KB)`;
+        }
+
+        text += `, строк: ${rows}`;
+        if (p.rows_rejected > 0) text += ` (отклонено ${p.rows_rejected})`;
+        if (p.rows_per_sec > 0) text += `, ${Math.round(p.rows_per_sec)} строк/с, ${(p.bytes_per_sec / 1048576).toFixed(1)} MB/s`;
+        if (p.eta_sec !== null && p.eta_sec !== undefined) text += `, осталось ~${formatETA(p.eta_sec)}`;
+        processingText.textContent = text;
+    }
+
+    // watchProgress следит за фоновой задачей по SSE и вызывает onFinish, когда она завершится.
+    function watchProgress(jobID, statusEl, onFinish) {
+        stopWatching();
+
+        const source = new EventSource(`${API_BASE}/api/v1/cdr/progress/${encodeURIComponent(jobID)}/events`);
+        progressSource = source;
+
+        const finish = (e) => {
+            stopWatching();
+            const p = JSON.parse(e.data);
+            showJobProgress(p);
+            onFinish(p);
         };
 
-        pollTimer = setTimeout(poll, 250);
+        source.addEventListener("progress", (e) => showJobProgress(JSON.parse(e.data)));
+        source.addEventListener("done", finish);
+        source.addEventListener("error", (e) => {
+            // событие error с данными шлёт сервер; без данных — это обрыв соединения
+            if (e.data) {
+                finish(e);
+                return;
+            }
+
+            // при обрыве EventSource переподключается сам; CLOSED — сервер ответил не потоком (404)
+            if (source.readyState === EventSource.CLOSED && progressSource === source) {
+                stopWatching();
+                setStatus(statusEl, "err", "Задача расчета не найдена");
+                startBtn.disabled = !preparedID;
+            }
+        });
     }
 
     function resetPreparedState() {
         preparedID = "";
         startBtn.disabled = true;
         preparedMeta.textContent = "Файл еще не подготовлен";
-        stopPolling();
+        stopWatching();
         processingWrap.style.display = "none";
         resetProgress(processingProgress, processingText);
     }
 
+    async function fetchJobResult(jobID, started) {
+        try {
+            const resp = await fetch(`${API_BASE}/api/v1/jobs/${encodeURIComponent(jobID)}/result`, { cache: "no-store" });
+            const payload = await resp.json().catch(() => null);
+            if (!resp.ok) {
+                const msg = payload?.error?.message || `HTTP ${resp.status}`;
+                setStatus(uploadStatus, "err", `Ошибка расчета: ${msg}`);
+                return;
+            }
+
+            const clientElapsed = Math.round((performance.now() - started) * 10) / 10;
+            const calcMS = Number(payload?.calculation_ms || 0);
+            setProgressDeterminate(processingProgress, 100);
+            processingText.textContent = `Расчет завершен: ${calcMS.toFixed(1)} ms`;
+            setStatus(uploadStatus, "ok", `Расчет завершен за ${calcMS.toFixed(1)} ms (клиент получил отчет за ${clientElapsed} ms)`);
+            renderReport(payload);
+        } catch (_) {
+            setStatus(uploadStatus, "err", "Ошибка сети при получении отчета");
+        } finally {
+            startBtn.disabled = !preparedID;
+        }
+    }
+
     async function startCalculation() {
         if (!preparedID) return;
 
-        const progressID = randomID();
         const started = performance.now();
 
         startBtn.disabled = true;
@@ -222,43 +282,69 @@ function setupPreparedCDR() {
         setProgressDeterminate(processingProgress, 0);
         processingText.textContent = "Расчет запущен...";
         setStatus(uploadStatus, null, "Идет расчет...");
-        startPolling(progressID, uploadStatus);
 
         try {
-            const resp = await fetch(`${API_BASE}/api/v1/cdr/start`, {
+            const resp = await fetch(`${API_BASE}/api/v1/jobs`, {
                 method: "POST",
                 headers: { "Content-Type": "application/json" },
                 body: JSON.stringify({
                     prepared_id: preparedID,
-                    collect_calls: collectCalls.checked,
-                    progress_id: progressID,
+                    collect_calls: collectCalls.checked && !callStorage,
+                    store_calls: collectCalls.checked && callStorage,
+                    tolerant: tolerant.checked,
+                    preview: preview.checked,
                 }),
             });
 
-            stopPolling();
             const payload = await resp.json().catch(() => null);
-            if (!resp.ok) {
+            if (!resp.ok || !payload?.job_id) {
                 const msg = payload?.error?.message || `HTTP ${resp.status}`;
                 setStatus(uploadStatus, "err", `Ошибка расчета: ${msg}`);
+                startBtn.disabled = !preparedID;
                 return;
             }
 
-            const clientElapsed = Math.round((performance.now() - started) * 10) / 10;
-            const calcMS = Number(payload?.calculation_ms || 0);
-            setProgressDeterminate(processingProgress, 100);
-            processingText.textContent = `Расчет завершен: ${calcMS.toFixed(1)} ms`;
-            setStatus(uploadStatus, "ok", `Расчет завершен за ${calcMS.toFixed(1)} ms (клиент получил ответ за ${clientElapsed} ms)`);
-            renderReport(payload);
+            jobID = payload.job_id;
+            cancelBtn.disabled = false;
+            cancelBtn.style.display = "";
+
+            watchProgress(payload.job_id, uploadStatus, (job) => {
+                if (job.status === "canceled") {
+                    setStatus(uploadStatus, null, "Расчет отменен");
+                    startBtn.disabled = !preparedID;
+                    return;
+                }
+
+                if (job.status === "error") {
+                    setStatus(uploadStatus, "err", `Ошибка расчета: ${job.error || "unknown error"}`);
+                    startBtn.disabled = !preparedID;
+                    return;
+                }
+
+                fetchJobResult(payload.job_id, started);
+            });
         } catch (_) {
-            stopPolling();
+            stopWatching();
             setStatus(uploadStatus, "err", "Ошибка сети при запуске расчета");
-        } finally {
             startBtn.disabled = !preparedID;
         }
     }
 
+    async function cancelCalculation() {
+        if (!jobID) return;
+
+        cancelBtn.disabled = true;
+        try {
+            // задача перейдет в canceled, когда воркеры доработают уже взятые строки; это придёт событием
+            await fetch(`${API_BASE}/api/v1/cdr/progress/${encodeURIComponent(jobID)}/cancel`, { method: "POST" });
+        } catch (_) {
+            cancelBtn.disabled = false;
+        }
+    }
+
     browse.addEventListener("click", () => input.click());
     startBtn.addEventListener("click", startCalculation);
+    cancelBtn.addEventListener("click", cancelCalculation);
 
     wireDropzone(drop, input, (file) => {
         resetPreparedState();
@@ -292,19 +378,58 @@ function renderReport(report) {
     const totalsTBody = qs("totalsTable").querySelector("tbody");
     const callsDetails = qs("callsDetails");
     const callsTBody = qs("callsTable").querySelector("tbody");
+    const unknownDetails = qs("unknownDetails");
+    const unknownTBody = qs("unknownTable").querySelector("tbody");
+    const rejectsInfo = qs("rejectsInfo");
 
     if (!report || report.status !== "ok") {
         meta.textContent = "Пока пусто";
+        rejectsInfo.style.display = "none";
         totalsWrap.style.display = "none";
         callsDetails.style.display = "none";
+        unknownDetails.style.display = "none";
         return;
     }
 
     const totals = Array.isArray(report.totals) ? report.totals : [];
     const calls = Array.isArray(report.calls) ? report.calls : [];
+    const unknown = Array.isArray(report.unknown_subscribers) ? report.unknown_subscribers : [];
     const calcMS = Number(report.calculation_ms || 0);
 
-    meta.textContent = `status=${report.status}, calculation_ms=${calcMS.toFixed(1)}, totals=${totals.length}, calls=${calls.length}`;
+    meta.textContent = `status=${report.status}, calculation_ms=${calcMS.toFixed(1)}, totals=${totals.length}, calls=${calls.length}, unknown_subscribers=${unknown.length}`;
+
+    const rejects = report.rejects || {};
+    if (Number(rejects.count || 0) > 0) {
+        const truncated = rejects.truncated ? " (список обрезан)" : "";
+        let html = `Отброшено строк: ${escapeHtml(rejects.count)}${truncated}`;
+        if (rejects.download_id) {
+            const url = `${API_BASE}/api/v1/cdr/rejects/${encodeURIComponent(rejects.download_id)}`;
+            html += ` — <a href="${url}">скачать</a>, <a href="${url}?with_errors=true">с ошибками</a>`;
+        }
+
+        rejectsInfo.innerHTML = html;
+        rejectsInfo.style.display = "block";
+    } else {
+        rejectsInfo.style.display = "none";
+    }
+
+    if (unknown.length > 0) {
+        unknownTBody.innerHTML = unknown.map((u) => {
+            const kop = Number(u.would_be_cost_kop || 0);
+            return `
+      <tr>
+        <td>${escapeHtml(u.phone_number)}</td>
+        <td>${escapeHtml(u.calls_count)}</td>
+        <td>${escapeHtml(kop)}</td>
+        <td>${escapeHtml(kopToRub(kop))}</td>
+      </tr>
+    `;
+        }).join("");
+
+        unknownDetails.style.display = "block";
+    } else {
+        unknownDetails.style.display = "none";
+    }
 
     totalsTBody.innerHTML = totals.map((t) => {
         const kop = Number(t.total_cost_kop || 0);
@@ -321,14 +446,26 @@ function renderReport(report) {
 
     totalsWrap.style.display = "block";
 
+    storedCalls.reset(null);
+
     if (calls.length > 0) {
-        callsTBody.innerHTML = calls.map((c) => {
-            const kop = Number(c.cost_kop || 0);
-            const tariff = c.tariff
-                ? `${escapeHtml(c.tariff.prefix)} → ${escapeHtml(c.tariff.destination)} (p=${escapeHtml(c.tariff.priority)})`
-                : "";
+        callsTBody.innerHTML = calls.map(callRowHtml).join("");
+        callsDetails.style.display = "block";
+    } else if (report.result_id && Number(report.stored_calls || 0) > 0) {
+        // звонки сохранены на сервере (store_calls): таблица читает их страницами
+        storedCalls.reset(report.result_id);
+    } else {
+        callsDetails.style.display = "none";
+    }
+}
 
-            return `
+function callRowHtml(c) {
+    const kop = Number(c.cost_kop || 0);
+    const tariff = c.tariff
+        ? `${escapeHtml(c.tariff.prefix)} → ${escapeHtml(c.tariff.destination)} (p=${escapeHtml(c.tariff.priority)})`
+        : "";
+
+    return `
         <tr>
           <td>${escapeHtml(c.start_time)}</td>
           <td>${escapeHtml(c.end_time)}</td>
@@ -343,12 +480,80 @@ function renderReport(report) {
           <td>${tariff}</td>
         </tr>
       `;
-        }).join("");
+}
 
-        callsDetails.style.display = "block";
-    } else {
-        callsDetails.style.display = "none";
+// setupStoredCalls показывает звонки результата из GET /api/v1/results/{id}/calls:
+// по странице за раз, с фильтрами и сортировкой на сервере.
+function setupStoredCalls() {
+    const details = qs("callsDetails");
+    const tbody = qs("callsTable").querySelector("tbody");
+    const filters = qs("callsFilters");
+    const subscriber = qs("callsSubscriber");
+    const destination = qs("callsDestination");
+    const sort = qs("callsSort");
+    const unrated = qs("callsUnrated");
+    const info = qs("callsPageInfo");
+    const more = qs("callsMore");
+
+    let resultID = "";
+    let cursor = "";
+    let shown = 0;
+
+    async function loadPage(append) {
+        const params = new URLSearchParams({ limit: "200", sort: sort.value });
+        if (subscriber.value.trim()) params.set("subscriber", subscriber.value.trim());
+        if (destination.value.trim()) params.set("destination", destination.value.trim());
+        if (unrated.checked) params.set("unrated", "true");
+        if (append && cursor) params.set("cursor", cursor);
+
+        more.disabled = true;
+        try {
+            const resp = await fetch(`${API_BASE}/api/v1/results/${encodeURIComponent(resultID)}/calls?${params}`, { cache: "no-store" });
+            const page = await resp.json().catch(() => null);
+            if (!resp.ok) {
+                info.textContent = `Ошибка: ${page?.error?.message || `HTTP ${resp.status}`}`;
+                return;
+            }
+
+            const items = Array.isArray(page.items) ? page.items : [];
+            const html = items.map(callRowHtml).join("");
+            if (append) {
+                tbody.insertAdjacentHTML("beforeend", html);
+                shown += items.length;
+            } else {
+                tbody.innerHTML = html;
+                shown = items.length;
+            }
+
+            cursor = page.next_cursor || "";
+            info.textContent = `Показано ${shown} из ${page.total}`;
+            more.style.display = cursor ? "" : "none";
+        } catch (_) {
+            info.textContent = "Ошибка сети при загрузке звонков";
+        } finally {
+            more.disabled = false;
+        }
     }
+
+    qs("callsApply").addEventListener("click", () => loadPage(false));
+    more.addEventListener("click", () => loadPage(true));
+
+    return {
+        // reset переключает таблицу на результат id; null — звонки пришли в самом отчёте
+        reset(id) {
+            resultID = id || "";
+            cursor = "";
+            filters.style.display = resultID ? "" : "none";
+            more.style.display = "none";
+            info.textContent = "";
+
+            if (resultID) {
+                tbody.innerHTML = "";
+                details.style.display = "block";
+                loadPage(false);
+            }
+        },
+    };
 }
 
 setupReferenceUpload({
@@ -373,5 +578,7 @@ setupReferenceUpload({
     url: `${API_BASE}/api/v1/subscribers`,
 });
 
+const storedCalls = setupStoredCalls();
+
 setupPreparedCDR();
 renderReport(null);